@@ -0,0 +1,302 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+
+	"github.com/ejv2/gahoot/accounts"
+	"github.com/ejv2/gahoot/game"
+	"github.com/ejv2/gahoot/game/results"
+	"github.com/ejv2/gahoot/oidc"
+)
+
+// AccountUserKey is the context key under which the name of the host account
+// to which a request is logged in is stored.
+const AccountUserKey = "account_user"
+
+// Host accounts, the identity provider and the login sessions of both.
+// Accounts is nil if local accounts are disabled, and OIDC is nil if there is
+// no identity provider.
+var (
+	Accounts *accounts.Store
+	OIDC     *oidc.Provider
+	Sessions accounts.Sessions
+)
+
+// loginEnabled reports if hosts have any way to log in.
+func loginEnabled() bool {
+	return Accounts != nil || OIDC != nil
+}
+
+// currentSession returns the login session of the request, if any.
+func currentSession(c *gin.Context) (accounts.Session, bool) {
+	if !loginEnabled() {
+		return accounts.Session{}, false
+	}
+	tok, err := c.Cookie(SessionCookie)
+	if err != nil {
+		return accounts.Session{}, false
+	}
+	return Sessions.Get(tok)
+}
+
+// startSession logs the request in to sess, then sends it on to next.
+func startSession(c *gin.Context, sess accounts.Session, next string) {
+	tok := Sessions.Start(sess)
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(SessionCookie, tok, int(Sessions.Lifetime().Seconds()),
+		"/", "", Config.HasSSL, true)
+	c.Redirect(http.StatusSeeOther, next)
+}
+
+// sessionAuth returns middleware which looks up the host account to which the
+// request is logged in, if any. If required, requests which are not logged in
+// are sent to the login page, or refused if they are not for a page.
+func sessionAuth(required bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !loginEnabled() {
+			return
+		}
+
+		if sess, ok := currentSession(c); ok {
+			c.Set(AccountUserKey, sess.User)
+			return
+		}
+		if !required {
+			return
+		}
+
+		if websocket.IsWebSocketUpgrade(c.Request) {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		c.Redirect(http.StatusSeeOther, "/login?next="+url.QueryEscape(c.Request.URL.RequestURI()))
+		c.Abort()
+	}
+}
+
+// validOwner reports if user could name a host account: either a local account
+// or a user of the identity provider, who is named by email address or by
+// "oidc:" and their subject identifier.
+func validOwner(user string) bool {
+	if strings.HasPrefix(user, "oidc:") || strings.Contains(user, "@") {
+		return !strings.ContainsAny(user, " \t\r\n")
+	}
+	return accounts.ValidUsername(user) == nil
+}
+
+// localPath returns next if it is a path on this server, such that visitors
+// cannot be sent elsewhere after logging in. Otherwise, returns "/create/".
+func localPath(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/create/"
+	}
+	return next
+}
+
+// handleLogin is the handler for "/login"
+//
+// Shows the login form for host accounts. Visitors who are already logged in
+// are sent straight on to the page they asked for.
+func handleLogin(c *gin.Context) {
+	next := localPath(c.Query("next"))
+	if c.GetString(AccountUserKey) != "" {
+		c.Redirect(http.StatusSeeOther, next)
+		return
+	}
+
+	c.HTML(http.StatusOK, "login.gohtml", gin.H{
+		"Next":  next,
+		"Local": Accounts != nil,
+		"OIDC":  OIDC != nil,
+	})
+}
+
+// handleLoginPost is the POST handler for "/login"
+//
+// Checks the submitted credentials and, if correct, starts a new session for
+// the account and sends the host on to the page they asked for.
+func handleLoginPost(c *gin.Context) {
+	if Accounts == nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if !sameOrigin(c.Request) {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	user, next := c.PostForm("user"), localPath(c.PostForm("next"))
+	if err := Accounts.Authenticate(user, c.PostForm("password")); err != nil {
+		log.Printf("Failed login as %q from %s", user, c.ClientIP())
+		c.HTML(http.StatusUnauthorized, "login.gohtml", gin.H{
+			"Next":  next,
+			"User":  user,
+			"Error": true,
+			"Local": true,
+			"OIDC":  OIDC != nil,
+		})
+		return
+	}
+	log.Printf("%s logged in from %s", user, c.ClientIP())
+
+	startSession(c, accounts.Session{User: user}, next)
+}
+
+// handleOIDCLogin is the handler for "/login/oidc"
+//
+// Begins a login through the identity provider, sending the host off to log
+// in there. The state of the login is kept in a cookie, such that only this
+// browser may finish it.
+func handleOIDCLogin(c *gin.Context) {
+	to, state, err := OIDC.Begin(localPath(c.Query("next")))
+	if err != nil {
+		log.Println("beginning login failed:", err)
+		c.HTML(http.StatusServiceUnavailable, "error.gohtml", gin.H{
+			"Title":   "Could not log in",
+			"Message": "Lots of people are logging in. Please try again in a moment.",
+		})
+		return
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(OIDCStateCookie, state, int(oidc.LoginTime.Seconds()),
+		"/login/oidc", "", Config.HasSSL, true)
+	c.Redirect(http.StatusSeeOther, to)
+}
+
+// handleOIDCCallback is the handler for "/login/oidc/callback"
+//
+// Finishes a login once the identity provider sends the host back, starting a
+// new session for them if they are allowed to log in. Hosts named in the
+// configured list of administrators may also use the administration console.
+func handleOIDCCallback(c *gin.Context) {
+	fail := func(status int, msg string) {
+		c.HTML(status, "error.gohtml", gin.H{
+			"Title":   "Could not log in",
+			"Message": msg,
+		})
+	}
+
+	if e := c.Query("error"); e != "" {
+		log.Printf("Login from %s refused by identity provider: %s: %s", c.ClientIP(), e, c.Query("error_description"))
+		fail(http.StatusForbidden, "Your identity provider did not log you in.")
+		return
+	}
+
+	state := c.Query("state")
+	want, err := c.Cookie(OIDCStateCookie)
+	c.SetCookie(OIDCStateCookie, "", -1, "/login/oidc", "", Config.HasSSL, true)
+	if err != nil || subtle.ConstantTimeCompare([]byte(state), []byte(want)) != 1 {
+		fail(http.StatusBadRequest, "This login was started in another browser, or has expired. Please try again.")
+		return
+	}
+
+	id, next, err := OIDC.Finish(c.Request.Context(), state, c.Query("code"))
+	if err != nil {
+		log.Printf("Login from %s failed: %s", c.ClientIP(), err)
+		switch {
+		case errors.Is(err, oidc.ErrDenied):
+			fail(http.StatusForbidden, "Your account may not be used to host games on this server.")
+		case errors.Is(err, oidc.ErrState):
+			fail(http.StatusBadRequest, "This login has expired. Please try again.")
+		default:
+			fail(http.StatusBadGateway, "Something went wrong talking to your identity provider. Please try again later.")
+		}
+		return
+	}
+
+	user := id.Username()
+	sess := accounts.Session{User: user}
+	for _, admin := range Config.OIDCAdmins {
+		if strings.EqualFold(admin, user) {
+			sess.Admin = true
+		}
+	}
+	log.Printf("%s logged in from %s through identity provider (admin: %t)", user, c.ClientIP(), sess.Admin)
+
+	startSession(c, sess, next)
+}
+
+// handleLogout is the POST handler for "/logout"
+//
+// Ends the current session, if any, and sends the visitor home.
+func handleLogout(c *gin.Context) {
+	if !sameOrigin(c.Request) {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	if tok, err := c.Cookie(SessionCookie); err == nil {
+		Sessions.End(tok)
+	}
+	c.SetCookie(SessionCookie, "", -1, "/", "", Config.HasSSL, true)
+	c.Redirect(http.StatusSeeOther, "/")
+}
+
+// handleAccount is the handler for "/account/"
+//
+// Lists the running games created by the logged in host, along with the
+// archived results of their finished games.
+func handleAccount(c *gin.Context) {
+	type listing struct {
+		game.GameInfo
+		Age time.Duration
+	}
+
+	user := c.GetString(AccountUserKey)
+	games := Coordinator.OwnedGames(user, AdminTimeout)
+	dat := struct {
+		User     string
+		Games    []listing
+		Archived bool
+		Results  []results.Result
+	}{User: user, Games: make([]listing, len(games)), Archived: Archive != nil}
+	for i, g := range games {
+		dat.Games[i] = listing{g, time.Since(g.Created).Truncate(time.Second)}
+	}
+
+	if Archive != nil {
+		var err error
+		dat.Results, err = Archive.Owned(user)
+		if err != nil {
+			log.Println("listing results failed:", err)
+		}
+	}
+
+	c.HTML(http.StatusOK, "account.gohtml", dat)
+}
+
+// handleAccountHost is the handler for "/account/host/{PIN}"
+//
+// Hands the host token of a running game to the account which created it, such
+// that its host can carry on from another browser, then sends them to the host
+// page.
+func handleAccountHost(c *gin.Context) {
+	pin, err := game.ParsePin(c.Param("pin"))
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/account/")
+		return
+	}
+
+	g, ok := Coordinator.GetGame(pin)
+	if !ok || g.Owner != c.GetString(AccountUserKey) {
+		c.HTML(http.StatusNotFound, "error.gohtml", gin.H{
+			"Title":   "Game not found",
+			"Message": "This game has ended or was not created by your account.",
+		})
+		return
+	}
+
+	c.SetCookie(HostCookie, g.HostToken, int(g.MaxGameTime.Seconds()),
+		"/play/host/"+g.PIN.String(), "", Config.HasSSL, true)
+	c.Redirect(http.StatusSeeOther, "/play/host/"+g.PIN.String())
+}
@@ -0,0 +1,108 @@
+package accounts
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Account errors.
+var (
+	ErrBadLogin = errors.New("accounts: incorrect username or password")
+	ErrSyntax   = errors.New("accounts: syntax error")
+	ErrUsername = errors.New("accounts: invalid username")
+)
+
+// dummyHash is compared against when logging in as an unknown user, such that
+// unknown users take as long to reject as wrong passwords.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("gahoot"), bcrypt.DefaultCost)
+
+// Store is the set of accounts loaded from a password file. It is not changed
+// once loaded, so is safe for concurrent use.
+type Store struct {
+	hashes map[string][]byte
+}
+
+// Load reads the accounts in the password file at path.
+func Load(path string) (Store, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Store{}, fmt.Errorf("accounts: %w", err)
+	}
+	defer f.Close()
+
+	s := Store{make(map[string][]byte)}
+	sc := bufio.NewScanner(f)
+	for num := 1; sc.Scan(); num++ {
+		l := strings.TrimSpace(sc.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+
+		user, hash, ok := strings.Cut(l, ":")
+		if !ok || ValidUsername(user) != nil {
+			return Store{}, fmt.Errorf("%w: line %d: expected user:hash", ErrSyntax, num)
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return Store{}, fmt.Errorf("%w: line %d: %s", ErrSyntax, num, err)
+		}
+		if _, ok := s.hashes[user]; ok {
+			return Store{}, fmt.Errorf("%w: line %d: duplicate user %q", ErrSyntax, num, user)
+		}
+		s.hashes[user] = []byte(hash)
+	}
+	if err := sc.Err(); err != nil {
+		return Store{}, fmt.Errorf("accounts: %w", err)
+	}
+
+	return s, nil
+}
+
+// Len returns the number of accounts in the store.
+func (s Store) Len() int {
+	return len(s.hashes)
+}
+
+// Authenticate checks that pass is the password of user, returning
+// ErrBadLogin if it is not or user does not exist.
+func (s Store) Authenticate(user, pass string) error {
+	hash, ok := s.hashes[user]
+	if !ok {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(pass))
+		return ErrBadLogin
+	}
+
+	if bcrypt.CompareHashAndPassword(hash, []byte(pass)) != nil {
+		return ErrBadLogin
+	}
+	return nil
+}
+
+// ValidUsername returns ErrUsername if user may not be used as a username.
+// Usernames must be non-empty and contain no whitespace, colons or "@", such
+// that they cannot be mistaken for users of an identity provider, who are
+// named by email address or an "oidc:" prefix.
+func ValidUsername(user string) error {
+	if user == "" || strings.ContainsAny(user, ":@ \t") {
+		return ErrUsername
+	}
+	return nil
+}
+
+// Entry returns the line of a password file which gives user the password
+// pass.
+func Entry(user, pass string) (string, error) {
+	if err := ValidUsername(user); err != nil {
+		return "", err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("accounts: %w", err)
+	}
+
+	return user + ":" + string(hash), nil
+}
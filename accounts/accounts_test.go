@@ -0,0 +1,98 @@
+package accounts
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "passwd")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestAuthenticate(t *testing.T) {
+	alice, err := Entry("alice", "hunter2")
+	if err != nil {
+		t.Fatal("creating entry:", err)
+	}
+	// Hashes written by "htpasswd -B" use the $2y$ prefix
+	bob := "bob:$2y$05$aY0lzlCO3fCfW0xN8t1qAeKtAE7nThjcAEmuNsZ1a2dTeHuvcbOw."
+
+	s, err := Load(writeFile(t, "# hosts\n"+alice+"\n\n"+bob+"\n"))
+	if err != nil {
+		t.Fatal("loading accounts:", err)
+	}
+	if s.Len() != 2 {
+		t.Errorf("loaded %d accounts, expected 2", s.Len())
+	}
+
+	tests := []struct {
+		user, pass string
+		ok         bool
+	}{
+		{"alice", "hunter2", true},
+		{"alice", "hunter3", false},
+		{"bob", "secret", true},
+		{"carol", "hunter2", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		err := s.Authenticate(tt.user, tt.pass)
+		if tt.ok && err != nil {
+			t.Errorf("%s/%s: expected success, got %v", tt.user, tt.pass, err)
+		} else if !tt.ok && !errors.Is(err, ErrBadLogin) {
+			t.Errorf("%s/%s: expected %v, got %v", tt.user, tt.pass, ErrBadLogin, err)
+		}
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	alice, _ := Entry("alice", "hunter2")
+	files := []string{
+		"alice\n",
+		"alice:hunter2\n",
+		":" + alice[len("alice:"):] + "\n",
+		alice + "\n" + alice + "\n",
+		"alice@school.example" + alice[len("alice"):] + "\n",
+	}
+	for _, f := range files {
+		if _, err := Load(writeFile(t, f)); !errors.Is(err, ErrSyntax) {
+			t.Errorf("%q: expected %v, got %v", f, ErrSyntax, err)
+		}
+	}
+
+	if _, err := Entry("a:b", "pass"); !errors.Is(err, ErrUsername) {
+		t.Errorf("expected %v for username with colon, got %v", ErrUsername, err)
+	}
+	if _, err := Entry("alice@school.example", "pass"); !errors.Is(err, ErrUsername) {
+		t.Errorf("expected %v for username with @, got %v", ErrUsername, err)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	s := NewSessions(50 * time.Millisecond)
+	tok := s.Start(Session{User: "alice", Admin: true})
+	if sess, ok := s.Get(tok); !ok || sess.User != "alice" || !sess.Admin {
+		t.Errorf("got session %+v, %t, expected alice as admin", sess, ok)
+	}
+	if _, ok := s.Get("not a token"); ok {
+		t.Error("unknown token accepted")
+	}
+
+	s.End(tok)
+	if _, ok := s.Get(tok); ok {
+		t.Error("ended session still valid")
+	}
+
+	tok = s.Start(Session{User: "bob"})
+	time.Sleep(100 * time.Millisecond)
+	if _, ok := s.Get(tok); ok {
+		t.Error("expired session still valid")
+	}
+}
@@ -0,0 +1,12 @@
+// Package accounts implements optional local accounts for game hosts, and the
+// login sessions which they use to prove who they are. Sessions are also used
+// by hosts who log in through an identity provider instead.
+//
+// Accounts are read from a password file of "user:hash" lines, one account
+// per line, where each hash is a bcrypt hash of the password. This is the
+// format written by "htpasswd -B", so such files may be shared with other web
+// servers. Blank lines and lines beginning with '#' are ignored.
+//
+// Sessions are held in memory only, so every host must log in again after the
+// server restarts.
+package accounts
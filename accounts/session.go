@@ -0,0 +1,90 @@
+package accounts
+
+import (
+	crand "crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// SessionTime is the default time for which a login session lasts.
+const SessionTime = time.Hour * 12
+
+// Session is one logged in user.
+type Session struct {
+	User string
+	// Admin is set if the user may also use the administration console.
+	Admin bool
+
+	expires time.Time
+}
+
+// Sessions tracks the login sessions of every user, each identified by a
+// secret token. It is safe for concurrent use.
+type Sessions struct {
+	mut      *sync.Mutex
+	sessions map[string]Session
+	lifetime time.Duration
+}
+
+// NewSessions returns an empty set of sessions, each of which lasts for
+// lifetime. If lifetime is zero, defaults to SessionTime.
+func NewSessions(lifetime time.Duration) Sessions {
+	if lifetime == 0 {
+		lifetime = SessionTime
+	}
+
+	return Sessions{
+		mut:      new(sync.Mutex),
+		sessions: make(map[string]Session),
+		lifetime: lifetime,
+	}
+}
+
+// Lifetime returns the time for which each session lasts.
+func (s Sessions) Lifetime() time.Duration {
+	return s.lifetime
+}
+
+// Start starts sess, returning its token. Expired sessions are removed.
+func (s Sessions) Start(sess Session) string {
+	var buf [16]byte
+	if _, err := crand.Read(buf[:]); err != nil {
+		panic("sessions: system random source failed: " + err.Error())
+	}
+	tok := hex.EncodeToString(buf[:])
+
+	now := time.Now()
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	for t, old := range s.sessions {
+		if now.After(old.expires) {
+			delete(s.sessions, t)
+		}
+	}
+	sess.expires = now.Add(s.lifetime)
+	s.sessions[tok] = sess
+
+	return tok
+}
+
+// Get returns the session with the given token, or false if there is no such
+// session or it has expired.
+func (s Sessions) Get(tok string) (Session, bool) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	sess, ok := s.sessions[tok]
+	if !ok || time.Now().After(sess.expires) {
+		return Session{}, false
+	}
+	return sess, true
+}
+
+// End ends the session with the given token.
+func (s Sessions) End(tok string) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	delete(s.sessions, tok)
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ejv2/gahoot/game"
+	"github.com/ejv2/gahoot/game/quiz"
+)
+
+// AdminTimeout is the time for which the administration console waits on each
+// game runner before giving up on it.
+const AdminTimeout = time.Second
+
+// AdminUserKey is the context key under which the name of the authenticated
+// administrator is stored.
+const AdminUserKey = "admin_user"
+
+// Audit is the log of every action taken by an administrator.
+var Audit *AuditLog
+
+// AuditLog records actions taken in the administration console, one JSON
+// object per line, such that they can be reviewed later. It is safe for
+// concurrent use.
+type AuditLog struct {
+	mut *sync.Mutex
+	w   io.Writer
+}
+
+// AuditEntry is one action recorded in the audit log.
+type AuditEntry struct {
+	Time   time.Time `json:"time"`
+	User   string    `json:"user"`
+	Addr   string    `json:"addr"`
+	Action string    `json:"action"`
+	Target string    `json:"target,omitempty"`
+	Result string    `json:"result"`
+}
+
+// OpenAuditLog opens the audit log at path for appending, creating it if
+// needed. If path is empty, entries are written to the server log.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	if path == "" {
+		return &AuditLog{new(sync.Mutex), log.Writer()}, nil
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLog{new(sync.Mutex), f}, nil
+}
+
+// Record appends an entry for an action taken by the administrator making
+// request c.
+func (a *AuditLog) Record(c *gin.Context, action, target, result string) {
+	buf, _ := json.Marshal(AuditEntry{
+		Time:   time.Now(),
+		User:   c.GetString(AdminUserKey),
+		Addr:   c.ClientIP(),
+		Action: action,
+		Target: target,
+		Result: result,
+	})
+
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	if _, err := a.w.Write(append(buf, '\n')); err != nil {
+		log.Println("writing audit log failed:", err)
+	}
+}
+
+// adminAuth returns middleware which requires either a login session with
+// administrator rights or HTTP basic authentication as the configured
+// administrator. If pass is empty, only the former is accepted. Requests which
+// change anything must also come from a page on this server, such that other
+// sites cannot make use of credentials remembered by the browser.
+func adminAuth(user, pass string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sess, loggedIn := currentSession(c)
+		if loggedIn && sess.Admin {
+			c.Set(AdminUserKey, sess.User)
+		} else if pass == "" {
+			switch {
+			case loggedIn:
+				c.Set(AdminUserKey, sess.User)
+				Audit.Record(c, "login", "", "denied")
+				c.HTML(http.StatusForbidden, "error.gohtml", gin.H{
+					"Title":   "Not an administrator",
+					"Message": "You are logged in as " + sess.User + ", who may not use the administration console.",
+				})
+				c.Abort()
+			case c.Request.Method == http.MethodGet:
+				c.Redirect(http.StatusSeeOther, "/login?next="+url.QueryEscape(c.Request.URL.RequestURI()))
+				c.Abort()
+			default:
+				c.AbortWithStatus(http.StatusUnauthorized)
+			}
+			return
+		} else {
+			u, p, ok := c.Request.BasicAuth()
+			userOK := subtle.ConstantTimeCompare([]byte(u), []byte(user)) == 1
+			passOK := subtle.ConstantTimeCompare([]byte(p), []byte(pass)) == 1
+			if !ok || !userOK || !passOK {
+				if ok {
+					c.Set(AdminUserKey, u)
+					Audit.Record(c, "login", "", "denied")
+				}
+				c.Header("WWW-Authenticate", `Basic realm="Gahoot administration", charset="UTF-8"`)
+				c.AbortWithStatus(http.StatusUnauthorized)
+				return
+			}
+			c.Set(AdminUserKey, u)
+		}
+
+		if c.Request.Method != http.MethodGet && !sameOrigin(c.Request) {
+			Audit.Record(c, c.Request.URL.Path, "", "cross-origin request denied")
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		c.Next()
+	}
+}
+
+// sameOrigin reports if r was made by a page served from the same host. Either
+// the Origin or the Referer header must be present and match.
+func sameOrigin(r *http.Request) bool {
+	src := r.Header.Get("Origin")
+	if src == "" {
+		src = r.Referer()
+	}
+	u, err := url.Parse(src)
+	return err == nil && src != "" && u.Host == r.Host
+}
+
+// adminReply finishes an administrative action. Requests from the console are
+// sent back to the page at back, whereas API clients are given a JSON status.
+func adminReply(c *gin.Context, status int, back, msg string) {
+	if c.ContentType() == "application/x-www-form-urlencoded" {
+		c.Redirect(http.StatusSeeOther, back)
+		return
+	}
+
+	c.JSON(status, gin.H{"message": msg})
+}
+
+// adminPlayer describes a player for the administration console.
+type adminPlayer struct {
+	game.PlayerInfo
+	Addr      string `json:"addr"`
+	Connected bool   `json:"connected"`
+	Pending   bool   `json:"pending"`
+	Removed   bool   `json:"removed"`
+	Banned    bool   `json:"banned"`
+}
+
+// adminGame is the detailed view of one game for the administration console.
+type adminGame struct {
+	game.GameInfo
+	Question      int           `json:"question"`
+	Questions     int           `json:"questions"`
+	HostConnected bool          `json:"host_connected"`
+	PlayerList    []adminPlayer `json:"player_list"`
+}
+
+// inspectGame fetches the details of the game with PIN param. If it cannot,
+// returns an error and the HTTP status with which to report it.
+func inspectGame(param string) (adminGame, int, error) {
+	pin, err := game.ParsePin(param)
+	if err != nil {
+		return adminGame{}, http.StatusBadRequest, err
+	}
+	g, ok := Coordinator.GetGame(pin)
+	if !ok {
+		return adminGame{}, http.StatusNotFound, game.ErrorNoGame
+	}
+
+	st, err := g.Inspect(AdminTimeout)
+	if errors.Is(err, game.ErrorNoGame) {
+		return adminGame{}, http.StatusNotFound, err
+	} else if err != nil {
+		return adminGame{}, http.StatusServiceUnavailable, err
+	}
+
+	ag := adminGame{
+		GameInfo: game.GameInfo{
+			PIN:      g.PIN,
+			Title:    g.Title,
+			Created:  g.Created,
+			HostAddr: Coordinator.HostAddr(pin),
+			Status:   st.Status,
+		},
+		Question:      st.CurrentQuestion + 1,
+		Questions:     len(g.Questions),
+		HostConnected: st.Host != nil && st.Host.Connected,
+	}
+	for _, plr := range st.Players {
+		if !plr.Removed {
+			ag.Players++
+		}
+		ag.PlayerList = append(ag.PlayerList, adminPlayer{
+			PlayerInfo: plr.Info(),
+			Addr:       plr.Address(),
+			Connected:  plr.Connected,
+			Pending:    plr.Pending,
+			Removed:    plr.Removed,
+			Banned:     plr.Banned,
+		})
+	}
+
+	return ag, http.StatusOK, nil
+}
+
+// handleAdmin is the handler for "/admin/"
+//
+// Shows the administration console, listing every running game and every quiz
+// in the library.
+func handleAdmin(c *gin.Context) {
+	type listing struct {
+		game.GameInfo
+		Age time.Duration
+	}
+
+	games := Coordinator.ListGames(AdminTimeout)
+	dat := struct {
+		Games   []listing
+		Quizzes []quiz.Entry
+	}{make([]listing, len(games)), QuizManager.Entries()}
+	for i, g := range games {
+		dat.Games[i] = listing{g, time.Since(g.Created).Truncate(time.Second)}
+	}
+
+	c.HTML(http.StatusOK, "admin.gohtml", dat)
+}
+
+// handleAdminGame is the handler for "/admin/game/{PIN}"
+//
+// Shows the details of a single running game and its players.
+func handleAdminGame(c *gin.Context) {
+	ag, status, err := inspectGame(c.Param("pin"))
+	if err != nil {
+		c.HTML(status, "error.gohtml", gin.H{
+			"Title":   "Game unavailable",
+			"Message": err.Error(),
+		})
+		return
+	}
+
+	c.HTML(http.StatusOK, "admin_game.gohtml", ag)
+}
+
+// handleAdminGamesAPI is the handler for "/admin/api/games"
+//
+// Lists every running game as JSON.
+func handleAdminGamesAPI(c *gin.Context) {
+	c.JSON(http.StatusOK, Coordinator.ListGames(AdminTimeout))
+}
+
+// handleAdminGameAPI is the handler for "/admin/api/games/{PIN}"
+//
+// Returns the details of a single running game as JSON.
+func handleAdminGameAPI(c *gin.Context) {
+	ag, status, err := inspectGame(c.Param("pin"))
+	if err != nil {
+		c.JSON(status, gin.H{"message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, ag)
+}
+
+// handleAdminQuizzesAPI is the handler for "/admin/api/quizzes"
+//
+// Lists every quiz in the library, including those which are hidden, as JSON.
+func handleAdminQuizzesAPI(c *gin.Context) {
+	type entry struct {
+		Hash     string    `json:"hash"`
+		Title    string    `json:"title"`
+		Category string    `json:"category"`
+		Remote   bool      `json:"remote"`
+		Hidden   bool      `json:"hidden"`
+		Loaded   time.Time `json:"loaded"`
+	}
+
+	ents := QuizManager.Entries()
+	out := make([]entry, len(ents))
+	for i, e := range ents {
+		out[i] = entry{e.Hash, e.Title, e.FriendlyCategory(), e.Remote(), e.Hidden, e.Loaded}
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// handleAdminEndGame is the POST handler for "/admin/game/{PIN}/end"
+//
+// Forcibly ends a running game.
+func handleAdminEndGame(c *gin.Context) {
+	target := "game " + c.Param("pin")
+	pin, err := game.ParsePin(c.Param("pin"))
+	if err != nil {
+		Audit.Record(c, "end game", target, err.Error())
+		adminReply(c, http.StatusBadRequest, "/admin/", err.Error())
+		return
+	}
+	g, ok := Coordinator.GetGame(pin)
+	if !ok {
+		Audit.Record(c, "end game", target, game.ErrorNoGame.Error())
+		adminReply(c, http.StatusNotFound, "/admin/", game.ErrorNoGame.Error())
+		return
+	}
+
+	if err := g.Submit(game.EndGame{Reason: "ended by administrator", Clean: false}, AdminTimeout); err != nil {
+		status := http.StatusServiceUnavailable
+		if errors.Is(err, game.ErrorNoGame) {
+			status = http.StatusNotFound
+		}
+		Audit.Record(c, "end game", target, err.Error())
+		adminReply(c, status, "/admin/", err.Error())
+		return
+	}
+
+	log.Println("Administrator", c.GetString(AdminUserKey), "ended game", pin)
+	Audit.Record(c, "end game", target, "ok")
+	adminReply(c, http.StatusOK, "/admin/", "game ended")
+}
+
+// handleAdminHideQuiz is the POST handler for "/admin/quiz/{HASH}/hide"
+//
+// Hides a quiz from the quiz listing, or shows it again if the "hidden" form
+// value is "false".
+func handleAdminHideQuiz(c *gin.Context) {
+	hash := c.Param("hash")
+	hidden := c.PostForm("hidden") != "false"
+	action := "hide quiz"
+	if !hidden {
+		action = "show quiz"
+	}
+
+	if !QuizManager.SetHidden(hash, hidden) {
+		Audit.Record(c, action, "quiz "+hash, "not found")
+		adminReply(c, http.StatusNotFound, "/admin/", "no such quiz")
+		return
+	}
+
+	Audit.Record(c, action, "quiz "+hash, "ok")
+	adminReply(c, http.StatusOK, "/admin/", "ok")
+}
+
+// handleAdminUnloadQuiz is the POST handler for "/admin/quiz/{HASH}/unload"
+//
+// Removes a quiz from the library. Games already using it are unaffected. A
+// quiz loaded from disk returns when the quiz directory is next reloaded.
+func handleAdminUnloadQuiz(c *gin.Context) {
+	hash := c.Param("hash")
+	if !QuizManager.Unload(hash) {
+		Audit.Record(c, "unload quiz", "quiz "+hash, "not found")
+		adminReply(c, http.StatusNotFound, "/admin/", "no such quiz")
+		return
+	}
+
+	Audit.Record(c, "unload quiz", "quiz "+hash, "ok")
+	adminReply(c, http.StatusOK, "/admin/", "ok")
+}
+
+// handleAdminReload is the POST handler for "/admin/quiz/reload"
+//
+// Reloads every quiz from the quiz directory.
+func handleAdminReload(c *gin.Context) {
+	qs, err := QuizManager.ReloadDir(Config.QuizPath)
+	if _, ok := err.(quiz.LoadDirError); err != nil && !ok {
+		Audit.Record(c, "reload quizzes", Config.QuizPath, err.Error())
+		adminReply(c, http.StatusInternalServerError, "/admin/", err.Error())
+		return
+	}
+
+	result := "ok"
+	if err != nil {
+		for _, elem := range err.(quiz.LoadDirError) {
+			log.Println("WARNING:", elem)
+		}
+		result = "loaded with warnings"
+	}
+	log.Printf("Reloaded %d quizzes from disk", len(qs))
+	Audit.Record(c, "reload quizzes", Config.QuizPath, result)
+	adminReply(c, http.StatusOK, "/admin/", result)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+
+	"github.com/ejv2/gahoot/game"
+	"github.com/ejv2/gahoot/game/nick"
+	"github.com/ejv2/gahoot/game/quiz"
+)
+
+// hostedGame creates a running game, created from addr, and returns it along
+// with a function which connects a host to it. The host stays connected until
+// the returned connection is closed.
+func hostedGame(t *testing.T, addr string) (game.Game, func() *websocket.Conn) {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/api/host/:pin", handleHostAPI)
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close)
+
+	Coordinator = game.NewCoordinator(game.Settings{Nicknames: nick.NewPolicy(0, 0, nil)})
+	q := quiz.Quiz{
+		Title: "Test quiz",
+		Questions: []quiz.Question{{
+			Title:    "Question",
+			Duration: 10,
+			Answers:  []quiz.Answer{{Title: "Right", Correct: true}, {Title: "Wrong"}},
+		}},
+	}
+	g, err := Coordinator.CreateGame(q, game.Options{}, addr, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { g.Submit(game.EndGame{}, time.Second) })
+
+	return g, func() *websocket.Conn {
+		url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/api/host/" + g.PIN.String()
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.WriteMessage(websocket.TextMessage, []byte("host "+strconv.Quote(g.HostToken))); err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+}
+
+// waitFor calls cond until it returns true, failing the test if it has not
+// within a few seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	for end := time.Now().Add(5 * time.Second); !cond(); {
+		if time.Now().After(end) {
+			t.Fatal("timed out waiting for", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAdminHostConnected(t *testing.T) {
+	g, connect := hostedGame(t, "127.0.0.1")
+	connected := func() bool {
+		ag, _, err := inspectGame(g.PIN.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ag.HostConnected
+	}
+
+	if connected() {
+		t.Error("host connected before connecting")
+	}
+	conn := connect()
+	waitFor(t, "host to connect", connected)
+
+	// The host is lost while the game is being inspected, which must
+	// not race with the game runner
+	conn.Close()
+	waitFor(t, "host to disconnect", func() bool { return !connected() })
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"log"
-	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
@@ -38,14 +37,14 @@ func handlePlayAPI(c *gin.Context) {
 	if param == "" {
 		log.Panic("handlePlayApi: no PIN parameter in required handler")
 	}
-	pin, err := strconv.ParseUint(param, 10, 32)
+	pin, err := game.ParsePin(param)
 	if err != nil {
 		c.AbortWithStatus(400)
 		log.Println("API error:", err)
 		return
 	}
 
-	g, ok := Coordinator.GetGame(game.Pin(pin))
+	g, ok := Coordinator.GetGame(pin)
 	if !ok {
 		c.AbortWithStatus(404)
 		return
@@ -60,7 +59,7 @@ func handlePlayAPI(c *gin.Context) {
 	log.Println("Got websocket play request from", conn.RemoteAddr(), "for", pin)
 
 	// Hand off to the game runner, when its ready
-	g.Action <- game.ConnectPlayer{Conn: conn}
+	g.Action <- game.ConnectPlayer{Conn: conn, Addr: c.ClientIP()}
 }
 
 // handleHostApi is the handler for "/api/host/{PIN}"
@@ -72,24 +71,33 @@ func handlePlayAPI(c *gin.Context) {
 // NOTE: At this stage, no validation is performed. HOWEVER, this action will fail
 // if:
 //   - The game does not exist
+//   - Hosts must log in, and the game was created by another account
+//   - The host token sent in the handshake is incorrect
+//   - The game already has a connected host
 //
 // This websocket lasts the lifetime of a game. If the client disconnects for any
-// reason (missed hearbeats or manual disconnect), the game will be immediately
-// cancelled.
+// reason (missed hearbeats or manual disconnect), the game is held for the
+// configured grace period, during which the host may reconnect with its token
+// and resume. After this, the game is cancelled.
 func handleHostAPI(c *gin.Context) {
 	param := c.Param("pin")
-	pin, err := strconv.ParseUint(param, 10, 32)
+	pin, err := game.ParsePin(param)
 	if err != nil {
 		c.AbortWithStatus(400)
 		log.Println("API error:", err)
 		return
 	}
 
-	g, ok := Coordinator.GetGame(game.Pin(pin))
+	g, ok := Coordinator.GetGame(pin)
 	if !ok {
 		c.AbortWithStatus(404)
 		return
 	}
+	// Games created by an account may only be hosted by that account
+	if Config.LoginHost && g.Owner != "" && g.Owner != c.GetString(AccountUserKey) {
+		c.AbortWithStatus(403)
+		return
+	}
 
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
@@ -100,3 +108,76 @@ func handleHostAPI(c *gin.Context) {
 
 	g.Action <- game.ConnectHost{Conn: conn}
 }
+
+// handleWatchAPI is the handler for "/api/watch/{PIN}"
+//
+// Accepts an incoming request to spectate a game for a specific game PIN and
+// hands off to the game runner. Spectators receive a copy of everything sent
+// to the host, but may not send any commands.
+//
+// NOTE: At this stage, no validation is performed. HOWEVER, this action will fail
+// if:
+//   - The game does not exist
+//   - The watch token sent in the handshake is incorrect
+//   - The game already has the maximum number of spectators
+func handleWatchAPI(c *gin.Context) {
+	param := c.Param("pin")
+	pin, err := game.ParsePin(param)
+	if err != nil {
+		c.AbortWithStatus(400)
+		log.Println("API error:", err)
+		return
+	}
+
+	g, ok := Coordinator.GetGame(pin)
+	if !ok {
+		c.AbortWithStatus(404)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Println("watch api failure:", err)
+		c.Abort()
+		return
+	}
+
+	g.Action <- game.ConnectSpectator{Conn: conn}
+}
+
+// handleRemoteAPI is the handler for "/api/remote/{PIN}"
+//
+// Accepts an incoming request to remotely control a game for a specific game
+// PIN and hands off to the game runner.
+//
+// NOTE: At this stage, no validation is performed. HOWEVER, this action will fail
+// if:
+//   - The game does not exist
+//   - The remote token sent in the handshake is incorrect
+//
+// Any existing remote for the game is disconnected. Losing the remote has no
+// effect on the game.
+func handleRemoteAPI(c *gin.Context) {
+	param := c.Param("pin")
+	pin, err := game.ParsePin(param)
+	if err != nil {
+		c.AbortWithStatus(400)
+		log.Println("API error:", err)
+		return
+	}
+
+	g, ok := Coordinator.GetGame(pin)
+	if !ok {
+		c.AbortWithStatus(404)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Println("remote api failure:", err)
+		c.Abort()
+		return
+	}
+
+	g.Action <- game.ConnectRemote{Conn: conn}
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ejv2/gahoot/game"
+	"github.com/ejv2/gahoot/game/quiz"
+	"github.com/ejv2/gahoot/game/results"
+)
+
+// APIClientKey is the context key under which the name of the API client
+// making a request is stored.
+const APIClientKey = "api_client"
+
+// OpenAPI is the OpenAPI description of the JSON API, as served to clients.
+var OpenAPI []byte
+
+// loadOpenAPI reads the OpenAPI description of the JSON API at path, pointing
+// it at the API on this server.
+func loadOpenAPI(path string) ([]byte, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(buf, &doc); err != nil {
+		return nil, err
+	}
+	doc["servers"] = []gin.H{{"url": strings.TrimSuffix(Config.SiteLink, "/") + "/api/v1"}}
+
+	return json.Marshal(doc)
+}
+
+// apiAddr returns the address under which games created by the API client
+// name are recorded, such that each client is limited as one host.
+func apiAddr(name string) string {
+	return "api:" + name
+}
+
+// apiError aborts the request with a JSON error message.
+func apiError(c *gin.Context, status int, msg string) {
+	c.AbortWithStatusJSON(status, gin.H{"message": msg})
+}
+
+// apiAuth returns middleware which requires a bearer token matching one of
+// keys, which maps client names to their keys.
+func apiAuth(keys map[string]string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tok := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+
+		// Every key is compared, such that timing reveals nothing
+		client := ""
+		for name, key := range keys {
+			if subtle.ConstantTimeCompare([]byte(tok), []byte(key)) == 1 {
+				client = name
+			}
+		}
+		if tok == "" || client == "" {
+			c.Header("WWW-Authenticate", `Bearer realm="Gahoot API"`)
+			apiError(c, http.StatusUnauthorized, "missing or invalid API key")
+			return
+		}
+
+		c.Set(APIClientKey, client)
+		c.Next()
+	}
+}
+
+// apiGame looks up the game with the PIN in the request, aborting the request
+// if there is no such game or it was not created by the requesting client.
+func apiGame(c *gin.Context) (game.Game, bool) {
+	pin, err := game.ParsePin(c.Param("pin"))
+	if err != nil {
+		apiError(c, http.StatusBadRequest, "invalid game PIN")
+		return game.Game{}, false
+	}
+
+	// Games of other clients are not found, rather than forbidden, such
+	// that PINs cannot be probed
+	g, ok := Coordinator.GetGame(pin)
+	if !ok || Coordinator.HostAddr(pin) != apiAddr(c.GetString(APIClientKey)) {
+		apiError(c, http.StatusNotFound, "game not found or has ended")
+		return game.Game{}, false
+	}
+
+	return g, true
+}
+
+// apiStatus returns the name of s used by the JSON API.
+func apiStatus(s game.Status) string {
+	switch s {
+	case game.GameHostWaiting:
+		return "host_waiting"
+	case game.GameWaiting:
+		return "lobby"
+	case game.GameRunning:
+		return "running"
+	default:
+		return "ended"
+	}
+}
+
+// apiGameStatus is the status of one game, as returned by the JSON API.
+type apiGameStatus struct {
+	PIN           game.Pin  `json:"pin"`
+	Title         string    `json:"title"`
+	Created       time.Time `json:"created"`
+	Owner         string    `json:"owner,omitempty"`
+	Busy          bool      `json:"busy"`
+	Status        string    `json:"status,omitempty"`
+	Question      int       `json:"question,omitempty"`
+	Questions     int       `json:"questions,omitempty"`
+	HostConnected bool      `json:"host_connected"`
+	Players       int       `json:"players"`
+}
+
+// apiPlayer describes one player, as returned by the JSON API.
+type apiPlayer struct {
+	game.PlayerInfo
+	Connected bool `json:"connected"`
+	Pending   bool `json:"pending"`
+}
+
+// apiCreateRequest is the body of a request to create a game. Exactly one of
+// QuizHash and Quiz must be given.
+type apiCreateRequest struct {
+	QuizHash string          `json:"quiz_hash"`
+	Quiz     json.RawMessage `json:"quiz"`
+	Options  struct {
+		ApproveJoins  bool `json:"approve_joins"`
+		GenerateNames bool `json:"generate_names"`
+	} `json:"options"`
+	Owner string `json:"owner"`
+}
+
+// handleAPICreateGame is the POST handler for "/api/v1/games"
+//
+// Creates a new game from a quiz in the library or one given in the request,
+// returning its PIN, the links to join and host it and the host token.
+func handleAPICreateGame(c *gin.Context) {
+	client := c.GetString(APIClientKey)
+
+	var req apiCreateRequest
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, quiz.MaxQuizSize+4096)
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		apiError(c, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if req.Owner != "" && !validOwner(req.Owner) {
+		apiError(c, http.StatusBadRequest, "invalid owner")
+		return
+	}
+
+	var q quiz.Quiz
+	switch {
+	case req.QuizHash != "" && len(req.Quiz) > 0:
+		apiError(c, http.StatusBadRequest, "only one of quiz_hash and quiz may be given")
+		return
+	case req.QuizHash != "":
+		var ok bool
+		if q, ok = QuizManager.GetString(req.QuizHash); !ok {
+			apiError(c, http.StatusNotFound, "quiz not found")
+			return
+		}
+	case len(req.Quiz) > 0:
+		var err error
+		if q, err = quiz.LoadQuiz(bytes.NewReader(req.Quiz), quiz.SourceUpload); err != nil {
+			apiError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+	default:
+		apiError(c, http.StatusBadRequest, "one of quiz_hash and quiz must be given")
+		return
+	}
+	if err := q.Validate(); err != nil {
+		apiError(c, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	g, err := Coordinator.CreateGame(q, game.Options{
+		ApproveJoins:  req.Options.ApproveJoins,
+		GenerateNames: req.Options.GenerateNames,
+	}, apiAddr(client), req.Owner)
+	if err != nil {
+		log.Println("Refusing to create game for API client", client+":", err)
+		switch {
+		case errors.Is(err, game.ErrorTooManyHostGames):
+			apiError(c, http.StatusTooManyRequests, "too many games running for this API key")
+		case errors.Is(err, game.ErrorDraining):
+			apiError(c, http.StatusServiceUnavailable, "server is restarting")
+		case errors.Is(err, game.ErrorNoPins):
+			apiError(c, http.StatusServiceUnavailable, "no game PINs free")
+		default:
+			apiError(c, http.StatusServiceUnavailable, "server is running too many games")
+		}
+		return
+	}
+	log.Println("Creating new game", g.PIN, "from quiz", q.String()[:12], "for API client", client)
+
+	site := strings.TrimSuffix(Config.SiteLink, "/")
+	pin := g.PIN.String()
+	resp := gin.H{
+		"pin":        g.PIN,
+		"title":      g.Title,
+		"join_url":   joinLink(g.PIN),
+		"host_url":   site + "/play/host/" + pin + "?key=" + g.HostToken,
+		"host_token": g.HostToken,
+		"watch_url":  site + "/play/watch/" + pin + "?key=" + g.WatchToken,
+		"remote_url": site + "/play/remote/" + pin + "?key=" + g.RemoteToken,
+	}
+	if Archive != nil {
+		resp["id"] = g.ID
+		resp["results_url"] = site + resultsLink(g.PIN, g.ID)
+	}
+
+	c.JSON(http.StatusCreated, resp)
+}
+
+// handleAPIGames is the handler for "/api/v1/games"
+//
+// Lists the running games created by the requesting client.
+func handleAPIGames(c *gin.Context) {
+	addr := apiAddr(c.GetString(APIClientKey))
+
+	out := make([]apiGameStatus, 0)
+	for _, g := range Coordinator.ListGames(AdminTimeout) {
+		if g.HostAddr != addr {
+			continue
+		}
+
+		st := apiGameStatus{
+			PIN:     g.PIN,
+			Title:   g.Title,
+			Created: g.Created,
+			Owner:   g.Owner,
+			Busy:    g.Busy,
+			Players: g.Players,
+		}
+		if !g.Busy {
+			st.Status = apiStatus(g.Status)
+		}
+		out = append(out, st)
+	}
+
+	c.JSON(http.StatusOK, out)
+}
+
+// inspectAPIGame fetches the state of g, aborting the request if it cannot.
+func inspectAPIGame(c *gin.Context, g game.Game) (game.State, bool) {
+	st, err := g.Inspect(AdminTimeout)
+	if errors.Is(err, game.ErrorNoGame) {
+		apiError(c, http.StatusNotFound, "game not found or has ended")
+		return st, false
+	} else if err != nil {
+		apiError(c, http.StatusServiceUnavailable, "game is busy, try again")
+		return st, false
+	}
+
+	return st, true
+}
+
+// handleAPIGame is the handler for "/api/v1/games/{PIN}"
+//
+// Returns the status of a single game created by the requesting client.
+func handleAPIGame(c *gin.Context) {
+	g, ok := apiGame(c)
+	if !ok {
+		return
+	}
+	st, ok := inspectAPIGame(c, g)
+	if !ok {
+		return
+	}
+
+	out := apiGameStatus{
+		PIN:           g.PIN,
+		Title:         g.Title,
+		Created:       g.Created,
+		Owner:         g.Owner,
+		Status:        apiStatus(st.Status),
+		Questions:     len(g.Questions),
+		HostConnected: st.Host != nil && st.Host.Connected,
+	}
+	if st.Status == game.GameRunning {
+		out.Question = st.CurrentQuestion + 1
+	}
+	for _, plr := range st.Players {
+		if !plr.Removed {
+			out.Players++
+		}
+	}
+
+	c.JSON(http.StatusOK, out)
+}
+
+// handleAPIPlayers is the handler for "/api/v1/games/{PIN}/players"
+//
+// Lists the players of a single game created by the requesting client, along
+// with their scores. Players removed by the host are left out.
+func handleAPIPlayers(c *gin.Context) {
+	g, ok := apiGame(c)
+	if !ok {
+		return
+	}
+	st, ok := inspectAPIGame(c, g)
+	if !ok {
+		return
+	}
+
+	out := make([]apiPlayer, 0, len(st.Players))
+	for _, plr := range st.Players {
+		if plr.Removed {
+			continue
+		}
+		out = append(out, apiPlayer{
+			PlayerInfo: plr.Info(),
+			Connected:  plr.Connected,
+			Pending:    plr.Pending,
+		})
+	}
+
+	c.JSON(http.StatusOK, out)
+}
+
+// handleAPIResults is the handler for "/api/v1/results/{PIN}/{game ID}"
+//
+// Returns the archived results of a finished game. As on the results page,
+// knowing the game ID is what grants access.
+func handleAPIResults(c *gin.Context) {
+	if Archive == nil {
+		apiError(c, http.StatusNotFound, "results are not archived on this server")
+		return
+	}
+
+	res, err := Archive.Load(c.Param("pin"), c.Param("id"))
+	if err != nil {
+		if !errors.Is(err, results.ErrNotFound) && !errors.Is(err, results.ErrInvalid) {
+			log.Println("loading results failed:", err)
+		}
+		apiError(c, http.StatusNotFound, "results not found or have expired")
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// handleAPIQuizzes is the handler for "/api/v1/quizzes"
+//
+// Lists the quizzes in the library from which games may be created. Hidden
+// quizzes are left out.
+func handleAPIQuizzes(c *gin.Context) {
+	type entry struct {
+		Hash        string `json:"hash"`
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		Author      string `json:"author"`
+		Category    string `json:"category"`
+		Questions   int    `json:"questions"`
+	}
+
+	out := make([]entry, 0)
+	for _, e := range QuizManager.Entries() {
+		if e.Hidden {
+			continue
+		}
+		out = append(out, entry{e.Hash, e.Title, e.Description, e.Author, e.FriendlyCategory(), len(e.Questions)})
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// handleOpenAPI is the handler for "/api/v1/openapi.json"
+//
+// Serves the OpenAPI description of the JSON API.
+func handleOpenAPI(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json; charset=utf-8", OpenAPI)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAPIHostConnected(t *testing.T) {
+	g, connect := hostedGame(t, apiAddr("test"))
+
+	router := gin.New()
+	router.GET("/api/v1/games/:pin", apiAuth(map[string]string{"test": "secret"}), handleAPIGame)
+	connected := func() bool {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/games/"+g.PIN.String(), nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		router.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("got %d: %s", w.Code, w.Body)
+		}
+
+		var out apiGameStatus
+		if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+			t.Fatal(err)
+		}
+		return out.HostConnected
+	}
+
+	if connected() {
+		t.Error("host connected before connecting")
+	}
+	conn := connect()
+	waitFor(t, "host to connect", connected)
+
+	// The host is lost while the game is being fetched, which must not
+	// race with the game runner
+	conn.Close()
+	waitFor(t, "host to disconnect", func() bool { return !connected() })
+}
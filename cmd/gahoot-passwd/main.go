@@ -0,0 +1,46 @@
+// Command gahoot-passwd prints a line for the host accounts file which gives a
+// user the password read from standard input.
+//
+// Usage:
+//
+//	gahoot-passwd <user> >> accounts
+//
+// The password is the first line of standard input. Any existing line for the
+// same user must be removed from the file, as duplicate users are refused.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ejv2/gahoot/accounts"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: gahoot-passwd <user>")
+		os.Exit(2)
+	}
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	r := bufio.NewReader(os.Stdin)
+	pass, err := r.ReadString('\n')
+	if err != nil && pass == "" {
+		fmt.Fprintln(os.Stderr, "gahoot-passwd: no password given")
+		os.Exit(1)
+	}
+	pass = strings.TrimRight(pass, "\r\n")
+	if pass == "" {
+		fmt.Fprintln(os.Stderr, "gahoot-passwd: password may not be empty")
+		os.Exit(1)
+	}
+
+	line, err := accounts.Entry(os.Args[1], pass)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gahoot-passwd:", err)
+		os.Exit(1)
+	}
+	fmt.Println(line)
+}
@@ -0,0 +1,137 @@
+// Command gahoot-replay replays a game from its event log, showing how the
+// state of the game changed with each action. It is used to settle disputes
+// over what happened during a game, and to reproduce bugs in the game runner.
+//
+// Usage:
+//
+//	gahoot-replay [-messages] <event log>
+//
+// Each action is printed with the time it was performed, followed by every
+// change it made to the game. The replay stops with an error if the game does
+// not reach the same state as was recorded in the log.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ejv2/gahoot/game"
+	"github.com/ejv2/gahoot/game/events"
+)
+
+var messages = flag.Bool("messages", false, "print every message sent to a client")
+
+// summary describes the parts of a game state which are printed when they
+// change.
+type summary struct {
+	game    string
+	players []string
+}
+
+func summarise(st game.State) summary {
+	s := summary{
+		game: fmt.Sprintf("status %s, question %d", st.Status, st.CurrentQuestion+1),
+	}
+	for _, p := range st.Players {
+		var flags []string
+		if p.Connected {
+			flags = append(flags, "connected")
+		}
+		if p.Pending {
+			flags = append(flags, "pending")
+		}
+		if p.Removed {
+			flags = append(flags, "removed")
+		}
+		if p.Banned {
+			flags = append(flags, "banned")
+		}
+
+		s.players = append(s.players, fmt.Sprintf("player %d %q: score %d, %d correct, streak %d [%s]",
+			p.ID, p.Nick, p.Score, p.Correct, p.Streak, strings.Join(flags, " ")))
+	}
+
+	return s
+}
+
+// diff prints every line of cur which has changed since prev.
+func diff(prev, cur summary) {
+	if cur.game != prev.game {
+		fmt.Println("\t" + cur.game)
+	}
+	for i, p := range cur.players {
+		if i >= len(prev.players) || p != prev.players[i] {
+			fmt.Println("\t" + p)
+		}
+	}
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: gahoot-replay [-messages] <event log>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gahoot-replay:", err)
+		os.Exit(1)
+	}
+	evs, err := events.Read(f)
+	f.Close()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gahoot-replay:", err)
+		os.Exit(1)
+	}
+
+	r, err := game.NewReplay(evs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gahoot-replay:", err)
+		os.Exit(1)
+	}
+	g := r.Game()
+	fmt.Printf("Game %s (%q, %d questions), started %s\n",
+		g.PIN, g.Title, len(g.Questions), evs[0].Time.Format(time.RFC1123))
+
+	prev := summarise(r.State())
+	for {
+		ev, err := r.Step()
+		if err == io.EOF {
+			break
+		}
+
+		stamp := ev.Time.Format("15:04:05.000")
+		switch ev.Kind {
+		case events.KindAction:
+			fmt.Printf("%s #%d %s %s", stamp, ev.Seq, ev.Type, ev.Data)
+			if len(ev.Draws) > 0 {
+				fmt.Printf(" (drew %q)", ev.Draws)
+			}
+			fmt.Println()
+		case events.KindSend:
+			if *messages {
+				fmt.Printf("%s #%d -> %s: %s\n", stamp, ev.Seq, ev.To, ev.Data)
+			}
+		case events.KindEnd:
+			fmt.Printf("%s #%d game ended\n", stamp, ev.Seq)
+		}
+
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "gahoot-replay:", err)
+			os.Exit(1)
+		}
+
+		cur := summarise(r.State())
+		diff(prev, cur)
+		prev = cur
+	}
+}
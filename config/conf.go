@@ -8,6 +8,7 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -25,9 +26,55 @@ type Config struct {
 
 	QuizPath string `validate:"dir"`
 
-	GameTimeout time.Duration
+	GameTimeout         time.Duration
+	HostGrace           time.Duration `validate:"gte=0"`
+	ClaimTimeout        time.Duration `validate:"gte=0"`
+	PinCooldown         time.Duration `validate:"gte=0"`
+	PinLength           int           `validate:"omitempty,min=6,max=10"`
+	LatencyCompensation bool
+
+	NickMinLength int    `validate:"gte=0"`
+	NickMaxLength int    `validate:"gte=0"`
+	NickBlocklist string `validate:"omitempty,file"`
+
+	MaxGames     int `validate:"gte=0"`
+	MaxHostGames int `validate:"gte=0"`
+	MaxPlayers   int `validate:"gte=0"`
+	MaxPending   int `validate:"gte=0"`
+
+	ResultsDir       string
+	ResultsRetention time.Duration `validate:"gte=0"`
+
+	EventLogDir string
+
+	SnapshotDir      string
+	SnapshotInterval time.Duration `validate:"gte=0"`
+
+	DrainTimeout time.Duration `validate:"gte=0"`
+
+	AdminUser     string `validate:"required_with=AdminPassword"`
+	AdminPassword string
+	AdminAuditLog string
+
+	AccountsFile string
+	LoginCreate  bool
+	LoginHost    bool
+
+	OIDCIssuer       string `validate:"omitempty,url"`
+	OIDCClientID     string `validate:"required_with=OIDCIssuer"`
+	OIDCClientSecret string
+	OIDCDomains      []string
+	OIDCGroupClaim   string
+	OIDCGroups       []string
+	OIDCAdmins       []string
+
+	// APIKeys maps the name of each API client to its key.
+	APIKeys map[string]string `validate:"dive,min=16"`
 }
 
+// ErrNoLogin is returned if hosts must log in, but there is no way to do so.
+var ErrNoLogin = errors.New("config: login_create and login_host need accounts_file or oidc_issuer")
+
 // FullAddr returns the full address for use in serving based on both
 // ListenAddr and ListenPort in the format expected by net.Dial.
 func (c Config) FullAddr() string {
@@ -53,6 +100,9 @@ func New(path string, validator *validator.Validate) (Config, error) {
 	if err != nil {
 		return c, err
 	}
+	if (c.LoginCreate || c.LoginHost) && c.AccountsFile == "" && c.OIDCIssuer == "" {
+		return c, ErrNoLogin
+	}
 
 	return c, nil
 }
@@ -67,6 +67,36 @@ scanloop:
 	return ret, nil
 }
 
+// parseKeys parses entries of the form "name:key" into a map of names to keys.
+// Empty entries are skipped.
+func parseKeys(entries []string) (map[string]string, error) {
+	keys := make(map[string]string, len(entries))
+	for _, elem := range entries {
+		if elem == "" {
+			continue
+		}
+
+		name, key, ok := strings.Cut(elem, ":")
+		name, key = strings.TrimSpace(name), strings.TrimSpace(key)
+		if !ok || name == "" || key == "" {
+			return nil, errors.New("expected name:key")
+		}
+		if _, ok := keys[name]; ok {
+			return nil, fmt.Errorf("duplicate key name %q", name)
+		}
+		keys[name] = key
+	}
+
+	return keys, nil
+}
+
+// parseBool returns true if trail is an affirmative boolean value ("true" or
+// "yes"). Anything else is considered false.
+func parseBool(trail string) bool {
+	lc := strings.ToLower(trail)
+	return lc == "true" || lc == "yes"
+}
+
 // parse advances through the config file, extracting one config key per line.
 // Keys are separated from content by a single ':' (colon). Any UTF-8 text can
 // be placed around the colon and will be handled.
@@ -107,9 +137,82 @@ func parse(c *Config, path string) error {
 		case "game_timeout":
 			i, e := strconv.ParseInt(trail, 10, 32)
 			c.GameTimeout, err = time.Second*time.Duration(i), e
+		case "host_grace":
+			i, e := strconv.ParseInt(trail, 10, 32)
+			c.HostGrace, err = time.Second*time.Duration(i), e
+		case "pin_length":
+			c.PinLength, err = strconv.Atoi(trail)
+		case "pin_cooldown":
+			i, e := strconv.ParseInt(trail, 10, 32)
+			c.PinCooldown, err = time.Second*time.Duration(i), e
+		case "claim_timeout":
+			i, e := strconv.ParseInt(trail, 10, 32)
+			c.ClaimTimeout, err = time.Second*time.Duration(i), e
+		case "latency_compensation":
+			c.LatencyCompensation = parseBool(trail)
+		case "nick_min":
+			c.NickMinLength, err = strconv.Atoi(trail)
+		case "nick_max":
+			c.NickMaxLength, err = strconv.Atoi(trail)
+		case "nick_blocklist":
+			c.NickBlocklist = trail
+		case "max_games":
+			c.MaxGames, err = strconv.Atoi(trail)
+		case "max_games_per_addr":
+			c.MaxHostGames, err = strconv.Atoi(trail)
+		case "max_players":
+			c.MaxPlayers, err = strconv.Atoi(trail)
+		case "max_pending":
+			c.MaxPending, err = strconv.Atoi(trail)
+		case "results_dir":
+			c.ResultsDir = trail
+		case "results_retention":
+			i, e := strconv.ParseInt(trail, 10, 32)
+			c.ResultsRetention, err = 24*time.Hour*time.Duration(i), e
+		case "event_log_dir":
+			c.EventLogDir = trail
+		case "snapshot_dir":
+			c.SnapshotDir = trail
+		case "snapshot_interval":
+			i, e := strconv.ParseInt(trail, 10, 32)
+			c.SnapshotInterval, err = time.Second*time.Duration(i), e
+		case "admin_user":
+			c.AdminUser = trail
+		case "admin_password":
+			c.AdminPassword = trail
+		case "admin_audit_log":
+			c.AdminAuditLog = trail
+		case "accounts_file":
+			c.AccountsFile = trail
+		case "login_create":
+			c.LoginCreate = parseBool(trail)
+		case "login_host":
+			c.LoginHost = parseBool(trail)
+		case "oidc_issuer":
+			c.OIDCIssuer = trail
+		case "oidc_client_id":
+			c.OIDCClientID = trail
+		case "oidc_client_secret":
+			c.OIDCClientSecret = trail
+		case "oidc_domains":
+			c.OIDCDomains, err = parseArray(s, &num, trail)
+		case "oidc_group_claim":
+			c.OIDCGroupClaim = trail
+		case "oidc_groups":
+			c.OIDCGroups, err = parseArray(s, &num, trail)
+		case "oidc_admins":
+			c.OIDCAdmins, err = parseArray(s, &num, trail)
+		case "api_keys":
+			var arr []string
+			arr, err = parseArray(s, &num, trail)
+			if err == nil {
+				c.APIKeys, err = parseKeys(arr)
+			}
+		case "drain_timeout":
+			i, e := strconv.ParseInt(trail, 10, 32)
+			c.DrainTimeout, err = time.Second*time.Duration(i), e
 		case "ssl":
-			lc := strings.ToLower(trail)
-			c.HasSSL = (lc == "true" || lc == "yes")
+			c.HasSSL = parseBool(trail)
 		default:
 			err = fmt.Errorf("unknown key: %q", key)
 		}
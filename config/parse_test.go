@@ -66,3 +66,43 @@ func TestParseArray(t *testing.T) {
 		}
 	}
 }
+
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		src     []string
+		expects map[string]string
+		err     string
+	}{
+		{nil, map[string]string{}, ""},
+		{[]string{""}, map[string]string{}, ""},
+		{[]string{"lms:0123456789abcdef"}, map[string]string{"lms": "0123456789abcdef"}, ""},
+		{[]string{"lms: abc:def ", "bot:xyz"}, map[string]string{"lms": "abc:def", "bot": "xyz"}, ""},
+
+		{[]string{"lms"}, nil, "expected name:key"},
+		{[]string{":key"}, nil, "expected name:key"},
+		{[]string{"lms:"}, nil, "expected name:key"},
+		{[]string{"lms:a", "lms:b"}, nil, "duplicate"},
+	}
+
+	for _, elem := range tests {
+		keys, err := parseKeys(elem.src)
+		if elem.err != "" {
+			if err == nil || !strings.Contains(err.Error(), elem.err) {
+				t.Errorf("%q: expected error %q, got %v", elem.src, elem.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", elem.src, err)
+			continue
+		}
+		if len(keys) != len(elem.expects) {
+			t.Errorf("%q: got %v, expected %v", elem.src, keys, elem.expects)
+		}
+		for name, key := range elem.expects {
+			if keys[name] != key {
+				t.Errorf("%q: got key %q for %s, expected %q", elem.src, keys[name], name, key)
+			}
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
@@ -8,6 +9,7 @@ import (
 	"github.com/gin-gonic/gin"
 
 	"github.com/ejv2/gahoot/game"
+	"github.com/ejv2/gahoot/game/nick"
 	"github.com/ejv2/gahoot/game/quiz"
 )
 
@@ -25,11 +27,23 @@ func handleRoot(c *gin.Context) {
 // back to this page with an error or to the game page with the pin filled out.
 func handleJoin(c *gin.Context) {
 	dat := struct {
-		Pin        game.Pin
-		PinValid   bool
-		PinPresent bool
-		JoinError  bool
-	}{}
+		Pin         game.Pin
+		PinValid    bool
+		PinPresent  bool
+		PinMistyped bool
+		JoinError   string
+		MinLength   int
+		MaxLength   int
+	}{
+		MinLength: Config.NickMinLength,
+		MaxLength: Config.NickMaxLength,
+	}
+	if dat.MinLength == 0 {
+		dat.MinLength = nick.DefaultMinLength
+	}
+	if dat.MaxLength == 0 {
+		dat.MaxLength = nick.DefaultMaxLength
+	}
 	// Aliases for landing pages.
 	joinPin := func() {
 		c.HTML(200, "join.gohtml", dat)
@@ -42,18 +56,14 @@ func handleJoin(c *gin.Context) {
 	// which puts us back on stage one with an error
 	if p := c.Query("pin"); p != "" {
 		dat.PinPresent = true
-		i, err := strconv.ParseUint(p, 10, 32)
+		pin, err := game.ParsePin(p)
 		if err != nil {
 			dat.PinValid = false
+			dat.PinMistyped = errors.Is(err, game.ErrorPinMistyped)
 			joinPin()
 			return
 		}
-		dat.Pin = game.Pin(i)
-		if dat.Pin < 1 {
-			dat.PinValid = false
-			joinPin()
-			return
-		}
+		dat.Pin = pin
 
 		g, ok := Coordinator.GetGame(dat.Pin)
 		if !ok {
@@ -64,28 +74,37 @@ func handleJoin(c *gin.Context) {
 
 		// If nick provided, second stage completed.
 		// Validate nickname with game rules and then request the game
-		// runner add a new player.
-		if n := c.Query("nick"); n != "" {
+		// runner add a new player. Games which generate names skip
+		// the second stage entirely, unless coming back from a failed
+		// join, which would otherwise only fail again.
+		dat.JoinError = c.Query("error")
+		if n := c.Query("nick"); n != "" || (g.GenerateNames && dat.JoinError == "") {
 			// Notify running game instance
-			act := game.AddPlayer{Nick: n, ID: make(chan int, 1)}
+			prev, _ := c.Cookie(PlayerCookie + p)
+			act := game.AddPlayer{
+				Nick:   n,
+				Addr:   c.ClientIP(),
+				Token:  prev,
+				Result: make(chan game.AddResult, 1),
+			}
 			g.Action <- act
-			id := int64(<-act.ID)
+			res := <-act.Result
 
 			// Error signalled. Fail the join request.
-			if id < 0 {
-				c.Redirect(http.StatusSeeOther, "/join?pin="+strconv.FormatUint(uint64(dat.Pin), 10)+"&error=duplicate")
+			if res.Err != nil {
+				c.Redirect(http.StatusSeeOther, "/join?pin="+strconv.FormatUint(uint64(dat.Pin), 10)+"&error="+joinErrorCode(res.Err))
 				return
 			}
 
-			c.Redirect(http.StatusSeeOther,
-				"/play/game/"+p+"?plr="+strconv.FormatInt(id, 10))
+			// Session token is kept in a cookie for this game, never
+			// in the URL, so it cannot leak through history or a
+			// shared link
+			c.SetCookie(PlayerCookie+p, res.Token, int(g.MaxGameTime.Seconds()),
+				"/", "", Config.HasSSL, true)
+			c.Redirect(http.StatusSeeOther, "/play/game/"+p)
 			return
 		}
 
-		if e := c.Query("error"); e != "" {
-			dat.JoinError = true
-		}
-
 		joinNick()
 		return
 	}
@@ -93,11 +112,41 @@ func handleJoin(c *gin.Context) {
 	joinPin()
 }
 
+// joinErrorCode returns the code passed back to the join page to explain why
+// a nickname was rejected.
+func joinErrorCode(err error) string {
+	switch {
+	case errors.Is(err, game.ErrorNickTaken):
+		return "duplicate"
+	case errors.Is(err, nick.ErrEmpty):
+		return "empty"
+	case errors.Is(err, nick.ErrTooShort):
+		return "short"
+	case errors.Is(err, nick.ErrTooLong):
+		return "long"
+	case errors.Is(err, nick.ErrBlocked):
+		return "blocked"
+	case errors.Is(err, game.ErrorBanned):
+		return "banned"
+	case errors.Is(err, game.ErrorGameFull):
+		return "full"
+	case errors.Is(err, game.ErrorJoinBusy):
+		return "busy"
+	default:
+		return "unknown"
+	}
+}
+
 // handleCreate is the handler for "/create/"
 //
 // Shows a page of links to different methods of creating a game.
 func handleCreate(c *gin.Context) {
-	c.HTML(200, "create.gohtml", nil)
+	dat := struct {
+		Accounts bool
+		User     string
+	}{loginEnabled(), c.GetString(AccountUserKey)}
+
+	c.HTML(200, "create.gohtml", dat)
 }
 
 // handleFind is the handler for "/create/find"
@@ -141,8 +190,9 @@ func handleEditor(c *gin.Context) {
 
 // handleCreateGame is the handler for "/create/game/{HASH}"
 //
-// Creates and stores a new game based on the stored hash from the game manager.
-// If the hash is not found, redirects back to "/new/find".
+// Creates and stores a new game based on the stored hash from the game manager,
+// with any game options given as query parameters. If the hash is not found,
+// redirects back to "/new/find".
 func handleCreateGame(c *gin.Context) {
 	hash := c.Param("hash")
 	if hash == "" {
@@ -156,12 +206,48 @@ func handleCreateGame(c *gin.Context) {
 		return
 	}
 
-	g := Coordinator.CreateGame(q)
+	g, err := Coordinator.CreateGame(q, game.Options{
+		ApproveJoins:  c.Query("approve") != "",
+		GenerateNames: c.Query("names") != "",
+	}, c.ClientIP(), c.GetString(AccountUserKey))
+	if err != nil {
+		log.Println("Refusing to create game for", c.ClientIP()+":", err)
+		handleCreateError(c, err)
+		return
+	}
 	log.Println("Creating new game", g.PIN, "from quiz", q.String()[:12])
 
+	// Host token is kept in a cookie scoped to this game's host page, such
+	// that a reloaded host page can reclaim the game
+	c.SetCookie(HostCookie, g.HostToken, int(g.MaxGameTime.Seconds()),
+		"/play/host/"+g.PIN.String(), "", Config.HasSSL, true)
 	c.Redirect(http.StatusSeeOther, "/play/host/"+g.PIN.String())
 }
 
+// handleCreateError shows a page explaining why a game could not be created.
+func handleCreateError(c *gin.Context, err error) {
+	dat := struct {
+		Title, Message string
+	}{Title: "Could not start game"}
+
+	status := http.StatusServiceUnavailable
+	switch {
+	case errors.Is(err, game.ErrorTooManyGames):
+		dat.Message = "This server is running as many games as it can. Please try again later."
+	case errors.Is(err, game.ErrorTooManyHostGames):
+		status = http.StatusTooManyRequests
+		dat.Message = "You already have too many games running. Finish one of them before starting another."
+	case errors.Is(err, game.ErrorDraining):
+		dat.Message = "This server is restarting. Please try again in a few minutes."
+	case errors.Is(err, game.ErrorNoPins):
+		dat.Message = "There are no game PINs left to give out. Please try again later."
+	default:
+		dat.Message = "Something went wrong. Please try again later."
+	}
+
+	c.HTML(status, "error.gohtml", dat)
+}
+
 // handleBlankCreateGame is the handler for "/create/game")
 //
 // Redirects confused visitors back to where they probably want to be.
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ejv2/gahoot/game"
+	"github.com/ejv2/gahoot/game/nick"
+	"github.com/ejv2/gahoot/game/quiz"
+)
+
+// joinGeneratedGame creates a game which generates player names under settings
+// and returns its join link, along with a function to request a path from the
+// join handler.
+func joinGeneratedGame(t *testing.T, settings game.Settings) (string, func(string) *httptest.ResponseRecorder) {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.LoadHTMLGlob(PathTemplates + "/*")
+	router.GET("/join", handleJoin)
+
+	settings.Nicknames = nick.NewPolicy(0, 0, nil)
+	Coordinator = game.NewCoordinator(settings)
+	q := quiz.Quiz{
+		Title: "Test quiz",
+		Questions: []quiz.Question{{
+			Title:    "Question",
+			Duration: 10,
+			Answers:  []quiz.Answer{{Title: "Right", Correct: true}, {Title: "Wrong"}},
+		}},
+	}
+	g, err := Coordinator.CreateGame(q, game.Options{GenerateNames: true}, "127.0.0.1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return "/join?pin=" + g.PIN.String(), func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		return w
+	}
+}
+
+func TestJoinGeneratedError(t *testing.T) {
+	join, get := joinGeneratedGame(t, game.Settings{})
+
+	// Players join straight away with a generated name
+	w := get(join)
+	if w.Code != http.StatusSeeOther || !strings.HasPrefix(w.Header().Get("Location"), "/play/game/") {
+		t.Fatalf("join: got %d to %q, expected game page", w.Code, w.Header().Get("Location"))
+	}
+
+	// Coming back from a failed join must show the error rather than
+	// trying to join again, which would only redirect back here forever
+	w = get(join + "&error=duplicate")
+	if w.Code != http.StatusOK {
+		t.Fatalf("join error page: got %d to %q, expected page", w.Code, w.Header().Get("Location"))
+	}
+	if !strings.Contains(w.Body.String(), `class="error"`) {
+		t.Error("join error page does not show the error")
+	}
+}
+
+func TestJoinGeneratedFull(t *testing.T) {
+	join, get := joinGeneratedGame(t, game.Settings{MaxPlayers: 1})
+
+	// The first player joins straight away with a generated name
+	w := get(join)
+	if w.Code != http.StatusSeeOther || !strings.HasPrefix(w.Header().Get("Location"), "/play/game/") {
+		t.Fatalf("first join: got %d to %q, expected game page", w.Code, w.Header().Get("Location"))
+	}
+
+	// The second is turned away as the game is full, and is shown why
+	w = get(join)
+	loc := w.Header().Get("Location")
+	if w.Code != http.StatusSeeOther || loc != join+"&error=full" {
+		t.Fatalf("second join: got %d to %q, expected join error", w.Code, loc)
+	}
+	w = get(loc)
+	if w.Code != http.StatusOK {
+		t.Fatalf("join error page: got %d to %q, expected page", w.Code, w.Header().Get("Location"))
+	}
+	if !strings.Contains(w.Body.String(), `class="error"`) {
+		t.Error("join error page does not show the error")
+	}
+}
+
+func TestHostPageToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.LoadHTMLGlob(PathTemplates + "/*")
+	router.GET("/play/host/:pin", handleHost)
+
+	Coordinator = game.NewCoordinator(game.Settings{Nicknames: nick.NewPolicy(0, 0, nil)})
+	q := quiz.Quiz{
+		Title: "Test quiz",
+		Questions: []quiz.Question{{
+			Title:    "Question",
+			Duration: 10,
+			Answers:  []quiz.Answer{{Title: "Right", Correct: true}, {Title: "Wrong"}},
+		}},
+	}
+	g, err := Coordinator.CreateGame(q, game.Options{}, "127.0.0.1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(tok string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/play/host/"+g.PIN.String(), nil)
+		r.AddCookie(&http.Cookie{Name: HostCookie, Value: tok})
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	// Anybody without the host token must be turned away before the page
+	// hands out the spectator and remote control tokens
+	for _, tok := range []string{"", "guess"} {
+		w := get(tok)
+		if w.Code != http.StatusSeeOther {
+			t.Errorf("host token %q: got %d, expected redirect", tok, w.Code)
+		}
+		if strings.Contains(w.Body.String(), g.WatchToken) {
+			t.Errorf("host token %q: watch token shown", tok)
+		}
+		if strings.Contains(w.Body.String(), g.RemoteToken) {
+			t.Errorf("host token %q: remote token shown", tok)
+		}
+	}
+
+	w := get(g.HostToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("host token: got %d to %q, expected page", w.Code, w.Header().Get("Location"))
+	}
+	if !strings.Contains(w.Body.String(), g.WatchToken) {
+		t.Error("watch token not shown to host")
+	}
+	if !strings.Contains(w.Body.String(), g.RemoteToken) {
+		t.Error("remote token not shown to host")
+	}
+}
@@ -8,6 +8,8 @@
 // Endpoint locations
 export const PlayEndpoint = ws_proto + "://" + location.host + "/api/play/"
 export const HostEndpoint = ws_proto + "://" + location.host + "/api/host/"
+export const WatchEndpoint = ws_proto + "://" + location.host + "/api/watch/"
+export const RemoteEndpoint = ws_proto + "://" + location.host + "/api/remote/"
 
 // Common icon resource paths
 export const iconpath: string = "/static/assets/"
@@ -19,9 +21,27 @@ export const icons: string[] = [
 ]
 
 // GameMessage represents a message received over the websocket channel
+//
+// Messages which must be acknowledged carry a sequence number
 export interface GameMessage {
     action: string
     data: any
+    seq?: number
+}
+
+// Parses a raw websocket message of the form "verb[#seq] body"
+export function ParseMessage(raw: string): GameMessage {
+    let [verb, ...rest]: string[] = raw.split(" ")
+    let [action, seq]: string[] = verb.split("#")
+
+    let msg: GameMessage = {
+        action: action,
+        data: JSON.parse(rest.join(" ")),
+    }
+    if (seq) {
+        msg.seq = parseInt(seq)
+    }
+    return msg
 }
 
 export interface PlayerData {
@@ -43,6 +63,87 @@ export interface GameState<T> {
     (this: T, ev: GameMessage): GameState<T>
 }
 
+// ClockData is the server's response to a clock synchronisation request
+export interface ClockData {
+    client: number
+    server: number
+}
+
+// Number of clock samples taken in quick succession when first connecting
+const ClockBurst = 5
+// Time between clock samples after the initial burst
+const ClockInterval = 30 * 1000
+
+// Clock estimates the offset between the local clock and the server's clock
+//
+// This works in the same way as NTP: we send our local time, the server
+// replies with ours and theirs and the offset is estimated assuming that the
+// reply took half of the round trip to reach us. Samples with the lowest round
+// trip have the smallest error, so are trusted over others.
+export class Clock {
+    private offset: number
+    private bestRTT: number
+    private ws: WebSocket | null
+    private hndl: number
+
+    constructor() {
+        this.offset = 0
+        this.bestRTT = Infinity
+        this.ws = null
+        this.hndl = 0
+    }
+
+    // Begins sending synchronisation requests over ws
+    //
+    // Must only be called once the server has accepted our handshake. May be
+    // called again with a new connection after reconnecting.
+    start(ws: WebSocket): void {
+        this.ws = ws
+
+        for (let i = 0; i < ClockBurst; i++) {
+            window.setTimeout(() => {this.sample()}, i * 500)
+        }
+        if (this.hndl == 0) {
+            this.hndl = window.setInterval(() => {this.sample()}, ClockInterval)
+        }
+    }
+
+    // Sends a single synchronisation request
+    private sample(): void {
+        if (this.ws && this.ws.readyState == WebSocket.OPEN) {
+            SendMessage(this.ws, "clk", Date.now())
+        }
+    }
+
+    // Handles a clock synchronisation response from the server
+    handle(data: ClockData): void {
+        let now = Date.now()
+        let rtt = now - data.client
+        if (rtt < 0) {
+            return
+        }
+
+        // Let old samples age, such that drift is eventually corrected
+        this.bestRTT *= 1.1
+        if (rtt > this.bestRTT) {
+            return
+        }
+
+        this.bestRTT = rtt
+        this.offset = data.server + (rtt / 2) - now
+    }
+
+    // Returns the current time on the server in milliseconds
+    now(): number {
+        return Date.now() + this.offset
+    }
+
+    // Returns the whole number of seconds until the server time "at"
+    remaining(at: number): number {
+        return Math.max(0, Math.round((at - this.now()) / 1000))
+    }
+}
+
 // Sends a properly formatted message over ws
 export function SendMessage(ws: WebSocket, action: string, body: any) {
     ws.send(action + " " + JSON.stringify(body))
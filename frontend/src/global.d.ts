@@ -4,9 +4,12 @@ declare global {
     var Alpine: AlpineType,
 
     // Game details
-    var uid: number
+    var token: string
     var pin: number
     var title: string
+    var host_token: string
+    var watch_token: string
+    var remote_token: string
 
     // Websocket protocol definition.
     // Set by server to support both SSL and non-SSL servers.
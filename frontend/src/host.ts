@@ -12,6 +12,7 @@ import Alpine from "alpinejs"
 // Page lifetime variables
 let conn: WebSocket
 let host: HostState
+let clock: common.Clock
 
 // Set up alpine on the window
 // For debugging purposes
@@ -45,6 +46,47 @@ interface Player extends common.PlayerData {
     loading: boolean
 }
 
+// Full game state sent by the server when we reconnect
+interface AnswerProgress {
+    answered: number
+    eligible: number
+}
+
+interface AnswerSummary extends AnswerProgress {
+    options: {
+        count: number
+        correct: boolean
+    }[]
+}
+
+interface ModEntry {
+    time: number
+    action: string
+    player: string
+    reason?: string
+}
+
+interface RemoteCommand {
+    action: string
+    index?: number
+}
+
+interface ResyncData {
+    stage: string
+    paused: boolean
+    players: (common.PlayerData & {connected: boolean, pending: boolean})[]
+    question: QuestionData | null
+    deadline: number
+    leaderboard: common.PlayerData[] | null
+    answers: AnswerSummary
+    modlog: ModEntry[] | null
+}
+
+// Number of times to try to reconnect to the game before giving up
+const MaxReconnects = 30
+// Close reasons after which reconnecting is pointless
+const FatalCloseReasons = ["invalid host token", "game already has a host"]
+
 // PlayerState is the current datamodel for the client.
 //
 // Any code which mutates the state of the application based
@@ -58,18 +100,34 @@ class HostState {
     stateID: number
 
     connected: boolean
+    reconnecting: boolean
+    private reconnects: number
 
     countdownTitle: string
     countdownFull: boolean
     countdownCount: number
     private countdownHndl: number
+    private countdownMsg: common.GameMessage | null
+
+    paused: boolean
+    jumpTarget: number
+    // Minutes until the game is ended by the server shutting down, or
+    // zero if it is not
+    drainMinutes: number
 
     players: Player[]
+    pendingPlayers: {id: number, name: string}[]
     startError: boolean
 
+    moderating: number
+    modReason: string
+    modLog: ModEntry[]
+
     icons: string[]
     question: QuestionData
     gotAnswers: number
+    eligibleAnswers: number
+    answerSummary: AnswerSummary | null
     questionCountdown: number
     private questionCountdownHndl: number
 
@@ -82,15 +140,27 @@ class HostState {
     // All event handlers must be hooked in init()
     constructor(game: number, title: string) {
         this.connected = false
+        this.reconnecting = false
+        this.reconnects = 0
+        this.drainMinutes = 0
         this.pin = game
         this.title = title
         this.players = []
+        this.pendingPlayers = []
         this.startError = false
 
+        this.moderating = 0
+        this.modReason = ""
+        this.modLog = []
+
         this.countdownTitle = ""
         this.countdownFull = false
         this.countdownCount = 10
         this.countdownHndl = 0
+        this.countdownMsg = null
+
+        this.paused = false
+        this.jumpTarget = 1
 
         this.icons = common.icons
         this.question = {
@@ -102,6 +172,8 @@ class HostState {
             total: 10,
         }
         this.gotAnswers = 0
+        this.eligibleAnswers = 0
+        this.answerSummary = null
         this.questionCountdown = this.question.time
         this.questionCountdownHndl = 0
 
@@ -118,26 +190,57 @@ class HostState {
     // Delegate to methods where appropriate
     init() {
         conn.onopen = () => {this.initConn()}
-        conn.onclose = (ev: CloseEvent) => {this.handleConnection(false);console.log(ev)}
+        conn.onclose = (ev: CloseEvent) => {this.handleClose(ev)}
         conn.onmessage = (e: MessageEvent) => {this.handleMsg(e)}
-        conn.onerror = () => {this.handleConnection(false)}
+        conn.onerror = () => {console.warn("websocket error")}
     }
 
     // Initializes the connection and internal state by sending the ident
     // packets
     initConn() {
-        common.SendMessage(conn, "host", this.pin)
+        common.SendMessage(conn, "host", window.host_token)
         console.log("now hosting game " + this.pin.toString())
+        clock.start(conn)
         this.handleConnection(true)
     }
 
+    // handleClose is called when the websocket is closed by either end
+    handleClose(ev: CloseEvent) {
+        console.log(ev)
+        if (FatalCloseReasons.includes(ev.reason)) {
+            this.reconnects = MaxReconnects
+        }
+
+        this.handleConnection(false)
+    }
+
     // handleConnection is called when a websocket connection changes state
+    //
+    // If the connection was lost, we have a grace period in which to
+    // reconnect and resume the game before it is cancelled
     handleConnection(connected: boolean) {
+        let lost = this.connected || this.reconnecting
+        if (!connected && lost && this.stateID != States.GameOver) {
+            this.reconnect()
+        }
+
         this.connected = connected
+    }
 
-        if (!this.connected && this.stateID != States.GameOver) {
+    // Attempts to reconnect to the game after a short delay
+    reconnect() {
+        if (this.reconnects >= MaxReconnects) {
             document.location.href = "/create/"
+            return
         }
+
+        this.reconnecting = true
+        this.reconnects++
+        window.setTimeout(() => {
+            console.log("attempting reconnect (attempt " + this.reconnects.toString() + ")")
+            conn = new WebSocket(common.HostEndpoint + this.pin.toString())
+            this.init()
+        }, 2000)
     }
 
     // handleMsg is called when a websocket message arrives
@@ -145,10 +248,62 @@ class HostState {
     // This must *only* be used to do parsing and state shifts
     // and must never mutate state itself
     handleMsg(ev: MessageEvent) {
-        let [action, ...rest]: string[] = ev.data.toString().split(" ")
-        let msg: common.GameMessage = {
-            action: action,
-            data: JSON.parse(rest.join(" "))
+        let msg = common.ParseMessage(ev.data.toString())
+
+        // Any message means that the server has accepted us
+        this.reconnecting = false
+        this.reconnects = 0
+
+        // Clock synchronisation and resync can arrive at any time
+        switch (msg.action) {
+            case "clk":
+                clock.handle(<common.ClockData>msg.data)
+                return
+            case "rsync":
+                this.resync(<ResyncData>msg.data)
+                return
+            case "pause":
+                this.paused = true
+                this.stopTimers()
+                return
+            case "resume":
+                this.paused = false
+                this.resumeTimers(msg.data.deadline)
+                return
+            case "dline":
+                if (this.stateID == States.QuestionAsk && !this.paused) {
+                    this.questionCountdown = clock.remaining(msg.data.deadline)
+                }
+                return
+            case "rctl":
+                this.remoteControl(<RemoteCommand>msg.data)
+                return
+            case "drain":
+                this.drainMinutes = Math.max(1, Math.ceil(clock.remaining(msg.data.deadline) / 60))
+                return
+            case "rnplr":
+                this.players.forEach(pl => {
+                    if (pl.id == msg.data.id) {
+                        pl.name = msg.data.name
+                    }
+                })
+                return
+            case "rmplr":
+                console.log("removed player "+msg.data.name)
+                this.players = this.players.filter(pl => pl.id != msg.data.id)
+                this.pendingPlayers = this.pendingPlayers.filter(pl => pl.id != msg.data.id)
+                if (this.moderating == msg.data.id) {
+                    this.moderating = 0
+                }
+                return
+            case "mlog":
+                this.modLog.push(<ModEntry>msg.data)
+                return
+            case "jreq":
+                if (!this.pendingPlayers.some(pl => pl.id == msg.data.id)) {
+                    this.pendingPlayers.push(msg.data)
+                }
+                return
         }
 
         this.state = this.state(msg)
@@ -164,11 +319,6 @@ class HostState {
 
         let plr = <common.PlayerData>ev.data
         switch (ev.action) {
-            // Remove player
-            case "rmplr":
-                console.log("removed player "+plr.name)
-            this.players = this.players.filter(pl => pl.name != plr.name)
-            return this.state
             case "dcplr":
                 // For now, also just remove the player
                 this.players.map(pl => {
@@ -245,32 +395,15 @@ class HostState {
     stateQuestion(ev: common.GameMessage): common.GameState<HostState> {
         switch (ev.action) {
             case "quack":
-                this.stateID = States.QuestionAsk
-                this.questionCountdown = this.question.time
-                this.questionCountdownHndl = window.setInterval(() => {
-                    this.questionCountdown--;
-                    if (this.questionCountdown <= 0) {
-                        this.skip()
-                        return
-                    }
-                }, 1000)
-
-                // Song selection
-                if (this.question.time >= 20) {
-                    res.qmusic_long.play();
-                } else if (this.question.time >= 10) {
-                    res.qmusic.play();
-                } else if (this.question.time >= 5) {
-                    res.qmusic_short.play();
-                } else {
-                    res.qmusic_vshort.play();
-                }
-
+                this.progress(ev.data)
+                this.beginAnswers(ev.data.deadline)
                 return this.state
             case "nans":
-                this.gotAnswers++;
+                this.progress(ev.data)
                 return this.state
             case "qend":
+                this.progress(ev.data)
+                this.answerSummary = <AnswerSummary>ev.data
                 this.stateID = States.QuestionAnswer
                 this.stopAllSongs();
                 res.gong.play();
@@ -296,6 +429,151 @@ class HostState {
         return this.state
     }
 
+    // Updates the count of answers received so far
+    progress(data: AnswerProgress): void {
+        this.gotAnswers = data.answered
+        this.eligibleAnswers = data.eligible
+    }
+
+    // Starts the question timer and music once the server is accepting
+    // answers
+    beginAnswers(deadline?: number): void {
+        this.stateID = States.QuestionAsk
+        this.questionCountdown = this.question.time
+        if (deadline) {
+            this.questionCountdown = clock.remaining(deadline)
+        }
+        clearInterval(this.questionCountdownHndl)
+        this.questionCountdownHndl = window.setInterval(() => {
+            this.questionCountdown--;
+            if (this.questionCountdown <= 0) {
+                this.skip()
+                return
+            }
+        }, 1000)
+
+        // Song selection
+        if (this.question.time >= 20) {
+            res.qmusic_long.play();
+        } else if (this.question.time >= 10) {
+            res.qmusic.play();
+        } else if (this.question.time >= 5) {
+            res.qmusic_short.play();
+        } else {
+            res.qmusic_vshort.play();
+        }
+    }
+
+    // Restores the full game state after reconnecting
+    resync(data: ResyncData): void {
+        console.log("resynchronising at stage " + data.stage)
+
+        clearInterval(this.countdownHndl)
+        clearInterval(this.questionCountdownHndl)
+        this.stopAllSongs()
+
+        this.pendingPlayers = data.players.filter(pl => pl.pending && pl.connected).map(pl => {
+            return {id: pl.id, name: pl.name}
+        })
+        this.players = data.players.filter(pl => !pl.pending).map(pl => {
+            return {
+                id: pl.id,
+                name: pl.name,
+                score: pl.score,
+                correct: pl.correct,
+
+                connected: pl.connected,
+                loading: false,
+            }
+        })
+        if (data.question) {
+            this.question = data.question
+        }
+        this.modLog = data.modlog || []
+        this.progress(data.answers)
+        this.answerSummary = data.answers.options ? data.answers : null
+
+        switch (data.stage) {
+            case "countdown":
+                this.stateID = States.QuestionCountdown
+                this.startCountdown(5, {
+                    action: "sans",
+                    data: {},
+                }, this.question.title)
+                this.state = this.stateQuestion
+                break
+            case "question":
+                this.beginAnswers(data.deadline)
+                this.state = this.stateQuestion
+                break
+            case "results":
+                this.stateID = States.QuestionAnswer
+                this.feedback = data.leaderboard
+                this.feedbackWaiting = false
+                this.state = this.stateFeedback
+                break
+            default:
+                this.stateID = States.JoinWaiting
+                this.state = this.stateWaitingJoin
+                break
+        }
+
+        this.paused = data.paused
+        if (this.paused) {
+            this.stopTimers()
+        }
+    }
+
+    // Performs a command sent from the remote control as if it were
+    // pressed on this screen, provided it makes sense where we are
+    remoteControl(cmd: RemoteCommand): void {
+        console.log("remote control: " + cmd.action)
+
+        switch (cmd.action) {
+            case "start":
+                if (this.stateID == States.JoinWaiting) {
+                    this.startGame()
+                }
+                break
+            case "time":
+                if (this.stateID == States.QuestionAsk) {
+                    this.skip()
+                }
+                break
+            case "next":
+                if (this.stateID == States.QuestionAnswer) {
+                    this.next()
+                }
+                break
+            case "jump":
+                if (cmd.index && this.stateID >= States.QuestionCountdown && this.stateID != States.GameOver) {
+                    this.jump(cmd.index)
+                }
+                break
+        }
+    }
+
+    // Stops all running timers while the game is paused
+    stopTimers(): void {
+        clearInterval(this.countdownHndl)
+        clearInterval(this.questionCountdownHndl)
+        this.stopAllSongs()
+    }
+
+    // Restarts whichever timer was running before the game was paused
+    resumeTimers(deadline: number): void {
+        switch (this.stateID) {
+            case States.QuestionCountdown:
+                if (this.countdownMsg) {
+                    this.startCountdown(this.countdownCount, this.countdownMsg)
+                }
+                break
+            case States.QuestionAsk:
+                this.beginAnswers(deadline)
+                break
+        }
+    }
+
     // FRONTEND FUNCTIONS
     // ------------------
 
@@ -343,6 +621,26 @@ class HostState {
         this.stateID = States.QuestionCountdown
     }
 
+    // Pauses or resumes the game
+    togglePause(): void {
+        common.SendMessage(conn, this.paused ? "resume" : "pause", {})
+    }
+
+    // Grants extra time for the current question
+    extendTime(secs: number): void {
+        common.SendMessage(conn, "extend", secs)
+    }
+
+    // Moves straight to question "index" (one indexed), abandoning the
+    // current question
+    jump(index: number): void {
+        this.stopTimers()
+        this.gotAnswers = 0
+        common.SendMessage(conn, "jump", index)
+        this.state = this.stateQuestionCountdown
+        this.stateID = States.QuestionCountdown
+    }
+
     // Start the visual countdown on screen.
     // If title is provided, the countdown is a "full" countdown, showing
     // an image etc.
@@ -355,24 +653,66 @@ class HostState {
             this.countdownTitle = title
         }
         this.countdownCount = length
+        this.countdownMsg = msg
 
+        clearInterval(this.countdownHndl)
         this.countdownHndl = window.setInterval(() => {
             this.countdownCount--;
             if (this.countdownCount <= 0) {
                 common.SendMessage(conn, msg.action, msg.data)
                 clearInterval(this.countdownHndl)
+                this.countdownMsg = null
             }
         }, 1000)
     }
 
-    // Request the server to kick a player
+    // Admits a player from the waiting room
+    approvePlayer(id: number): void {
+        this.pendingPlayers = this.pendingPlayers.filter(pl => pl.id != id)
+        common.SendMessage(conn, "appr", id)
+    }
+
+    // Turns away a player in the waiting room
+    rejectPlayer(id: number): void {
+        this.pendingPlayers = this.pendingPlayers.filter(pl => pl.id != id)
+        common.SendMessage(conn, "rej", id)
+    }
+
+    // Selects a player to moderate, or deselects them if already selected
+    selectPlayer(id: number): void {
+        this.moderating = this.moderating == id ? 0 : id
+        this.modReason = ""
+    }
+
+    // Requests the server to kick a player, who may then rejoin
     kickPlayer(id: number): void {
+        this.moderate("kick", id)
+    }
+
+    // Requests the server to ban a player, and optionally anybody else
+    // connecting from the same address
+    banPlayer(id: number, addr: boolean): void {
+        this.moderate("ban", id, addr)
+    }
+
+    // Requests the server to replace a player's nickname
+    renamePlayer(id: number): void {
+        this.moderate("ren", id)
+        this.moderating = 0
+    }
+
+    private moderate(action: string, id: number, addr?: boolean): void {
         this.players.map(pl => {
-            if (pl.id == id) {
+            if (pl.id == id && action != "ren") {
                 pl.loading = true
             }
         })
-        common.SendMessage(conn, "kick", id)
+        common.SendMessage(conn, action, {
+            id: id,
+            reason: this.modReason,
+            addr: addr || false,
+        })
+        this.modReason = ""
     }
 
     // Stops all possible question songs
@@ -393,6 +733,7 @@ document.addEventListener("DOMContentLoaded", () => {
 
     // Init our global objects
     host = new HostState(window.pin, window.title)
+    clock = new common.Clock()
 
     // Load information
     let url = common.HostEndpoint + window.pin.toString()
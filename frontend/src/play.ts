@@ -11,6 +11,7 @@ import Alpine from "alpinejs"
 // Page lifetime variables
 let conn: WebSocket
 let plr: PlayerState
+let clock: common.Clock
 
 
 // Possible game state IDs
@@ -23,9 +24,16 @@ enum States {
     Finished
 }
 
+// Number of times to try to reconnect to the game before giving up
+const MaxReconnects = 10
+// Close reasons after which reconnecting is pointless. Moderation reasons
+// may be followed by a message from the host, so are matched by prefix.
+const FatalCloseReasons = ["invalid session token", "player already connected", "ID banned", "rejected by host", "kicked by host", "banned by host", "left game"]
+
 interface CountdownData {
     count: number
     title: string
+    ends?: number
 }
 
 interface QuestionData {
@@ -51,12 +59,23 @@ window.Alpine = Alpine
 // of this class for the changes to be reflected in the DOM.
 class PlayerState {
     private pin: number
-    private uid: number
+    private token: string
 
     private state: common.GameState<PlayerState>
     stateID: States
 
     connected: boolean
+    reconnecting: boolean
+    private reconnects: number
+    private lastSeq: number
+    hostWaiting: boolean
+    approvalPending: boolean
+    nick: string
+    rerolls: number
+    paused: boolean
+    // Minutes until the game is ended by the server shutting down, or
+    // zero if it is not
+    drainMinutes: number
     points: number
     rank: number
 
@@ -74,12 +93,21 @@ class PlayerState {
     //
     // NOTE: Does not do any interaction with events!
     // All event handlers must be hooked in init()
-    constructor(game: number, user: number) {
+    constructor(game: number, token: string) {
         this.connected = false
+        this.reconnecting = false
+        this.reconnects = 0
+        this.lastSeq = 0
+        this.hostWaiting = false
+        this.approvalPending = false
+        this.nick = ""
+        this.rerolls = 0
+        this.paused = false
+        this.drainMinutes = 0
         this.points = this.rank = 0
 
         this.pin = game
-        this.uid = user
+        this.token = token
 
         this.countdownHndl = 0
         this.countdown = 0
@@ -113,40 +141,124 @@ class PlayerState {
     // Delegate to methods where appropriate
     init() {
         conn.onopen = () => {this.initConn()}
-        conn.onclose = (ev: CloseEvent) => {this.handleConnection(false);console.log(ev)}
+        conn.onclose = (ev: CloseEvent) => {this.handleClose(ev)}
         conn.onmessage = (e: MessageEvent) => {this.handleMsg(e)}
-        conn.onerror = () => {this.handleConnection(false)}
+        conn.onerror = () => {console.warn("websocket error")}
     }
 
     // Initializes the connection and internal state by sending the ident
     // packets
     initConn() {
         setTimeout(() => {
-            common.SendMessage(conn, "ident", this.uid)
+            common.SendMessage(conn, "ident", this.token)
             console.log("authenticated to game " + this.pin.toString())
+            clock.start(conn)
             this.handleConnection(true)
+            if (this.reconnecting) {
+                this.reconnecting = false
+                this.reconnects = 0
+                return
+            }
             this.stateID = States.Waiting
         }, 700)
     }
 
+    // handleClose is called when the websocket is closed by either end
+    handleClose(ev: CloseEvent) {
+        console.log(ev)
+        if (FatalCloseReasons.some(r => ev.reason.startsWith(r))) {
+            this.reconnects = MaxReconnects
+        }
+        if (ev.reason.startsWith("kicked by host") || ev.reason.startsWith("banned by host")) {
+            this.removed(ev.reason)
+            return
+        }
+        if (ev.reason == "left game") {
+            window.location.href = "/join"
+            return
+        }
+
+        this.handleConnection(false)
+    }
+
     // handleConnection is called when a websocket connection changes state
+    //
+    // If the connection was lost, we try to resume our slot. Anything we
+    // missed in the meantime is replayed by the server.
     handleConnection(connected: boolean) {
+        let lost = this.connected || this.reconnecting
         this.connected = connected
 
-        if (!this.connected && this.stateID != States.Finished) {
-            window.location.href = "/join";
+        if (!connected && lost && this.stateID != States.Finished) {
+            this.reconnect()
         }
     }
 
+    // Attempts to reconnect to the game after a short delay
+    reconnect() {
+        if (this.reconnects >= MaxReconnects) {
+            window.location.href = "/join"
+            return
+        }
+
+        this.reconnecting = true
+        this.reconnects++
+        window.setTimeout(() => {
+            console.log("attempting reconnect (attempt " + this.reconnects.toString() + ")")
+            conn = new WebSocket(common.PlayEndpoint + this.pin.toString())
+            this.init()
+        }, 1000)
+    }
+
     // handleMsg is called when a websocket message arrives
     //
     // This must *only* be used to do parsing and state shifts
     // and must never mutate state itself
     handleMsg(ev: MessageEvent) {
-        let [action, ...rest]: string[] = ev.data.toString().split(" ")
-        let msg: common.GameMessage = {
-            action: action,
-            data: JSON.parse(rest.join(" "))
+        let msg = common.ParseMessage(ev.data.toString())
+
+        // Numbered messages must be acknowledged. Anything we have already
+        // seen is a replay after reconnecting, so is dropped.
+        if (msg.seq !== undefined) {
+            common.SendMessage(conn, "ack", msg.seq)
+            if (msg.seq <= this.lastSeq) {
+                return
+            }
+            this.lastSeq = msg.seq
+        }
+
+        // Out of band messages can arrive at any time
+        switch (msg.action) {
+            case "clk":
+                clock.handle(<common.ClockData>msg.data)
+                return
+            case "hwait":
+                this.hostWaiting = true
+                return
+            case "hback":
+                this.hostWaiting = false
+                return
+            case "jwait":
+                this.approvalPending = true
+                return
+            case "jok":
+                this.approvalPending = false
+                return
+            case "nick":
+                this.nick = msg.data.name
+                this.rerolls = msg.data.rerolls
+                return
+            case "pause":
+                this.paused = true
+                return
+            case "resume":
+                this.paused = false
+                return
+            case "dline":
+                return
+            case "drain":
+                this.drainMinutes = Math.max(1, Math.ceil(clock.remaining(msg.data.deadline) / 60))
+                return
         }
 
         this.state = this.state(msg)
@@ -169,7 +281,7 @@ class PlayerState {
         }
 
         let data = <CountdownData>ev.data
-        this.startCountdown(data.count)
+        this.startCountdown(this.countdownLength(data))
         return this.stateGameCountdown
     }
 
@@ -178,7 +290,7 @@ class PlayerState {
             let data = <CountdownData>ev.data
 
             this.stateID = States.Countdown
-            this.startCountdown(data.count)
+            this.startCountdown(this.countdownLength(data))
             return this.stateQuestionCountdown
         }
 
@@ -222,9 +334,10 @@ class PlayerState {
             case "count":
                 let data = <CountdownData>ev.data
                 this.stateID = States.Countdown
-                this.startCountdown(data.count)
+                this.startCountdown(this.countdownLength(data))
                 return this.stateQuestionCountdown
             case "gend":
+            case "fres":
                 this.stateID = States.Finished
                 return this.stateEnding
             default:
@@ -239,6 +352,18 @@ class PlayerState {
         return this.stateEnding
     }
 
+    // Returns the length of a countdown in seconds
+    //
+    // If the server sent an absolute end time, this is preferred, as the
+    // countdown will then be synchronised with everybody else's screen.
+    private countdownLength(data: CountdownData): number {
+        if (data.ends) {
+            return clock.remaining(data.ends)
+        }
+
+        return data.count
+    }
+
     private startCountdown(len: number, title?: string): void {
         // Clear any existing countdown
         window.clearInterval(this.countdownHndl)
@@ -268,15 +393,43 @@ class PlayerState {
         // NOTE: we need to increment i, as server expects 1-indexed list
         common.SendMessage(conn, "ans", ++index)
     }
+
+    // Tells the player why the host removed them. Kicked players are sent
+    // back to choose a new nickname.
+    removed(reason: string): void {
+        this.connected = false
+        this.stateID = States.Finished
+        this.state = this.stateEnding
+        window.alert("You have been " + reason)
+        if (reason.startsWith("kicked")) {
+            window.location.href = "/join?pin=" + this.pin.toString()
+        } else {
+            window.location.href = "/join"
+        }
+    }
+
+    // Gives up our place in the game before it starts, so that somebody
+    // else may use our nickname
+    leave(): void {
+        common.SendMessage(conn, "leave", {})
+    }
+
+    // Asks the server for a new generated name
+    reroll(): void {
+        if (this.rerolls > 0) {
+            common.SendMessage(conn, "reroll", {})
+        }
+    }
 }
 
 // Main frontend init code
 document.addEventListener("DOMContentLoaded", () => {
     console.log("Gahoot! client scripts loaded")
-    console.log("Joining game " + window.pin + " as " + window.uid)
+    console.log("Joining game " + window.pin)
 
     // Init our global objects
-    plr = new PlayerState(window.pin, window.uid)
+    plr = new PlayerState(window.pin, window.token)
+    clock = new common.Clock()
 
     // Load information
     let url = common.PlayEndpoint + window.pin.toString()
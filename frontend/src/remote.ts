@@ -0,0 +1,238 @@
+/*
+ *  Gahoot! A self-hostable, minimal rewrite of Kahoot! in Go
+ *  Copyright 2022 - Ethan Marshall
+ *
+ *  Remote control scripts
+ */
+
+import * as common from "./common"
+import Alpine from "alpinejs"
+
+// Page lifetime variables
+let conn: WebSocket
+let remote: RemoteState
+let clock: common.Clock
+
+// Set up alpine on the window
+// For debugging purposes
+window.Alpine = Alpine
+
+// Game stages, named as reported by the server on resynchronisation
+enum Stages {
+    Lobby = "lobby",
+    Countdown = "countdown",
+    Question = "question",
+    Results = "results",
+    GameOver = "over",
+}
+
+interface QuestionData {
+    title: string
+    index: number
+    total: number
+}
+
+interface AnswerProgress {
+    answered: number
+    eligible: number
+}
+
+interface ResyncData {
+    stage: string
+    paused: boolean
+    players: (common.PlayerData & {connected: boolean})[]
+    question: QuestionData | null
+    answers: AnswerProgress
+}
+
+// Number of times to try to reconnect to the game before giving up
+const MaxReconnects = 30
+// Close reasons after which reconnecting is pointless
+const FatalCloseReasons = [
+    "invalid remote token",
+    "replaced by another remote",
+]
+
+// RemoteState tracks just enough of the game to know which controls make
+// sense. The host's screen remains the display; we only send commands.
+class RemoteState {
+    pin: number
+    title: string
+    stage: Stages
+    connected: boolean
+    reconnects: number
+    paused: boolean
+
+    players: common.PlayerData[]
+    question: QuestionData | null
+    progress: AnswerProgress
+    pending: common.PlayerData[]
+
+    constructor(game: number, title: string) {
+        this.pin = game
+        this.title = title
+        this.stage = Stages.Lobby
+        this.connected = false
+        this.reconnects = 0
+        this.paused = false
+
+        this.players = []
+        this.question = null
+        this.progress = {answered: 0, eligible: 0}
+        this.pending = []
+    }
+
+    // Hooks websocket events such that Alpine will track changes for us
+    init() {
+        conn.onopen = () => {this.initConn()}
+        conn.onclose = (ev: CloseEvent) => {this.handleClose(ev)}
+        conn.onmessage = (e: MessageEvent) => {this.handleMsg(e)}
+        conn.onerror = () => {console.warn("websocket error")}
+    }
+
+    // Sends the handshake
+    initConn() {
+        common.SendMessage(conn, "remote", window.remote_token)
+        console.log("now controlling game " + this.pin.toString())
+        clock.start(conn)
+        this.connected = true
+        this.reconnects = 0
+    }
+
+    // Reconnects after losing the connection, unless the game is over
+    handleClose(ev: CloseEvent) {
+        console.log(ev)
+        this.connected = false
+        if (FatalCloseReasons.includes(ev.reason) || this.stage == Stages.GameOver) {
+            return
+        }
+        if (this.reconnects >= MaxReconnects) {
+            return
+        }
+
+        this.reconnects++
+        window.setTimeout(() => {
+            conn = new WebSocket(common.RemoteEndpoint + this.pin.toString())
+            this.init()
+        }, 1000 * this.reconnects)
+    }
+
+    handleMsg(e: MessageEvent) {
+        let msg = common.ParseMessage(e.data)
+
+        switch (msg.action) {
+            case "clk":
+                clock.handle(<common.ClockData>msg.data)
+                break
+            case "rsync":
+                this.resync(<ResyncData>msg.data)
+                break
+            case "plr":
+                this.players = this.players.filter(pl => pl.id != msg.data.id)
+                this.players.push(<common.PlayerData>msg.data)
+                break
+            case "rmplr":
+                this.players = this.players.filter(pl => pl.id != msg.data.id)
+                break
+            case "ques":
+                this.stage = Stages.Countdown
+                this.question = <QuestionData>msg.data
+                this.pending = []
+                break
+            case "quack":
+            case "nans":
+                this.stage = Stages.Question
+                this.progress = <AnswerProgress>msg.data
+                this.refreshPending()
+                break
+            case "qend":
+                this.stage = Stages.Results
+                this.progress = <AnswerProgress>msg.data
+                this.pending = []
+                break
+            case "fres":
+                this.stage = Stages.GameOver
+                break
+            case "pause":
+                this.paused = true
+                break
+            case "resume":
+                this.paused = false
+                break
+            case "pend":
+                this.pending = msg.data
+                break
+        }
+    }
+
+    // Restores our state after connecting part way through the game
+    resync(data: ResyncData) {
+        this.players = data.players
+        this.question = data.question
+        this.paused = data.paused
+        this.progress = data.answers
+
+        switch (data.stage) {
+            case "countdown":
+                this.stage = Stages.Countdown
+                break
+            case "question":
+                this.stage = Stages.Question
+                this.refreshPending()
+                break
+            case "results":
+                this.stage = Stages.Results
+                break
+            default:
+                this.stage = Stages.Lobby
+                break
+        }
+    }
+
+    // Requests the list of players yet to answer
+    refreshPending(): void {
+        common.SendMessage(conn, "pend", {})
+    }
+
+    // Control commands
+    // These are performed by the host's screen or the server; we wait to
+    // be told the outcome rather than assuming it.
+
+    start(): void {
+        common.SendMessage(conn, "start", {})
+    }
+
+    skip(): void {
+        common.SendMessage(conn, "time", {})
+    }
+
+    next(): void {
+        common.SendMessage(conn, "next", {})
+    }
+
+    togglePause(): void {
+        common.SendMessage(conn, this.paused ? "resume" : "pause", {})
+    }
+
+    extendTime(secs: number): void {
+        common.SendMessage(conn, "extend", secs)
+    }
+
+    kick(id: number): void {
+        common.SendMessage(conn, "kick", id)
+    }
+}
+
+// Main frontend init code
+document.addEventListener("DOMContentLoaded", () => {
+    console.log("Gahoot! remote control scripts loaded")
+    console.log("Controlling game " + window.pin)
+
+    remote = new RemoteState(window.pin, window.title)
+    clock = new common.Clock()
+
+    conn = new WebSocket(common.RemoteEndpoint + window.pin.toString())
+
+    Alpine.store("remote", remote)
+    Alpine.start()
+})
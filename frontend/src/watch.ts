@@ -0,0 +1,269 @@
+/*
+ *  Gahoot! A self-hostable, minimal rewrite of Kahoot! in Go
+ *  Copyright 2022 - Ethan Marshall
+ *
+ *  Spectator display scripts
+ */
+
+import * as common from "./common"
+import Alpine from "alpinejs"
+
+// Page lifetime variables
+let conn: WebSocket
+let watch: WatchState
+let clock: common.Clock
+
+// Set up alpine on the window
+// For debugging purposes
+window.Alpine = Alpine
+
+// Display stages, named as reported by the server on resynchronisation
+enum Stages {
+    Lobby = "lobby",
+    StartCountdown = "start",
+    Countdown = "countdown",
+    Question = "question",
+    Results = "results",
+    GameOver = "over",
+}
+
+interface QuestionData {
+    title: string
+    image_url?: string
+    time: number
+    answers: {
+        title: string
+        correct: boolean
+    }[]
+
+    index: number
+    total: number
+}
+
+interface AnswerProgress {
+    answered: number
+    eligible: number
+}
+
+interface AnswerSummary extends AnswerProgress {
+    options: {
+        count: number
+        correct: boolean
+    }[]
+}
+
+interface ResyncData {
+    stage: string
+    paused: boolean
+    players: (common.PlayerData & {connected: boolean})[]
+    question: QuestionData | null
+    deadline: number
+    leaderboard: common.PlayerData[] | null
+    answers: AnswerSummary
+}
+
+// Number of times to try to reconnect to the game before giving up
+const MaxReconnects = 30
+// Close reasons after which reconnecting is pointless
+const FatalCloseReasons = [
+    "invalid watch token",
+]
+
+// WatchState mirrors the host's display, driven entirely by the copies of
+// host messages sent to us. Nothing is ever sent to the server other than
+// the handshake and clock synchronisation.
+class WatchState {
+    pin: number
+    title: string
+    stage: Stages
+    connected: boolean
+    reconnects: number
+    paused: boolean
+
+    icons: string[]
+    players: common.PlayerData[]
+    question: QuestionData | null
+    countdown: number
+    private countdownHndl: number
+
+    progress: AnswerProgress
+    summary: AnswerSummary | null
+    leaderboard: common.PlayerData[]
+
+    constructor(game: number, title: string) {
+        this.pin = game
+        this.title = title
+        this.stage = Stages.Lobby
+        this.connected = false
+        this.reconnects = 0
+        this.paused = false
+
+        this.icons = common.icons
+        this.players = []
+        this.question = null
+        this.countdown = 0
+        this.countdownHndl = 0
+
+        this.progress = {answered: 0, eligible: 0}
+        this.summary = null
+        this.leaderboard = []
+    }
+
+    // Hooks websocket events such that Alpine will track changes for us
+    init() {
+        conn.onopen = () => {this.initConn()}
+        conn.onclose = (ev: CloseEvent) => {this.handleClose(ev)}
+        conn.onmessage = (e: MessageEvent) => {this.handleMsg(e)}
+        conn.onerror = () => {console.warn("websocket error")}
+    }
+
+    // Sends the handshake
+    initConn() {
+        common.SendMessage(conn, "watch", window.watch_token)
+        console.log("now watching game " + this.pin.toString())
+        clock.start(conn)
+        this.connected = true
+        this.reconnects = 0
+    }
+
+    // Reconnects after losing the connection, unless the game is over
+    handleClose(ev: CloseEvent) {
+        console.log(ev)
+        this.connected = false
+        if (FatalCloseReasons.includes(ev.reason) || this.stage == Stages.GameOver) {
+            return
+        }
+        if (this.reconnects >= MaxReconnects) {
+            return
+        }
+
+        this.reconnects++
+        window.setTimeout(() => {
+            conn = new WebSocket(common.WatchEndpoint + this.pin.toString())
+            this.init()
+        }, 1000 * this.reconnects)
+    }
+
+    handleMsg(e: MessageEvent) {
+        let msg = common.ParseMessage(e.data)
+
+        switch (msg.action) {
+            case "clk":
+                clock.handle(<common.ClockData>msg.data)
+                break
+            case "rsync":
+                this.resync(<ResyncData>msg.data)
+                break
+            case "plr":
+                this.players = this.players.filter(pl => pl.id != msg.data.id)
+                this.players.push(<common.PlayerData>msg.data)
+                break
+            case "rmplr":
+                this.players = this.players.filter(pl => pl.id != msg.data.id)
+                break
+            case "gcount":
+                this.stage = Stages.StartCountdown
+                this.startCountdown(clock.remaining(msg.data.ends))
+                break
+            case "ques":
+                this.stage = Stages.Countdown
+                this.question = <QuestionData>msg.data
+                this.summary = null
+                this.progress = {answered: 0, eligible: 0}
+                this.startCountdown(5)
+                break
+            case "quack":
+                this.stage = Stages.Question
+                this.progress = <AnswerProgress>msg.data
+                this.startCountdown(clock.remaining(msg.data.deadline))
+                break
+            case "nans":
+                this.progress = <AnswerProgress>msg.data
+                break
+            case "qend":
+                this.stage = Stages.Results
+                this.summary = <AnswerSummary>msg.data
+                this.progress = this.summary
+                clearInterval(this.countdownHndl)
+                break
+            case "res":
+                this.leaderboard = msg.data
+                break
+            case "fres":
+                this.stage = Stages.GameOver
+                this.leaderboard = msg.data
+                break
+            case "pause":
+                this.paused = true
+                clearInterval(this.countdownHndl)
+                break
+            case "resume":
+                this.paused = false
+                if (this.stage == Stages.Question) {
+                    this.startCountdown(clock.remaining(msg.data.deadline))
+                } else {
+                    this.startCountdown(this.countdown)
+                }
+                break
+            case "dline":
+                this.countdown = clock.remaining(msg.data.deadline)
+                break
+        }
+    }
+
+    // Restores the display after connecting part way through the game
+    resync(data: ResyncData) {
+        this.players = data.players
+        this.question = data.question
+        this.paused = data.paused
+        this.progress = data.answers
+        this.summary = data.answers.options ? data.answers : null
+        this.leaderboard = data.leaderboard || []
+
+        clearInterval(this.countdownHndl)
+        switch (data.stage) {
+            case "countdown":
+                this.stage = Stages.Countdown
+                this.startCountdown(5)
+                break
+            case "question":
+                this.stage = Stages.Question
+                this.startCountdown(clock.remaining(data.deadline))
+                break
+            case "results":
+                this.stage = Stages.Results
+                break
+            default:
+                this.stage = Stages.Lobby
+                break
+        }
+        if (this.paused) {
+            clearInterval(this.countdownHndl)
+        }
+    }
+
+    // Counts down from secs for display only
+    startCountdown(secs: number) {
+        this.countdown = secs
+        clearInterval(this.countdownHndl)
+        this.countdownHndl = window.setInterval(() => {
+            if (this.countdown > 0) {
+                this.countdown--
+            }
+        }, 1000)
+    }
+}
+
+// Main frontend init code
+document.addEventListener("DOMContentLoaded", () => {
+    console.log("Gahoot! spectator scripts loaded")
+    console.log("Watching game " + window.pin)
+
+    watch = new WatchState(window.pin, window.title)
+    clock = new common.Clock()
+
+    conn = new WebSocket(common.WatchEndpoint + window.pin.toString())
+
+    Alpine.store("watch", watch)
+    Alpine.start()
+})
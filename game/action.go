@@ -2,10 +2,15 @@ package game
 
 import (
 	"context"
+	"crypto/subtle"
 	"log"
+	"strconv"
 	"time"
 
 	"github.com/gorilla/websocket"
+
+	"github.com/ejv2/gahoot/game/nick"
+	"github.com/ejv2/gahoot/game/quiz"
 )
 
 // A Action is some action which can be sent to the game runner goroutine
@@ -17,32 +22,61 @@ type Action interface {
 	Perform(game *Game)
 }
 
+// ConnectHost initializes the host's connection, performing the startup
+// handshake asynchronously. When this is complete, submits a new action to the
+// game runner to install the new host. If the game already has a host which
+// has since disconnected, the new connection replaces it and is resynchronised
+// with the current game state.
 type ConnectHost struct {
 	Conn *websocket.Conn
+	cl   Client
+	tok  string
+	fin  bool
 }
 
-func (c ConnectHost) Perform(game *Game) {
-	// NOTE: There is no need, for now, to enforce a deadline, as players
-	// cannot connect before their host.
-	cl := Client{
+func (c ConnectHost) handleConnection(game Game) {
+	// Enforce 30s handshake deadline to stop deadlocking of the game thread
+	c.Conn.SetReadDeadline(time.Now().Add(time.Second * 30))
+	defer c.Conn.SetReadDeadline(*new(time.Time))
+
+	// Temporary client object. See ConnectPlayer for details.
+	c.cl = Client{
 		Connected: true,
 		conn:      c.Conn,
 		send:      nil,
 		Ctx:       context.Background(),
 	}
-	verb, _, err := cl.ReadString()
+	verb, err := c.cl.ReadMessage(&c.tok)
 	switch {
 	case err != nil:
-		cl.CloseReason(err.Error())
+		c.cl.CloseReason(err.Error())
 		return
 	case verb != "host":
-		cl.CloseReason("expected first message to be HOST")
+		c.cl.CloseReason("expected first message to be HOST")
 		return
-	case game.state.Host != nil:
-		cl.CloseReason("game already has a host")
+	case subtle.ConstantTimeCompare([]byte(c.tok), []byte(game.HostToken)) != 1:
+		c.cl.CloseReason("invalid host token")
 		return
 	}
 
+	c.fin = true
+	select {
+	case game.Action <- c:
+	case <-game.ctx.Done():
+		c.cl.Close()
+	}
+}
+
+func (c ConnectHost) handleInsertion(game *Game) {
+	resume := false
+	if game.state.Host != nil {
+		if game.state.Host.Connected {
+			c.cl.CloseReason("game already has a host")
+			return
+		}
+		resume = true
+	}
+
 	deadline, ok := game.ctx.Deadline()
 	if !ok {
 		panic("connecthost: found game with no deadline")
@@ -56,56 +90,276 @@ func (c ConnectHost) Perform(game *Game) {
 			Ctx:       ctx,
 			Cancel:    cancel,
 			send:      make(chan string),
+			rtt:       new(roundTrip),
+			rec:       game.recipient("host"),
 		},
 	}
+	game.start(game.state.Host.Client, game.state.Host.Run)
+
+	if !resume {
+		log.Println("Host successfully joined", game.PIN.String())
+		return
+	}
 
-	log.Println("Host successfully joined", game.PIN.String())
-	go game.state.Host.Run(game.Action)
+	log.Println("Host rejoined", game.PIN.String(), "after", game.now().Sub(game.state.hostLostAt))
+	game.state.hostLostAt = time.Time{}
+	game.state.Host.SendMessage(CommandResync, game.hostResync())
+	for _, plr := range game.state.Players {
+		go plr.SendMessage(CommandHostReturned, struct{}{})
+	}
+}
+
+func (c ConnectHost) Perform(game *Game) {
+	if !c.fin {
+		go c.handleConnection(*game)
+		return
+	}
+
+	c.handleInsertion(game)
+}
+
+// HostDisconnect informs the game runner that the host's connection has been
+// lost. The game is paused and held until either the host reconnects or the
+// grace period expires, at which point the game is cancelled. A returning host
+// must resume the game itself.
+type HostDisconnect struct{}
+
+func (h HostDisconnect) Perform(game *Game) {
+	if game.state.Host == nil || !game.state.Host.Connected {
+		return
+	}
+
+	game.state.Host.Connected = false
+	game.state.hostLostAt = game.now()
+	game.schedule(game.HostGracePeriod, hostTimeout{game.state.hostLostAt})
+
+	// Nobody is keeping time, so freeze the game until the host returns
+	if game.state.Status == GameRunning {
+		PauseGame{}.Perform(game)
+	}
+
+	log.Println("Host lost for", game.PIN.String(), "- holding game for", game.HostGracePeriod)
+	until := Timestamp(game.state.hostLostAt.Add(game.HostGracePeriod))
+	for _, plr := range game.state.Players {
+		go plr.SendMessage(CommandHostWaiting, struct {
+			Until int64 `json:"until"`
+		}{until})
+	}
+}
+
+// hostTimeout is submitted when the host grace period started at "since"
+// expires. If the host has not yet returned, the game is cancelled.
+type hostTimeout struct {
+	Since time.Time
+}
+
+func (h hostTimeout) Perform(game *Game) {
+	if game.state.Host.Connected || !game.state.hostLostAt.Equal(h.Since) {
+		return
+	}
+
+	log.Println("Host did not return to", game.PIN.String(), "- cancelling")
+	EndGame{"host disconnect", false}.Perform(game)
 }
 
 // AddPlayer allocates a new slot on the server for one player to join and
-// returns an ID for this player, which is the index into the players array.
-// This ID will then be used by the websocket to request to join the game.
-// If Err is non-nil, the player will not have been added and ID will be
-// negative, which is invalid.
+// returns an ID for this player, which is the index into the players array,
+// alongside a secret session token. The token will then be used by the
+// websocket to request to join the game, and to resume the same slot if the
+// connection is lost. If Err is non-nil, the player will not have been added
+// and the other fields of the result are invalid.
+//
+// The nickname is cleaned and checked against the game's nickname policy, and
+// must not look the same as any nickname already in use. If the game generates
+// names for its players, Nick is ignored and a name is picked at random.
 type AddPlayer struct {
 	Nick string
-	ID   chan int
+	// Addr is the address of the joining client, and Token any session
+	// token which it already holds, for checking against bans.
+	Addr, Token string
+	Result      chan AddResult `json:"-"`
+}
+
+// AddResult is the result of an AddPlayer action.
+type AddResult struct {
+	ID    int
+	Token string
+	Err   error
 }
 
 func (p AddPlayer) Perform(game *Game) {
 	if game.state.namecache == nil {
 		game.state.namecache = make(map[string]struct{})
+		game.state.tokens = make(map[string]int)
 	}
-	if _, ok := game.state.namecache[p.Nick]; ok {
-		log.Println("reserved nick", p.Nick, "attempted re-add: rejected")
-		p.ID <- -1
+	if game.banned(p.Addr, p.Token) {
+		log.Println("banned client", p.Addr, "attempted to join: rejected")
+		p.Result <- AddResult{ID: -1, Err: ErrorBanned}
+		return
+	}
+	if err := game.checkCapacity(); err != nil {
+		log.Println("join to", game.PIN, "rejected:", err)
+		p.Result <- AddResult{ID: -1, Err: err}
+		return
+	}
+
+	name, err := p.Nick, error(nil)
+	if game.GenerateNames {
+		name = game.generateNick()
+	} else if name, err = game.checkNick(p.Nick); err != nil {
+		log.Printf("nick %q rejected: %v", p.Nick, err)
+		p.Result <- AddResult{ID: -1, Err: err}
+		return
+	}
+	skel := nick.Skeleton(name)
+	if _, ok := game.state.namecache[skel]; ok {
+		log.Println("reserved nick", name, "attempted re-add: rejected")
+		p.Result <- AddResult{ID: -1, Err: ErrorNickTaken}
 		return
 	}
 
 	// NOTE: Deliberately does not start the player context.
 	// Runner has not yet started and the context must be re-created on
 	// re-connection
+	id, tok := len(game.state.Players)+1, game.newToken()
 	game.state.Players = append(game.state.Players, Player{
-		ID:   len(game.state.Players) + 1,
-		Nick: p.Nick,
+		ID:   id,
+		Nick: name,
 		Client: Client{
 			Connected: false,
 			send:      make(chan string),
+			box:       new(outbox),
+			rec:       game.recipient("player " + strconv.Itoa(id)),
 		},
+		token:   tok,
+		addr:    p.Addr,
+		Pending: game.ApproveJoins,
 	})
-	game.state.namecache[p.Nick] = struct{}{}
+	game.state.namecache[skel] = struct{}{}
+	game.state.tokens[tok] = id
+	game.schedule(game.ClaimTimeout, claimTimeout{id})
+
+	p.Result <- AddResult{ID: id, Token: tok}
+}
+
+// nickTaken reports if a nickname with skeleton skel is in use.
+func (game *Game) nickTaken(skel string) bool {
+	_, ok := game.state.namecache[skel]
+	return ok
+}
+
+// checkCapacity returns an error if the game has no room for another player,
+// either because it is full or because too many players are still joining.
+func (game *Game) checkCapacity() error {
+	var players, joining int
+	for _, plr := range game.state.Players {
+		if plr.Removed {
+			continue
+		}
+		players++
+		if !plr.claimed {
+			joining++
+		}
+	}
+
+	if game.MaxPlayers > 0 && players >= game.MaxPlayers {
+		return ErrorGameFull
+	}
+	if game.MaxPending > 0 && joining >= game.MaxPending {
+		return ErrorJoinBusy
+	}
+	return nil
+}
+
+// claimTimeout is submitted when the time allowed for a player to connect
+// after joining expires. If they never connected, their slot and nickname
+// are released.
+type claimTimeout struct {
+	ID int
+}
+
+func (c claimTimeout) Perform(game *Game) {
+	plr := &game.state.Players[c.ID-1]
+	if plr.claimed || plr.Removed {
+		return
+	}
 
-	p.ID <- len(game.state.Players)
+	log.Printf("%s (ID: %d) never connected to %s - releasing", plr.Nick, plr.ID, game.PIN)
+	game.removePlayer(plr, "")
+}
+
+// LeavePlayer removes a player who has chosen to leave the game. This frees
+// their slot and nickname, so is only allowed before the game starts.
+type LeavePlayer struct {
+	ID int
+}
+
+func (l LeavePlayer) Perform(game *Game) {
+	if l.ID <= 0 || l.ID > len(game.state.Players) {
+		return
+	}
+	plr := &game.state.Players[l.ID-1]
+	if plr.Removed || game.state.Status == GameRunning {
+		return
+	}
+
+	log.Printf("%s (ID: %d) left %s", plr.Nick, plr.ID, game.PIN)
+	game.removePlayer(plr, "left game")
+}
+
+// RerollNick replaces a player's generated name with a new random name. Each
+// player may only do so MaxRerolls times, and only before the game starts.
+type RerollNick struct {
+	PlayerID int
+}
+
+func (r RerollNick) Perform(game *Game) {
+	if !game.GenerateNames || game.state.Status == GameRunning {
+		return
+	}
+	if r.PlayerID <= 0 || r.PlayerID > len(game.state.Players) {
+		return
+	}
+	plr := &game.state.Players[r.PlayerID-1]
+	if plr.rerolls >= MaxRerolls {
+		log.Printf("%d attempted to re-roll name too many times [%s]", plr.ID, game.PIN)
+		return
+	}
+
+	name := game.generateNick()
+	delete(game.state.namecache, nick.Skeleton(plr.Nick))
+	game.state.namecache[nick.Skeleton(name)] = struct{}{}
+	plr.Nick = name
+	plr.rerolls++
+
+	game.sendNick(plr)
+	if !plr.Pending {
+		game.sendHost(CommandRenamePlayer, plr.Info())
+	}
+}
+
+// sendNick tells a player the name they have been given, and how many more
+// times they may re-roll it.
+func (game *Game) sendNick(plr *Player) {
+	go plr.SendMessage(CommandNick, struct {
+		Nick    string `json:"name"`
+		Rerolls int    `json:"rerolls"`
+	}{plr.Nick, MaxRerolls - plr.rerolls})
 }
 
 // ConnectPlayer initializes a player's connection, performs the startup
 // handshake asynchronously. When this is complete, submits a new action to the
 // game runner to update the player's state.
+//
+// The handshake must contain the session token issued by AddPlayer. A player
+// which presents the token of a disconnected player resumes that player's
+// slot, including score and streak.
 type ConnectPlayer struct {
 	Conn *websocket.Conn
+	// Addr is the address of the connecting client.
+	Addr string
 	cl   Client
-	id   uint32
+	tok  string
 	fin  bool
 }
 
@@ -128,13 +382,13 @@ func (c ConnectPlayer) handleConnection(game Game) {
 		send:      nil,
 		Ctx:       context.Background(),
 	}
-	verb, err := c.cl.ReadMessage(&c.id)
+	verb, err := c.cl.ReadMessage(&c.tok)
 	if err != nil {
 		c.cl.CloseReason(err.Error())
 		return
 	}
 
-	if verb != "ident" {
+	if verb != MessageIdenfity {
 		c.cl.CloseReason("expected first message to be IDENT")
 		return
 	}
@@ -145,49 +399,63 @@ func (c ConnectPlayer) handleConnection(game Game) {
 
 func (c ConnectPlayer) handleInsertion(game *Game) {
 	// Validate player object
-	if c.id > uint32(len(game.state.Players)) {
-		c.cl.CloseReason("invalid player identifier")
+	id, ok := game.state.tokens[c.tok]
+	if !ok {
+		log.Println("invalid session token presented to", game.PIN)
+		c.cl.CloseReason("invalid session token")
 		return
-	} else if game.state.Players[c.id-1].Connected {
-		c.cl.CloseReason("given ID already connected")
+	} else if game.state.Players[id-1].Connected {
+		c.cl.CloseReason("player already connected")
 		return
 	}
 
 	// Player valid
 	// Go ahead and update player object
-	if game.state.Players[c.id-1].Banned {
-		log.Println("banned ID", c.id, "attempted rejoin: rejected")
+	if game.state.Players[id-1].Banned || game.banned(c.Addr, c.tok) {
+		log.Println("banned ID", id, "attempted rejoin: rejected")
 		c.cl.CloseReason("ID banned")
 		return
 	} else if game.state.Host == nil {
-		log.Println("ID", c.id, "attempted to join before host")
+		log.Println("ID", id, "attempted to join before host")
 		c.cl.CloseReason("host not connected")
 		return
 	}
 
-	game.state.Players[c.id-1].Connected = true
-	game.state.Players[c.id-1].conn = c.Conn
+	game.state.Players[id-1].Connected = true
+	game.state.Players[id-1].claimed = true
+	game.state.Players[id-1].conn = c.Conn
+	game.state.Players[id-1].addr = c.Addr
+	game.state.Players[id-1].rtt = new(roundTrip)
 
 	// Add context for player
 	end, ok := game.ctx.Deadline()
 	if !ok {
 		panic("addplayer: found game with no deadline")
 	}
-	game.state.Players[c.id-1].Ctx,
-		game.state.Players[c.id-1].Cancel = context.WithDeadline(game.ctx, end)
+	game.state.Players[id-1].Ctx,
+		game.state.Players[id-1].Cancel = context.WithDeadline(game.ctx, end)
 
-	log.Printf("%s (ID: %d) successfully joined %d", game.state.Players[c.id-1].Nick, c.id, game.PIN)
+	log.Printf("%s (ID: %d) successfully joined %d", game.state.Players[id-1].Nick, id, game.PIN)
 
-	// Launch player runner
-	go game.state.Players[c.id-1].Run(game.Action)
+	// Launch player runner and catch up on anything missed
+	game.start(game.state.Players[id-1].Client, game.state.Players[id-1].Run)
+	game.state.Players[id-1].Replay()
+	if game.GenerateNames {
+		game.sendNick(&game.state.Players[id-1])
+	}
 
 	// Inform host
 	inf := struct {
 		ID   int    `json:"id"`
 		Nick string `json:"name"`
-	}{game.state.Players[c.id-1].ID, game.state.Players[c.id-1].Nick}
+	}{game.state.Players[id-1].ID, game.state.Players[id-1].Nick}
 
-	game.state.Host.SendMessage(CommandNewPlayer, inf)
+	if game.state.Players[id-1].Pending {
+		game.sendHost(CommandJoinRequest, inf)
+		game.state.Players[id-1].SendMessage(CommandJoinPending, struct{}{})
+		return
+	}
+	game.sendHost(CommandNewPlayer, inf)
 }
 
 func (c ConnectPlayer) Perform(game *Game) {
@@ -214,23 +482,59 @@ func (c ConnectionUpdate) Perform(game *Game) {
 	game.state.Players[c.PlayerID-1].Connected = c.Connected
 
 	plr := game.state.Players[c.PlayerID-1]
-	game.state.Host.SendMessage(CommandDisconPlayer, plr.Info())
+	if plr.Pending || plr.Removed {
+		return
+	}
+	game.sendHost(CommandDisconPlayer, plr.Info())
 }
 
-// KickPlayer disconnects and bans a player ID from this game.
-// This means the player will be disconnected and will be prevented from
-// rejoining.
-type KickPlayer struct {
+// ApprovePlayer admits a player waiting for approval into the game.
+type ApprovePlayer struct {
 	ID int
 }
 
-func (k KickPlayer) Perform(game *Game) {
-	game.state.Players[k.ID-1].Connected = false
-	game.state.Players[k.ID-1].Banned = true
-	game.state.Players[k.ID-1].Cancel()
+func (a ApprovePlayer) Perform(game *Game) {
+	if a.ID <= 0 || a.ID > len(game.state.Players) {
+		log.Printf("attempted to approve invalid player (ID: %d) [%s]", a.ID, game.PIN)
+		return
+	}
+	plr := &game.state.Players[a.ID-1]
+	if !plr.Pending || plr.Removed {
+		return
+	}
+
+	plr.Pending = false
+	log.Printf("%s (ID: %d) approved to join %s", plr.Nick, plr.ID, game.PIN)
 
-	plr := game.state.Players[k.ID-1]
-	game.state.Host.SendMessage(CommandRemovePlayer, plr.Info())
+	if plr.Connected {
+		game.sendHost(CommandNewPlayer, struct {
+			ID   int    `json:"id"`
+			Nick string `json:"name"`
+		}{plr.ID, plr.Nick})
+	}
+	go plr.SendMessage(CommandJoinApproved, struct{}{})
+}
+
+// RejectPlayer turns away a player waiting for approval. The player is
+// disconnected, their session token is revoked and their nickname is released
+// for somebody else to use.
+type RejectPlayer struct {
+	ID int
+}
+
+func (r RejectPlayer) Perform(game *Game) {
+	if r.ID <= 0 || r.ID > len(game.state.Players) {
+		log.Printf("attempted to reject invalid player (ID: %d) [%s]", r.ID, game.PIN)
+		return
+	}
+	plr := &game.state.Players[r.ID-1]
+	if !plr.Pending || plr.Removed {
+		return
+	}
+
+	log.Printf("%s (ID: %d) rejected from %s", plr.Nick, plr.ID, game.PIN)
+	game.moderate(plr, ModReject, "")
+	game.removePlayer(plr, "rejected by host")
 }
 
 // StartGame either begins a game or game countdown.
@@ -246,22 +550,24 @@ func (s StartGame) Perform(game *Game) {
 			log.Println(game.PIN, "attempted to start with", len(game.state.Players), "(too few; rejected)")
 		}
 
-		game.state.Host.SendMessage(CommandStartAck, struct{}{})
+		game.sendHost(CommandStartAck, struct{}{})
 		game.sf = game.Question
 		game.state.Status = GameRunning
+		game.state.startedAt = game.now()
 
 		log.Println(game.PIN, "now commencing")
 		return
 	}
 
 	game.sf = game.Sustain
+	count := struct {
+		Count int    `json:"count"`
+		Title string `json:"title"`
+		Ends  int64  `json:"ends"`
+	}{s.Count, game.Title, Timestamp(game.now().Add(time.Duration(s.Count) * time.Second))}
+	game.spectate(CommandGameCount, count)
 	for _, plr := range game.state.Players {
-		go func(plr Player) {
-			plr.SendMessage(CommandGameCount, struct {
-				Count int    `json:"count"`
-				Title string `json:"title"`
-			}{s.Count, game.Title})
-		}(plr)
+		go plr.SendMessage(CommandGameCount, count)
 	}
 
 	game.sf = game.Sustain
@@ -273,38 +579,185 @@ type NextQuestion struct{}
 func (n NextQuestion) Perform(game *Game) {
 	// End of the game
 	if game.state.CurrentQuestion == len(game.Questions)-1 {
-		game.sf = game.GameTerminate
+		game.finish()
+		return
+	}
+
+	game.gotoQuestion(game.state.CurrentQuestion + 1)
+}
 
-		board := NewLeaderboard(game.state.Players)
-		game.state.Host.SendMessage(CommandFinalResults, board)
-		for _, plr := range game.state.Players {
-			plr.SendMessage(CommandFinalResults, board)
+// finish ends the game, sending the final results to the host and every
+// player. The game terminates on return.
+func (game *Game) finish() {
+	game.sf = game.GameTerminate
+
+	board := NewLeaderboard(game.state.Players)
+	game.sendHost(CommandFinalResults, board)
+	for _, plr := range game.state.Players {
+		plr.SendMessage(CommandFinalResults, board)
+	}
+}
+
+// JumpQuestion moves the game straight to the countdown for question Index
+// (one indexed), abandoning the current question if it has not yet finished.
+// Nobody is scored for an abandoned question. Jumping to the current question
+// repeats it.
+type JumpQuestion struct {
+	Index int
+}
+
+func (j JumpQuestion) Perform(game *Game) {
+	if game.state.Status != GameRunning {
+		log.Println(game.PIN, "attempted to jump before starting: rejected")
+		return
+	}
+	if j.Index < 1 || j.Index > len(game.Questions) {
+		log.Println(game.PIN, "attempted to jump to invalid question", j.Index)
+		return
+	}
+
+	// The abandoned question's answers must not open on resume
+	game.state.countdownPending = false
+	if game.state.paused {
+		game.resume()
+	}
+	game.gotoQuestion(j.Index - 1)
+	log.Println(game.PIN, "jumped to question", j.Index)
+}
+
+// PauseGame freezes the game. If answers are being accepted, the answer window
+// is frozen and no answers are accepted until the game is resumed. Time spent
+// paused does not count towards the time taken by any player.
+type PauseGame struct{}
+
+func (p PauseGame) Perform(game *Game) {
+	if game.state.paused {
+		return
+	}
+
+	game.state.paused = true
+	game.state.pausedAt = game.now()
+
+	log.Println(game.PIN, "paused")
+	game.sendHost(CommandPaused, struct{}{})
+	for _, plr := range game.state.Players {
+		go plr.SendMessage(CommandPaused, struct{}{})
+	}
+}
+
+// ResumeGame resumes a game paused with PauseGame.
+type ResumeGame struct{}
+
+func (r ResumeGame) Perform(game *Game) {
+	if !game.state.paused {
+		return
+	}
+
+	game.resume()
+}
+
+// resume un-pauses the game, shifting the answer window forward by the time
+// spent paused, and informs all clients of the new deadline.
+func (game *Game) resume() {
+	now := game.now()
+	paused := now.Sub(game.state.pausedAt)
+	// Only time spent paused after answers opened was lost from the
+	// answer window
+	from := game.state.pausedAt
+	if game.state.answersAt.After(from) {
+		from = game.state.answersAt
+	}
+	lost := now.Sub(from)
+	if lost < 0 {
+		lost = 0
+	}
+	game.state.paused = false
+	game.state.pausedAt = time.Time{}
+
+	var deadline int64
+	if game.state.countdownDone {
+		game.state.answersAt = game.state.answersAt.Add(lost)
+		deadline = Timestamp(game.deadline())
+
+		// Answers given before the pause keep the time they took
+		for i, plr := range game.state.Players {
+			if plr.answer > 0 {
+				game.state.Players[i].answeredAt = plr.answeredAt.Add(lost)
+			}
 		}
+	}
+
+	log.Println(game.PIN, "resumed after", paused)
+	msg := struct {
+		Deadline int64 `json:"deadline"`
+	}{deadline}
+	game.sendHost(CommandResumed, msg)
+	for _, plr := range game.state.Players {
+		go plr.SendMessage(CommandResumed, msg)
+	}
+
+	if game.state.countdownPending {
+		game.state.countdownPending = false
+		StartAnswer{}.Perform(game)
+	}
+}
 
-		// Game terminates on return
+// ExtendTime grants extra time for answering the current question. Extensions
+// are capped such that the total extra time never exceeds MaxExtraTime.
+type ExtendTime struct {
+	Seconds int
+}
+
+func (e ExtendTime) Perform(game *Game) {
+	if !game.state.acceptingAnswers || e.Seconds <= 0 {
 		return
 	}
 
-	game.sf = game.Question
-	game.state.CurrentQuestion++
+	extra := game.state.extraTime + time.Duration(e.Seconds)*time.Second
+	if extra > MaxExtraTime {
+		extra = MaxExtraTime
+	}
+	game.state.extraTime = extra
 
-	game.state.countdownDone = false
-	game.state.acceptingAnswers = false
-	game.state.questionSkipped = false
-	for i := range game.state.Players {
-		game.state.Players[i].canAnswer = false
-		game.state.Players[i].answer = 0
+	log.Println(game.PIN, "extended question", game.state.CurrentQuestion+1, "by", e.Seconds, "seconds")
+	msg := struct {
+		Deadline int64 `json:"deadline"`
+	}{Timestamp(game.deadline())}
+	game.sendHost(CommandDeadline, msg)
+	for _, plr := range game.state.Players {
+		go plr.SendMessage(CommandDeadline, msg)
 	}
 }
 
 type StartAnswer struct{}
 
 func (s StartAnswer) Perform(game *Game) {
+	// Answers cannot open while the game is frozen, so hold them until the
+	// game is resumed
+	if game.state.paused {
+		game.state.countdownPending = true
+		return
+	}
+
 	game.state.countdownDone = true
-	game.state.answersAt = time.Now()
-	go game.state.Host.SendMessage(CommandQuestionAck, struct{}{})
+	game.state.answersAt = game.now()
+
+	// Deadline is sent as an absolute server time, such that clients
+	// with synchronised clocks can all show the same countdown.
+	q := game.Questions[game.state.CurrentQuestion]
+	deadline := Timestamp(game.deadline())
+
+	ack := struct {
+		AnswerProgress
+		Deadline int64 `json:"deadline"`
+	}{game.answerProgress(), deadline}
+	game.spectate(CommandQuestionAck, ack)
+	go game.state.Host.SendMessage(CommandQuestionAck, ack)
 	for _, plr := range game.state.Players {
-		plr.SendMessage(CommandNewQuestion, game.Questions[game.state.CurrentQuestion])
+		plr.SendMessage(CommandNewQuestion, struct {
+			quiz.Question
+			Deadline int64 `json:"deadline"`
+		}{q, deadline})
 	}
 }
 
@@ -319,19 +772,20 @@ type Answer struct {
 }
 
 func (a Answer) Perform(game *Game) {
-	atime := time.Now()
-	taken := time.Since(game.state.answersAt).Seconds()
-	maxtaken := (time.Duration(game.Questions[game.state.CurrentQuestion].Duration) * time.Second).Seconds()
+	atime := game.now()
+	taken := atime.Sub(game.state.answersAt).Seconds()
+	maxtaken := game.answerWindow().Seconds()
 
 	if a.Number < 1 {
 		panic("answer: invalid answer: less than 1")
 	}
 	if !game.state.acceptingAnswers {
-		log.Printf("%d attempted to answer out of answer time (%v : %v) [%s]", a.PlayerID, atime, time.Since(game.state.answersAt), game.PIN)
+		log.Printf("%d attempted to answer out of answer time (%v : %v) [%s]", a.PlayerID, atime, atime.Sub(game.state.answersAt), game.PIN)
 		return
 	}
-	if taken > maxtaken {
-		atime = game.state.answersAt.Add(time.Duration(game.Questions[game.state.CurrentQuestion].Duration) * time.Second)
+	if game.state.paused {
+		log.Printf("%d attempted to answer while paused [%s]", a.PlayerID, game.PIN)
+		return
 	}
 	if a.PlayerID <= 0 || a.PlayerID > len(game.state.Players) {
 		log.Printf("invalid player attempted to answer (ID: %d) [%s]", a.PlayerID, game.PIN)
@@ -345,6 +799,20 @@ func (a Answer) Perform(game *Game) {
 		log.Printf("%d attempted multiple answer [%s]", a.PlayerID, game.PIN)
 		return
 	}
+	if game.LatencyCompensation {
+		// Credit back the time the answer spent in flight. Latency is
+		// capped, so a client cannot gain more than a fraction of a
+		// second by faking a slow connection.
+		comp := game.latency(game.state.Players[a.PlayerID-1])
+		atime = atime.Add(-comp)
+		taken -= comp.Seconds()
+		if atime.Before(game.state.answersAt) {
+			atime, taken = game.state.answersAt, 0
+		}
+	}
+	if taken > maxtaken {
+		atime = game.deadline()
+	}
 
 	log.Println(a.PlayerID, "answered option", a.Number, "in", atime.Sub(game.state.answersAt), "for question", game.state.CurrentQuestion+1, "in game", game.PIN)
 
@@ -354,6 +822,35 @@ func (a Answer) Perform(game *Game) {
 	if !game.state.lastPlayer {
 		game.state.Players[a.PlayerID-1].SendMessage(CommandAnswerAck, struct{}{})
 	}
+
+	// Batch progress updates to the host. Answers arriving within the
+	// update interval are all reported by the one pending update.
+	if !game.state.progressPending {
+		game.state.progressPending = true
+		game.schedule(AnswerUpdateInterval, answerUpdate{game.state.CurrentQuestion})
+	}
+}
+
+// answerUpdate is submitted after AnswerUpdateInterval has elapsed since the
+// first unreported answer to question "question", and sends the host the
+// current answer progress.
+type answerUpdate struct {
+	Question int
+}
+
+func (a answerUpdate) Perform(game *Game) {
+	if !game.state.progressPending || a.Question != game.state.CurrentQuestion {
+		return
+	}
+	game.state.progressPending = false
+
+	// The summary sent with qend already contains the final count
+	if !game.state.acceptingAnswers {
+		return
+	}
+	progress := game.answerProgress()
+	game.spectate(CommandNewAnswer, progress)
+	go game.state.Host.SendMessage(CommandNewAnswer, progress)
 }
 
 type SendResults struct{}
@@ -383,3 +880,38 @@ func (e EndGame) Perform(game *Game) {
 
 	game.sf = game.GameEnding
 }
+
+// Drain warns every client that the server is shutting down. The game may
+// carry on until Deadline, at which point it is ended. A running game is
+// ended cleanly, with the final results sent out and archived.
+type Drain struct {
+	Deadline time.Time
+}
+
+func (d Drain) Perform(game *Game) {
+	msg := struct {
+		Deadline int64 `json:"deadline"`
+	}{Timestamp(d.Deadline)}
+	if game.state.Host != nil {
+		game.sendHost(CommandDraining, msg)
+	}
+	for _, plr := range game.state.Players {
+		go plr.SendMessage(CommandDraining, msg)
+	}
+
+	log.Println(game.PIN, "draining - ending by", d.Deadline.Format(time.Kitchen))
+	game.schedule(d.Deadline.Sub(game.now()), drainTimeout{})
+}
+
+// drainTimeout is submitted when the deadline given by Drain passes.
+type drainTimeout struct{}
+
+func (d drainTimeout) Perform(game *Game) {
+	log.Println(game.PIN, "did not finish before shutdown - ending")
+	if game.state.Status != GameRunning {
+		EndGame{"server shutting down", false}.Perform(game)
+		return
+	}
+
+	game.finish()
+}
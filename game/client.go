@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -17,6 +19,10 @@ import (
 // or composites (arrays and objects). This allows arbitrary Go data to be
 // passed to the client. The client expects each field in the passed data to be
 // lower case.
+//
+// Messages sent to clients with reliable delivery have a sequence number
+// appended to the verb, as in "<verb>#<seq> <body>". The client must
+// acknowledge these with MessageAcknowledge.
 const (
 	CommandGameCount     = "gcount"
 	CommandQuestionCount = "count"
@@ -25,6 +31,16 @@ const (
 	CommandQuestionOver  = "qend"
 	CommandSeeResults    = "res"
 	CommandFinalResults  = "fres"
+	CommandClockSync     = "clk"
+	CommandHostWaiting   = "hwait"
+	CommandHostReturned  = "hback"
+	CommandPaused        = "pause"
+	CommandResumed       = "resume"
+	CommandDeadline      = "dline"
+	CommandJoinPending   = "jwait"
+	CommandJoinApproved  = "jok"
+	CommandNick          = "nick"
+	CommandDraining      = "drain"
 
 	CommandNewPlayer    = "plr"
 	CommandRemovePlayer = "rmplr"
@@ -32,6 +48,12 @@ const (
 	CommandStartAck     = "sack"
 	CommandQuestionAck  = "quack"
 	CommandNewAnswer    = "nans"
+	CommandResync       = "rsync"
+	CommandRemote       = "rctl"
+	CommandPending      = "pend"
+	CommandJoinRequest  = "jreq"
+	CommandRenamePlayer = "rnplr"
+	CommandModLog       = "mlog"
 )
 
 // WebSocket client message commands.
@@ -39,15 +61,29 @@ const (
 // message commands for more details on format.
 const (
 	MessageIdenfity    = "ident"
+	MessageWatch       = "watch"
+	MessageRemote      = "remote"
 	MessageAcknowledge = "ack"
 	MessageAnswer      = "ans"
+	MessageClockSync   = "clk"
+	MessageReroll      = "reroll"
+	MessageLeave       = "leave"
 
 	MessageKick         = "kick"
+	MessageBan          = "ban"
+	MessageRename       = "ren"
 	MessageCountdown    = "count"
 	MessageStartGame    = "start"
 	MessageNextQuestion = "next"
 	MessageAnswerNow    = "sans"
 	MessageQuestionEnd  = "time"
+	MessagePause        = "pause"
+	MessageResume       = "resume"
+	MessageExtendTime   = "extend"
+	MessageJump         = "jump"
+	MessagePending      = "pend"
+	MessageApprove      = "appr"
+	MessageReject       = "rej"
 )
 
 // Client mechanism constants.
@@ -58,8 +94,28 @@ const (
 	PongInterval = time.Second * 15
 	// PongTimeout is the maximum time allowed waiting for a keepalive pong.
 	PongTimeout = time.Second * 10
+	// MaxReplay is the maximum number of unacknowledged, non-critical
+	// messages kept for replay to a client. Critical messages are never
+	// discarded from the replay buffer until acknowledged.
+	MaxReplay = 64
+	// MaxLatencyCompensation is the largest one-way latency which will ever
+	// be credited back to a client when scoring. Anything above this is
+	// assumed to be either a terrible connection or a client lying about its
+	// round trip time.
+	MaxLatencyCompensation = time.Millisecond * 250
 )
 
+// criticalCommands are the server message commands which must never be
+// silently dropped, as missing them would leave a client stuck on an old
+// screen.
+var criticalCommands = map[string]bool{
+	CommandGameCount:     true,
+	CommandQuestionCount: true,
+	CommandNewQuestion:   true,
+	CommandQuestionOver:  true,
+	CommandFinalResults:  true,
+}
+
 // Client errors.
 var (
 	ErrorConnectionClosed = fmt.Errorf("client: connection closed")
@@ -78,6 +134,144 @@ type Client struct {
 	send     chan string
 	conn     *websocket.Conn
 	lastPong time.Time
+	rtt      *roundTrip
+	box      *outbox
+	rec      *recipient
+}
+
+// outbox is the replay buffer of numbered messages sent to a client, which
+// survives across re-connections of the same client. Messages are kept until
+// acknowledged by the client, such that any lost while the client was
+// disconnected can be replayed when it resumes.
+//
+// Acknowledgements are cumulative, so messages must reach the client in the
+// order in which they were numbered. Sends are therefore serialised by
+// sending, which is held from numbering a message until it is handed to the
+// client's writer.
+type outbox struct {
+	sending sync.Mutex
+
+	mut     sync.Mutex
+	seq     uint64
+	pending []numbered
+}
+
+// numbered is a single formatted message held in an outbox.
+type numbered struct {
+	seq      uint64
+	critical bool
+	msg      string
+}
+
+// push numbers and formats a new message, storing it for replay.
+func (o *outbox) push(verb string, body interface{}) string {
+	o.mut.Lock()
+	defer o.mut.Unlock()
+
+	o.seq++
+	msg := FormatMessage(verb+"#"+strconv.FormatUint(o.seq, 10), body)
+	o.pending = append(o.pending, numbered{o.seq, criticalCommands[verb], msg})
+
+	// Evict the oldest non-critical message if over capacity
+	count := 0
+	for _, n := range o.pending {
+		if !n.critical {
+			count++
+		}
+	}
+	if count > MaxReplay {
+		for i, n := range o.pending {
+			if !n.critical {
+				o.pending = append(o.pending[:i], o.pending[i+1:]...)
+				break
+			}
+		}
+	}
+
+	return msg
+}
+
+// ack discards all messages up to and including seq, which the client has
+// confirmed receipt of.
+func (o *outbox) ack(seq uint64) {
+	o.mut.Lock()
+	defer o.mut.Unlock()
+
+	i := 0
+	for i < len(o.pending) && o.pending[i].seq <= seq {
+		i++
+	}
+	o.pending = o.pending[i:]
+}
+
+// unacked returns every message still awaiting acknowledgement, in the order
+// in which they were sent.
+func (o *outbox) unacked() []string {
+	o.mut.Lock()
+	defer o.mut.Unlock()
+
+	msgs := make([]string, len(o.pending))
+	for i, n := range o.pending {
+		msgs[i] = n.msg
+	}
+	return msgs
+}
+
+// roundTrip tracks the smoothed round trip time of a client connection, as
+// measured by the keepalive PING system. It is shared between every copy of a
+// Client, so must only be accessed through its methods.
+type roundTrip struct {
+	mut     sync.Mutex
+	smooth  time.Duration
+	samples int
+}
+
+// sample adds a new round trip measurement to the running average. The
+// average is smoothed in the same manner as TCP (RFC 6298), such that a single
+// slow PING does not wildly change the estimate.
+func (r *roundTrip) sample(d time.Duration) {
+	if r == nil || d < 0 || d > PongTimeout {
+		return
+	}
+
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	if r.samples == 0 {
+		r.smooth = d
+	} else {
+		r.smooth = (7*r.smooth + d) / 8
+	}
+	r.samples++
+}
+
+// get returns the current smoothed round trip time, or zero if no
+// measurements have yet been taken.
+func (r *roundTrip) get() time.Duration {
+	if r == nil {
+		return 0
+	}
+
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	return r.smooth
+}
+
+// RTT returns the smoothed round trip time to this client, or zero if it is
+// not yet known.
+func (c Client) RTT() time.Duration {
+	return c.rtt.get()
+}
+
+// Latency returns the estimated one-way latency to this client, which is half
+// of the round trip time, capped at MaxLatencyCompensation.
+func (c Client) Latency() time.Duration {
+	l := c.rtt.get() / 2
+	if l > MaxLatencyCompensation {
+		l = MaxLatencyCompensation
+	}
+
+	return l
 }
 
 func (c Client) writer(interval time.Duration) {
@@ -93,8 +287,12 @@ func (c Client) writer(interval time.Duration) {
 				return
 			}
 		case <-tick.C:
+			// PING body is the send time, which the client must echo
+			// back to us in the PONG, allowing us to measure the round
+			// trip time accurately.
 			log.Println("sending ping message to", c.conn.RemoteAddr())
-			err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(interval))
+			stamp := strconv.FormatInt(time.Now().UnixNano(), 10)
+			err := c.conn.WriteControl(websocket.PingMessage, []byte(stamp), time.Now().Add(interval))
 			if err != nil {
 				c.Cancel()
 				c.CloseReason("invalid ping packet: " + err.Error())
@@ -117,15 +315,74 @@ func (c Client) Send(msg string) {
 
 	select {
 	case c.send <- msg:
+		c.rec.sent(msg)
 	case <-c.Ctx.Done():
 	}
 }
 
+// offer queues msg for sending to the client without blocking, returning
+// false if the client is not keeping up with its messages. Only useful for
+// clients with a buffered send queue.
+func (c Client) offer(msg string) bool {
+	if !c.Connected {
+		return true
+	}
+
+	select {
+	case c.send <- msg:
+		c.rec.sent(msg)
+		return true
+	default:
+		return false
+	}
+}
+
 // SendMessage formats a message using FormatMessage and sends to the client.
+//
+// If the client has reliable delivery enabled, the message is numbered and
+// kept for replay until acknowledged, even if the client is currently
+// disconnected.
 func (c Client) SendMessage(verb string, body interface{}) {
+	if c.box != nil {
+		c.box.sending.Lock()
+		defer c.box.sending.Unlock()
+
+		c.Send(c.box.push(verb, body))
+		return
+	}
+
 	c.Send(FormatMessage(verb, body))
 }
 
+// Acknowledge handles an acknowledgement message from the client, which
+// contains the sequence number of the last message received.
+func (c Client) Acknowledge(data string) error {
+	seq, err := strconv.ParseUint(data, 10, 64)
+	if err != nil {
+		return fmt.Errorf("client: ack: %w", err)
+	}
+	if c.box != nil {
+		c.box.ack(seq)
+	}
+
+	return nil
+}
+
+// Replay re-sends every numbered message which the client has not yet
+// acknowledged. This should be called when a client resumes after a
+// disconnection, before any other messages are sent.
+func (c Client) Replay() {
+	if c.box == nil {
+		return
+	}
+
+	c.box.sending.Lock()
+	defer c.box.sending.Unlock()
+	for _, msg := range c.box.unacked() {
+		c.Send(msg)
+	}
+}
+
 // Open sets up the websocket connection for reading as a client. Among other
 // things, it sets up the read deadline and PING subsystem handlers.
 //
@@ -134,11 +391,15 @@ func (c Client) SendMessage(verb string, body interface{}) {
 func (c Client) Open() {
 	c.lastPong = time.Now()
 	c.conn.SetReadDeadline(c.lastPong.Add(PongInterval).Add(PongTimeout))
-	c.conn.SetPongHandler(func(_ string) error {
+	c.conn.SetPongHandler(func(body string) error {
 		latency := time.Now().Add(-PongInterval).Sub(c.lastPong)
 		if latency < 0 {
 			latency = 0 - latency
 		}
+		if sent, err := strconv.ParseInt(body, 10, 64); err == nil {
+			latency = time.Since(time.Unix(0, sent))
+			c.rtt.sample(latency)
+		}
 
 		log.Println("got pong response with latency", latency, "from", c.conn.RemoteAddr())
 		c.lastPong = time.Now()
@@ -149,9 +410,41 @@ func (c Client) Open() {
 	go c.writer(PongInterval)
 }
 
+// SyncClock responds to a clock synchronisation request from the client,
+// which contains the client's local time in milliseconds at the point of
+// sending. The client's time is echoed back alongside our own, allowing the
+// client to estimate the offset between the two clocks in the same manner as
+// NTP.
+//
+// The response is never numbered, as a stale response is useless to replay,
+// and must not wait behind other messages for the writer.
+func (c Client) SyncClock(data string) error {
+	var sent int64
+	if err := json.Unmarshal([]byte(data), &sent); err != nil {
+		return fmt.Errorf("client: clock sync: %w", err)
+	}
+
+	c.Send(FormatMessage(CommandClockSync, struct {
+		Client int64 `json:"client"`
+		Server int64 `json:"server"`
+	}{sent, Timestamp(time.Now())}))
+	return nil
+}
+
+// Timestamp returns t in the format used to communicate absolute times to the
+// client, which is milliseconds since the UNIX epoch.
+func Timestamp(t time.Time) int64 {
+	return t.UnixMilli()
+}
+
 // CloseReason gracefully tears down the connection with the specified teardown
 // message for the client.
 func (c Client) CloseReason(why string) {
+	// Clients replayed from an event log have no connection
+	if c.conn == nil {
+		return
+	}
+
 	c.conn.WriteControl(websocket.CloseMessage,
 		websocket.FormatCloseMessage(websocket.CloseNormalClosure, why),
 		time.Now().Add(time.Second*10))
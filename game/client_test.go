@@ -0,0 +1,104 @@
+package game
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOutboxAck(t *testing.T) {
+	o := new(outbox)
+	for i := 0; i < 5; i++ {
+		o.push(CommandNewPlayer, i)
+	}
+
+	o.ack(3)
+	msgs := o.unacked()
+	if len(msgs) != 2 {
+		t.Fatalf("expected 2 unacked messages, got %d", len(msgs))
+	}
+	if !strings.HasPrefix(msgs[0], CommandNewPlayer+"#4 ") {
+		t.Errorf("bad replay order: expected seq 4 first, got %q", msgs[0])
+	}
+
+	// Stale acknowledgement must not resurrect anything
+	o.ack(1)
+	if len(o.unacked()) != 2 {
+		t.Errorf("stale ack changed outbox")
+	}
+}
+
+func TestOutboxCritical(t *testing.T) {
+	o := new(outbox)
+	o.push(CommandNewQuestion, nil)
+	for i := 0; i < MaxReplay*2; i++ {
+		o.push(CommandNewPlayer, i)
+	}
+	o.push(CommandQuestionOver, nil)
+
+	msgs := o.unacked()
+	if len(msgs) != MaxReplay+2 {
+		t.Errorf("expected %d messages kept, got %d", MaxReplay+2, len(msgs))
+	}
+	if !strings.HasPrefix(msgs[0], CommandNewQuestion+"#1 ") {
+		t.Errorf("critical message evicted: first message is %q", msgs[0])
+	}
+	if !strings.HasPrefix(msgs[len(msgs)-1], CommandQuestionOver+"#") {
+		t.Errorf("critical message evicted: last message is %q", msgs[len(msgs)-1])
+	}
+}
+
+func TestOutboxOrder(t *testing.T) {
+	c := Client{
+		Connected: true,
+		Ctx:       context.Background(),
+		send:      make(chan string),
+		box:       new(outbox),
+	}
+	seq := func() uint64 {
+		c.box.mut.Lock()
+		defer c.box.mut.Unlock()
+		return c.box.seq
+	}
+
+	// While one message waits for the writer, no other may be numbered,
+	// as acknowledgements are cumulative and a later message overtaking it
+	// would acknowledge it unseen
+	go c.SendMessage(CommandNewPlayer, nil)
+	for seq() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	go c.SendMessage(CommandNewPlayer, nil)
+	time.Sleep(20 * time.Millisecond)
+	if n := seq(); n != 1 {
+		t.Fatalf("message %d numbered while message 1 unsent", n)
+	}
+
+	for want := 1; want <= 2; want++ {
+		msg := <-c.send
+		if !strings.HasPrefix(msg, CommandNewPlayer+"#"+strconv.Itoa(want)+" ") {
+			t.Errorf("expected message %d, got %q", want, msg)
+		}
+	}
+}
+
+func TestSyncClockUnnumbered(t *testing.T) {
+	c := Client{
+		Connected: true,
+		Ctx:       context.Background(),
+		send:      make(chan string, 1),
+		box:       new(outbox),
+	}
+
+	if err := c.SyncClock("1000"); err != nil {
+		t.Fatal(err)
+	}
+	if msg := <-c.send; !strings.HasPrefix(msg, CommandClockSync+" ") {
+		t.Errorf("clock sync sent as %q, expected unnumbered", msg)
+	}
+	if n := len(c.box.unacked()); n != 0 {
+		t.Errorf("clock sync kept for replay: %d unacked messages", n)
+	}
+}
@@ -1,63 +1,97 @@
 package game
 
 import (
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"log"
-	"math/rand"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/ejv2/gahoot/game/quiz"
 )
 
-// Gameplay constants with no need for configuration.
+// Coordinator defaults.
 const (
-	MinGamePin = 1111111111
-	MaxGamePin = 4294967295
+	// PinCooldownTime is the default time for which a PIN is withheld
+	// after its game ends.
+	PinCooldownTime = time.Minute * 30
+	// DrainTime is the default time for which games may carry on when the
+	// server is shutting down.
+	DrainTime = time.Minute * 5
+	// drainGrace is the time allowed after the drain deadline for games to
+	// send their results and shut down.
+	drainGrace = time.Second * 10
+	// pinAttempts is the number of random PINs tried for a new game before
+	// giving up.
+	pinAttempts = 1000
 )
 
-// Pin is the 10-digit PIN which can be used to join a game.
-// It is constrained at an int32, as that is the smallest int type which
-// provides enough space without allowing any higher inputs.
-type Pin uint32
-
-// String properly formats the game PIN such that any leading digits are
-// displayed properly as zeroes.
-func (p Pin) String() string {
-	return fmt.Sprintf("%010d", p)
-}
+// Coordinator errors.
+var (
+	ErrorTooManyGames     = fmt.Errorf("game: too many games running")
+	ErrorTooManyHostGames = fmt.Errorf("game: too many games from this address")
+	ErrorDraining         = fmt.Errorf("game: server is shutting down")
+	ErrorNoPins           = fmt.Errorf("game: no free game PINs")
+	ErrorNoGame           = fmt.Errorf("game: no such game")
+	ErrorGameBusy         = fmt.Errorf("game: game runner not responding")
+)
 
-// Validate returns true if a game pin is within the required limits.
-func (p Pin) Validate() bool {
-	return p < MaxGamePin && p < MinGamePin
-}
+// generateToken generates a random, unguessable secret token suitable for
+// authenticating a client to a game.
+func generateToken() string {
+	var buf [16]byte
+	if _, err := crand.Read(buf[:]); err != nil {
+		panic("generatetoken: system random source failed: " + err.Error())
+	}
 
-// generatePin generates a pseudorandom game PIN between MinGamePin and
-// MaxGamePin, ensuring to eliminate possible overflows.
-func generatePin() Pin {
-	return Pin(rand.Int63n(MaxGamePin-MinGamePin) + MinGamePin)
+	return hex.EncodeToString(buf[:])
 }
 
 // Coordinator is responsible for managing all ongoing games in order to
 // receive and delegate incoming events.
 type Coordinator struct {
-	mut        *sync.RWMutex // protects games
-	games      map[Pin]Game
+	mut   *sync.RWMutex // protects games, hosts, cooldown and draining
+	games map[Pin]Game
+	hosts map[Pin]string
+	// Recently reaped PINs which may not yet be reused, and the time at
+	// which they become available again.
+	cooldown   map[Pin]time.Time
 	reapNotify chan Pin
+	// Set once the server begins shutting down, after which no more games
+	// may be created.
+	draining bool
 
-	maxTime time.Duration
+	settings Settings
 }
 
 // NewCoordinator allocates and returns a new game coordinator with a blank
 // initial game map.
-func NewCoordinator(maxGameTime time.Duration) Coordinator {
+func NewCoordinator(settings Settings) Coordinator {
+	if settings.PinCooldown == 0 {
+		settings.PinCooldown = PinCooldownTime
+	}
+	if settings.PinLength == 0 {
+		settings.PinLength = MaxPinLength
+	}
+	if settings.DrainTimeout == 0 {
+		settings.DrainTimeout = DrainTime
+	}
+
 	c := Coordinator{
 		mut:        new(sync.RWMutex),
 		games:      make(map[Pin]Game),
+		hosts:      make(map[Pin]string),
+		cooldown:   make(map[Pin]time.Time),
 		reapNotify: make(chan Pin),
-		maxTime:    maxGameTime,
+		settings:   settings,
 	}
 	go c.reaper()
+	if settings.Snapshots != nil {
+		c.restore()
+	}
 
 	return c
 }
@@ -68,32 +102,299 @@ func NewCoordinator(maxGameTime time.Duration) Coordinator {
 // closing).
 func (c *Coordinator) reaper() {
 	for pin := range c.reapNotify {
+		now := time.Now()
 		c.mut.Lock()
 		delete(c.games, pin)
+		delete(c.hosts, pin)
+		for p, until := range c.cooldown {
+			if now.After(until) {
+				delete(c.cooldown, p)
+			}
+		}
+		c.cooldown[pin] = now.Add(c.settings.PinCooldown)
 		c.mut.Unlock()
 
 		log.Println("Reaper: game died:", pin)
 	}
 }
 
-// CreateGame creates a new game blank game with no players waiting for a host
-// connection, generating a random PIN by continually regenerating a random PIN
-// until a free one is found. If the maximum concurrent games are running,
-// blocks until one is available (which hopefully should occur *very* rarely).
-func (c *Coordinator) CreateGame(q quiz.Quiz) Game {
-	p := generatePin()
-	for c.GameExists(p) {
-		p = generatePin()
+// restore restores every game with a saved snapshot, keeping its original PIN.
+// Restored games wait for their host to reconnect and, if running, are paused
+// until the host resumes them.
+func (c *Coordinator) restore() {
+	store := c.settings.Snapshots
+	pins, err := store.List()
+	if err != nil {
+		log.Println("could not list game snapshots:", err)
+		return
 	}
 
-	g := NewGame(p, q, c.reapNotify, c.maxTime)
+	for _, p := range pins {
+		snap, err := store.Load(p)
+		if err != nil {
+			log.Println("could not load snapshot of", p+":", err)
+			continue
+		}
+		g, err := restoreGame(snap, c.reapNotify, c.settings)
+		if err != nil {
+			log.Println("could not restore", p+":", err)
+			store.Remove(p)
+			continue
+		}
+
+		c.mut.Lock()
+		c.games[g.PIN] = g
+		c.hosts[g.PIN] = g.creator
+		c.mut.Unlock()
+
+		go g.Run()
+		log.Println("Restored game", g.PIN, "from snapshot taken", snap.Taken.Format(time.RFC1123))
+	}
+}
+
+// CreateGame creates a new game from q with the host's chosen options, which
+// waits with no players for its host to connect. Its PIN is chosen at random,
+// trying again until one is found which is neither in use nor cooling down
+// after a previous game, or returning ErrorNoPins if none is found after
+// pinAttempts tries. The address of the creating client, addr, and the
+// account to which it is logged in, owner, are recorded against the game. If
+// the maximum number of concurrent games are already running, either in total
+// or from addr, returns ErrorTooManyGames or ErrorTooManyHostGames. Once the
+// coordinator is draining, returns ErrorDraining.
+func (c *Coordinator) CreateGame(q quiz.Quiz, opts Options, addr, owner string) (Game, error) {
 	c.mut.Lock()
+	if err := c.checkLimits(addr); err != nil {
+		c.mut.Unlock()
+		return Game{}, err
+	}
+
+	p := generatePin(c.settings.PinLength)
+	for i := 1; !c.pinFree(p); i++ {
+		if i == pinAttempts {
+			c.mut.Unlock()
+			return Game{}, ErrorNoPins
+		}
+		p = generatePin(c.settings.PinLength)
+	}
+
+	g := NewGame(p, q, c.reapNotify, c.settings)
+	g.Options = opts
+	g.creator = addr
+	g.Owner = owner
 	c.games[g.PIN] = g
+	c.hosts[g.PIN] = addr
+	ret := c.games[g.PIN]
 	c.mut.Unlock()
 
 	// NOTE: Must return copy from map here, or subtle data race caused
 	go g.Run()
-	return c.games[g.PIN]
+	return ret, nil
+}
+
+// pinFree returns true if p may be given to a new game. The caller must hold
+// c.mut.
+func (c Coordinator) pinFree(p Pin) bool {
+	if _, ok := c.games[p]; ok {
+		return false
+	}
+	until, ok := c.cooldown[p]
+	return !ok || time.Now().After(until)
+}
+
+// checkLimits returns an error if another game may not be created from addr.
+// The caller must hold c.mut.
+func (c Coordinator) checkLimits(addr string) error {
+	if c.draining {
+		return ErrorDraining
+	}
+	if c.settings.MaxGames > 0 && len(c.games) >= c.settings.MaxGames {
+		return ErrorTooManyGames
+	}
+	if c.settings.MaxHostGames <= 0 {
+		return nil
+	}
+
+	n := 0
+	for _, a := range c.hosts {
+		if a == addr {
+			n++
+		}
+	}
+	if n >= c.settings.MaxHostGames {
+		return ErrorTooManyHostGames
+	}
+	return nil
+}
+
+// Drain prepares the coordinator for the server shutting down. No more games
+// may be created, and every game is warned that the server is shutting down.
+// Games may carry on for the drain timeout, after which any still running are
+// ended, with their final results sent out and archived. Drain blocks until
+// every game has ended or ctx is done, and gives up shortly after the drain
+// timeout if any game fails to end.
+func (c *Coordinator) Drain(ctx context.Context) error {
+	deadline := time.Now().Add(c.settings.DrainTimeout)
+	ctx, cancel := context.WithDeadline(ctx, deadline.Add(drainGrace))
+	defer cancel()
+
+	c.mut.Lock()
+	c.draining = true
+	games := make([]Game, 0, len(c.games))
+	for _, g := range c.games {
+		games = append(games, g)
+	}
+	c.mut.Unlock()
+
+	log.Println("Draining", len(games), "games until", deadline.Format(time.Kitchen))
+	for _, g := range games {
+		select {
+		case g.Action <- Drain{deadline}:
+		case <-g.ctx.Done():
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	tick := time.NewTicker(100 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		c.mut.RLock()
+		n := len(c.games)
+		c.mut.RUnlock()
+		if n == 0 {
+			return nil
+		}
+
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// GameInfo describes a game for the server administrator.
+type GameInfo struct {
+	PIN      Pin       `json:"pin"`
+	Title    string    `json:"title"`
+	Created  time.Time `json:"created"`
+	HostAddr string    `json:"host_addr"`
+	Owner    string    `json:"owner,omitempty"`
+	// Busy is set if the game runner did not respond in time, in which
+	// case Status and Players are unknown.
+	Busy    bool   `json:"busy"`
+	Status  Status `json:"status"`
+	Players int    `json:"players"`
+}
+
+// ListGames describes every game, ordered by PIN. Each game runner is asked
+// for its state at once, waiting at most timeout for them to respond.
+func (c Coordinator) ListGames(timeout time.Duration) []GameInfo {
+	return c.listGames(timeout, func(Game) bool { return true })
+}
+
+// OwnedGames describes every game created by the account owner, as does
+// ListGames.
+func (c Coordinator) OwnedGames(owner string, timeout time.Duration) []GameInfo {
+	return c.listGames(timeout, func(g Game) bool { return g.Owner == owner })
+}
+
+// listGames describes every game for which match returns true.
+func (c Coordinator) listGames(timeout time.Duration, match func(Game) bool) []GameInfo {
+	c.mut.RLock()
+	games := make([]Game, 0, len(c.games))
+	infos := make([]GameInfo, 0, len(c.games))
+	for pin, g := range c.games {
+		if !match(g) {
+			continue
+		}
+		games = append(games, g)
+		infos = append(infos, GameInfo{
+			PIN:      pin,
+			Title:    g.Title,
+			Created:  g.Created,
+			HostAddr: c.hosts[pin],
+			Owner:    g.Owner,
+		})
+	}
+	c.mut.RUnlock()
+
+	var wg sync.WaitGroup
+	for i := range games {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			st, err := games[i].Inspect(timeout)
+			if err != nil {
+				infos[i].Busy = true
+				return
+			}
+			infos[i].Status = st.Status
+			for _, plr := range st.Players {
+				if !plr.Removed {
+					infos[i].Players++
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].PIN < infos[j].PIN
+	})
+	return infos
+}
+
+// HostAddr returns the address from which the game with the given PIN was
+// created.
+func (c Coordinator) HostAddr(pin Pin) string {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+
+	return c.hosts[pin]
+}
+
+// Inspect returns a copy of the current state of the game, including its
+// players and host, waiting at most timeout for the game runner to respond. Returns ErrorGameBusy if it did
+// not, or ErrorNoGame if the game has ended.
+func (g Game) Inspect(timeout time.Duration) (State, error) {
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+
+	// Buffered, such that the runner is not left blocked if we give up
+	req := make(chan State, 1)
+	select {
+	case g.Request <- req:
+	case <-g.ctx.Done():
+		return State{}, ErrorNoGame
+	case <-t.C:
+		return State{}, ErrorGameBusy
+	}
+
+	select {
+	case st := <-req:
+		return st, nil
+	case <-t.C:
+		return State{}, ErrorGameBusy
+	}
+}
+
+// Submit submits act to the game runner, waiting at most timeout for it to
+// be accepted. Returns ErrorGameBusy if it was not, or ErrorNoGame if the game
+// has ended.
+func (g Game) Submit(act Action, timeout time.Duration) error {
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+
+	select {
+	case g.Action <- act:
+		return nil
+	case <-g.ctx.Done():
+		return ErrorNoGame
+	case <-t.C:
+		return ErrorGameBusy
+	}
 }
 
 // GetGame does a thread safe lookup in the game map for the specified PIN.
@@ -0,0 +1,8 @@
+// Package events implements the event log kept for each game, which records
+// every action applied by the game runner and every message sent to a client,
+// in order and with the time at which it happened.
+//
+// Logs are append-only files of JSON lines, one event per line. The first
+// event of a log describes how the game was set up, such that the game can
+// later be replayed from the log to audit disputes or reproduce bugs.
+package events
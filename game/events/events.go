@@ -0,0 +1,171 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event kinds.
+const (
+	// KindStart is the first event in every log. Its data describes how
+	// the game was set up.
+	KindStart = "start"
+	// KindAction is an action applied by the game runner. Its data is the
+	// action itself.
+	KindAction = "action"
+	// KindSend is a message sent to a client. Its data is the message as
+	// sent.
+	KindSend = "send"
+	// KindEnd is the last event in a log, written when the game ends.
+	KindEnd = "end"
+)
+
+// Event log errors.
+var (
+	ErrClosed  = errors.New("events: log closed")
+	ErrInvalid = errors.New("events: invalid PIN or game ID")
+	ErrNoStart = errors.New("events: log does not begin with a start event")
+)
+
+// Event is a single entry in an event log.
+type Event struct {
+	// Seq numbers each event in a log, from one.
+	Seq  uint64    `json:"seq"`
+	Time time.Time `json:"time"`
+	Kind string    `json:"kind"`
+	// Type is the type of action, for action events.
+	Type string `json:"type,omitempty"`
+	// To is the client to which a message was sent, for send events.
+	To   string          `json:"to,omitempty"`
+	Data json.RawMessage `json:"data,omitempty"`
+	// Draws are the values which an action took from outside the game,
+	// such as random names or measured latencies, in the order taken.
+	Draws []string `json:"draws,omitempty"`
+	// Check summarises the game state after an action or read, so that a
+	// replay can tell if it has gone astray.
+	Check string `json:"check,omitempty"`
+}
+
+// Store is a directory of event logs on disk.
+type Store struct {
+	dir string
+}
+
+// NewStore opens a store of event logs in dir, creating it if needed.
+func NewStore(dir string) (Store, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return Store{}, fmt.Errorf("events: %w", err)
+	}
+
+	return Store{dir}, nil
+}
+
+// Path returns the file in which the log for pin and id is stored.
+func (s Store) Path(pin, id string) (string, error) {
+	if !digits(pin, "0123456789") || !digits(id, "0123456789abcdef") {
+		return "", ErrInvalid
+	}
+
+	return filepath.Join(s.dir, pin+"-"+id+".log"), nil
+}
+
+// digits returns true if s is non-empty and made up only of runes in set.
+func digits(s, set string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !strings.ContainsRune(set, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// Create creates a new, empty log for the game with the given PIN and ID. It
+// is an error for the log to already exist.
+func (s Store) Create(pin, id string) (*Log, error) {
+	path, err := s.Path(pin, id)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_EXCL, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("events: %w", err)
+	}
+	return &Log{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// Log is an open event log, to which events may only be appended. It is safe
+// for concurrent use.
+type Log struct {
+	mut sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+	seq uint64
+}
+
+// Append numbers e and writes it to the end of the log. If e has no time, it
+// is given the current time.
+func (l *Log) Append(e Event) error {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	if l.f == nil {
+		return ErrClosed
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	l.seq++
+	e.Seq = l.seq
+	if err := l.enc.Encode(e); err != nil {
+		return fmt.Errorf("events: %w", err)
+	}
+	return nil
+}
+
+// Close closes the log. Events appended after closing are discarded.
+func (l *Log) Close() error {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	if l.f == nil {
+		return ErrClosed
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
+
+// Read reads every event from a log, checking that it begins with a start
+// event. A log cut short mid-event, such as by a crash, is read up to the last
+// complete event.
+func Read(r io.Reader) ([]Event, error) {
+	var evs []Event
+	dec := json.NewDecoder(r)
+	for {
+		var e Event
+		err := dec.Decode(&e)
+		if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("events: event %d: %w", len(evs)+1, err)
+		}
+		evs = append(evs, e)
+	}
+
+	if len(evs) == 0 || evs[0].Kind != KindStart {
+		return nil, ErrNoStart
+	}
+	return evs, nil
+}
@@ -0,0 +1,80 @@
+package events
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestLog(t *testing.T) {
+	s, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := s.Create("1234567890", "0123456789abcdef")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Create("1234567890", "0123456789abcdef"); err == nil {
+		t.Error("created the same log twice")
+	}
+
+	for _, e := range []Event{
+		{Kind: KindStart, Data: []byte(`{"pin":1234567890}`)},
+		{Kind: KindAction, Type: "Answer", Data: []byte(`{"PlayerID":1,"Number":2}`), Draws: []string{"0"}},
+		{Kind: KindSend, To: "player 1", Data: []byte(`"ansack {}"`)},
+		{Kind: KindEnd},
+	} {
+		if err := l.Append(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Append(Event{Kind: KindSend}); err != ErrClosed {
+		t.Errorf("append after close: got %v, expected %v", err, ErrClosed)
+	}
+
+	path, _ := s.Path("1234567890", "0123456789abcdef")
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	evs, err := Read(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evs) != 4 {
+		t.Fatalf("read %d events, expected 4", len(evs))
+	}
+	for i, e := range evs {
+		if e.Seq != uint64(i+1) || e.Time.IsZero() {
+			t.Errorf("event %d: got sequence %d and time %v", i, e.Seq, e.Time)
+		}
+	}
+	if evs[1].Type != "Answer" || len(evs[1].Draws) != 1 || evs[2].To != "player 1" {
+		t.Errorf("events did not round trip: %+v", evs)
+	}
+
+	// A crash may leave the last event half written
+	evs, err = Read(bytes.NewReader(buf[:len(buf)-5]))
+	if err != nil || len(evs) != 3 {
+		t.Errorf("reading truncated log: got %d events and %v, expected 3", len(evs), err)
+	}
+	if _, err := Read(bytes.NewReader(buf[bytes.IndexByte(buf, '\n')+1:])); err != ErrNoStart {
+		t.Errorf("reading log without start: got %v, expected %v", err, ErrNoStart)
+	}
+}
+
+func TestPath(t *testing.T) {
+	s := Store{"logs"}
+	for _, tc := range [][2]string{
+		{"", "0123"}, {"1234", ""}, {"../1234", "0123"}, {"1234", "0123/.."}, {"1234", "ABCD"},
+	} {
+		if _, err := s.Path(tc[0], tc[1]); err != ErrInvalid {
+			t.Errorf("path for %q, %q: got %v, expected %v", tc[0], tc[1], err, ErrInvalid)
+		}
+	}
+}
@@ -2,10 +2,16 @@ package game
 
 import (
 	"context"
+	"fmt"
+	"log"
 	"math"
 	"time"
 
+	"github.com/ejv2/gahoot/game/events"
+	"github.com/ejv2/gahoot/game/nick"
 	"github.com/ejv2/gahoot/game/quiz"
+	"github.com/ejv2/gahoot/game/results"
+	"github.com/ejv2/gahoot/game/snapshot"
 )
 
 // Possible game states.
@@ -25,13 +31,40 @@ const (
 // details.
 type Status int
 
+// String returns a short description of the status.
+func (s Status) String() string {
+	switch s {
+	case GameHostWaiting:
+		return "waiting for host"
+	case GameWaiting:
+		return "lobby"
+	case GameRunning:
+		return "running"
+	case GameDead:
+		return "dead"
+	default:
+		return "unknown"
+	}
+}
+
 // Gameplay constants.
 const (
-	MaxGameTime    = time.Minute * 45
-	MinPlayers     = 3
-	BasePoints     = 1000
-	StreakBonus    = 100
-	MaxStreakBonus = 500
+	MaxGameTime     = time.Minute * 45
+	HostGraceTime   = time.Minute * 2
+	ClaimTime       = time.Minute
+	MaxExtraTime    = time.Minute * 5
+	MinPlayers      = 3
+	BasePoints      = 1000
+	StreakBonus     = 100
+	MaxStreakBonus  = 500
+	LeaderboardClip = 6
+	// MaxRerolls is the number of times a player may ask for a new name
+	// when names are generated.
+	MaxRerolls = 3
+	// AnswerUpdateInterval is the minimum time between answer progress
+	// updates sent to the host, such that a large game does not flood the
+	// host with one message per answer.
+	AnswerUpdateInterval = time.Millisecond * 250
 )
 
 // StateFunc is a current state in the finite state machine of the game state.
@@ -49,11 +82,26 @@ type State struct {
 	Players         []Player
 	CurrentQuestion int
 
-	// Caches the used names in the current game.
+	// Caches the skeletons of the used names in the current game.
 	namecache map[string]struct{}
+	// Maps player session tokens to player IDs.
+	tokens map[string]int
+	// Banned client addresses and session tokens.
+	bannedAddrs  map[string]struct{}
+	bannedTokens map[string]struct{}
+	// Record of moderation actions taken by the host.
+	modlog []ModEntry
+	// Currently connected spectators, by spectator ID.
+	spectators map[int]Spectator
+	// Last allocated spectator or remote ID.
+	nextSpectator int
+	// Currently connected remote control, if any.
+	remote *Remote
 
 	// Has the host completed the countdown?
 	countdownDone bool
+	// Did the host complete the countdown while paused?
+	countdownPending bool
 	// Curently in answer time?
 	acceptingAnswers bool
 	// Is this the last player?
@@ -61,15 +109,123 @@ type State struct {
 	lastPlayer bool
 	// Has the host skipped the question?
 	questionSkipped bool
+	// Have the results for the current question been sent?
+	questionDone bool
+	// Has the host paused the game?
+	paused bool
+	// Time at which the game was paused.
+	pausedAt time.Time
+	// Is an answer progress update waiting to be sent to the host?
+	progressPending bool
+	// Additional answer time granted by the host for this question.
+	extraTime time.Duration
 	// Time at which answers begin being accepted.
 	// Used to calculate points bonus from time taken.
 	answersAt time.Time
+	// Time at which the game was started.
+	startedAt time.Time
+	// Time at which the host's connection was lost, or zero if the host
+	// is connected.
+	hostLostAt time.Time
+}
+
+// Game errors.
+var (
+	ErrorNickTaken = fmt.Errorf("game: nickname already in use")
+	ErrorBanned    = fmt.Errorf("game: banned from this game")
+	ErrorGameFull  = fmt.Errorf("game: game is full")
+	ErrorJoinBusy  = fmt.Errorf("game: too many players joining")
+)
+
+// Settings are the server-wide gameplay settings, configured by the
+// administrator, which apply to every game run by a Coordinator.
+type Settings struct {
+	// MaxGameTime is the maximum time a game may run for. If zero,
+	// defaults to MaxGameTime.
+	MaxGameTime time.Duration
+	// LatencyCompensation enables crediting each player's estimated
+	// network latency back to them when timing answers.
+	LatencyCompensation bool
+	// HostGracePeriod is the time for which a game is held after the host
+	// disconnects, waiting for it to reconnect. If zero, defaults to
+	// HostGraceTime.
+	HostGracePeriod time.Duration
+	// ClaimTimeout is the time for which a player's slot and nickname are
+	// reserved after joining, waiting for their websocket to connect. If
+	// zero, defaults to ClaimTime.
+	ClaimTimeout time.Duration
+	// PinCooldown is the time for which a game's PIN may not be reused
+	// after the game ends, such that stale links do not lead into another
+	// game. If zero, defaults to PinCooldownTime.
+	PinCooldown time.Duration
+	// PinLength is the number of digits in new game PINs, including the
+	// check digit. It must be between MinPinLength and MaxPinLength. If
+	// zero, defaults to MaxPinLength.
+	PinLength int
+	// Nicknames is the policy which decides which nicknames players may
+	// use.
+	Nicknames nick.Policy
+
+	// MaxGames is the maximum number of games which may run at once, and
+	// MaxHostGames the maximum created from any one address. MaxPlayers is
+	// the maximum number of players in any game, and MaxPending the number
+	// of those which may be joining but not yet connected. Zero means no
+	// limit.
+	MaxGames, MaxHostGames int
+	MaxPlayers, MaxPending int
+
+	// Archive is where the results of each game are saved once it ends.
+	// If nil, results are not kept.
+	Archive *results.Store `json:"-"`
+	// Events is where the event log of each game is kept. If nil, games
+	// are not logged.
+	Events *events.Store `json:"-"`
+	// Snapshots is where running games are saved every SnapshotInterval,
+	// such that they can be restored after a restart. If nil, games are
+	// lost if the server stops. If SnapshotInterval is zero, defaults to
+	// SnapshotInterval.
+	Snapshots        *snapshot.Store `json:"-"`
+	SnapshotInterval time.Duration
+	// DrainTimeout is the time for which running games may carry on when
+	// the server is shutting down, before they are ended. If zero,
+	// defaults to DrainTime.
+	DrainTimeout time.Duration
+}
+
+// Options are the per-game options chosen by the host when creating a game.
+type Options struct {
+	// ApproveJoins holds each joining player in a waiting room until the
+	// host approves or rejects them.
+	ApproveJoins bool
+	// GenerateNames gives each joining player a random friendly name,
+	// rather than letting them choose their own.
+	GenerateNames bool
 }
 
 // Game is a single instance of a running game.
 type Game struct {
 	PIN Pin
+	// ID uniquely identifies this game, unlike its PIN, which is reused.
+	// It is secret, as it grants access to the game's archived results.
+	ID string
+	// Created is the time at which the game was created.
+	Created time.Time
+	// Owner is the name of the account which created the game, or empty
+	// if it was created without logging in.
+	Owner string
 	quiz.Quiz
+	Settings
+	Options
+
+	// HostToken is the secret which the host must present to connect, or
+	// reconnect, to the game.
+	HostToken string
+	// WatchToken is the secret which spectators must present to watch the
+	// game.
+	WatchToken string
+	// RemoteToken is the secret which a remote control must present to
+	// connect to the game.
+	RemoteToken string
 
 	Action  chan Action
 	Request chan chan State
@@ -79,23 +235,57 @@ type Game struct {
 	cancel context.CancelFunc
 	state  State
 	sf     StateFunc
+
+	// Event log, or nil if the game is not logged.
+	events *events.Log
+	// Time at which the current step of the runner began.
+	at time.Time
+	// Values drawn during the current step or, when replaying, those
+	// still to be drawn.
+	draws     []string
+	replaying bool
+
+	// Address from which the game was created.
+	creator string
+	// Snapshots waiting to be saved, and whether the game has changed
+	// since the last was taken.
+	snapshots chan snapshot.Snapshot
+	dirty     bool
 }
 
-func NewGame(pin Pin, quiz quiz.Quiz, reaper chan Pin, maxGameTime time.Duration) Game {
-	if maxGameTime == 0 {
-		maxGameTime = MaxGameTime
+func NewGame(pin Pin, quiz quiz.Quiz, reaper chan Pin, settings Settings) Game {
+	if settings.MaxGameTime == 0 {
+		settings.MaxGameTime = MaxGameTime
+	}
+	if settings.HostGracePeriod == 0 {
+		settings.HostGracePeriod = HostGraceTime
+	}
+	if settings.ClaimTimeout == 0 {
+		settings.ClaimTimeout = ClaimTime
+	}
+	if settings.SnapshotInterval == 0 {
+		settings.SnapshotInterval = SnapshotInterval
 	}
 
-	c, cancel := context.WithTimeout(context.Background(), maxGameTime)
-	return Game{
-		PIN:     pin,
-		Quiz:    quiz,
-		reaper:  reaper,
-		ctx:     c,
-		cancel:  cancel,
-		Action:  make(chan Action),
-		Request: make(chan chan State),
+	c, cancel := context.WithTimeout(context.Background(), settings.MaxGameTime)
+	game := Game{
+		PIN:         pin,
+		ID:          generateToken(),
+		Created:     time.Now(),
+		Quiz:        quiz,
+		Settings:    settings,
+		HostToken:   generateToken(),
+		WatchToken:  generateToken(),
+		RemoteToken: generateToken(),
+		reaper:      reaper,
+		ctx:         c,
+		cancel:      cancel,
+		Action:      make(chan Action),
+		Request:     make(chan chan State),
 	}
+	game.openLog()
+
+	return game
 }
 
 // Score returns the number of points that should be awarded for an answer.
@@ -133,24 +323,135 @@ func Score(correct bool, base, streak int, taken, allowed time.Duration) int64 {
 // reaper channel it was initialised with.
 func (game *Game) Run() {
 	defer func() {
+		if game.state.Status == GameRunning && game.Archive != nil {
+			game.archive()
+		}
+		game.closeLog()
+		if game.snapshots != nil {
+			close(game.snapshots)
+		}
+
 		game.state.Status = GameDead
 		game.reaper <- game.PIN
 		game.cancel()
 	}()
 
+	var tick <-chan time.Time
+	if game.Snapshots != nil {
+		t := time.NewTicker(game.SnapshotInterval)
+		defer t.Stop()
+		tick = t.C
+
+		game.snapshots = make(chan snapshot.Snapshot, 1)
+		go snapshotter(game.Snapshots, game.PIN, game.snapshots)
+	}
+
 	// Loop until our statefunc tells us that we are dead (nil state).
 	//
-	// We begin in the WaitForHost state, as the host will still be waiting
-	// on our connection, after which we wait for players.
-	for game.sf = game.WaitForHost; game.sf != nil; game.sf = game.sf() {
+	// A new game begins in the WaitForHost state, as the host will still be
+	// waiting on our connection, after which we wait for players.
+	game.recordStart()
+	for game.sf = game.entry(); game.sf != nil; {
 		select {
 		case <-game.ctx.Done():
 			return
 		case act := <-game.Action:
-			act.Perform(game)
+			game.step(act)
 		case req := <-game.Request:
-			req <- game.state
+			// Players and the host are copied, as the runner carries
+			// on changing them while the state is read
+			st := game.state
+			st.Players = append([]Player(nil), st.Players...)
+			if st.Host != nil {
+				host := *st.Host
+				st.Host = &host
+			}
+			// Reading the state changes nothing, so is neither
+			// logged nor snapshotted
+			req <- st
+		case <-tick:
+			if game.dirty {
+				game.saveSnapshot()
+			}
+		}
+	}
+}
+
+// archive saves the final results of the game to the archive. The write is
+// done in the background, so does not hold up the game runner.
+func (game *Game) archive() {
+	res := results.Result{
+		ID:        game.ID,
+		PIN:       game.PIN.String(),
+		Title:     game.Title,
+		Owner:     game.Owner,
+		Questions: make([]string, len(game.Questions)),
+		Started:   game.state.startedAt,
+		Finished:  time.Now(),
+	}
+	for i, q := range game.Questions {
+		res.Questions[i] = q.Title
+	}
+	for i, info := range NewLeaderboard(game.state.Players) {
+		res.Players = append(res.Players, results.Player{
+			Rank:    i + 1,
+			Nick:    info.Nick,
+			Score:   info.Score,
+			Correct: info.Correct,
+			Answers: game.state.Players[info.ID-1].answers,
+		})
+	}
+
+	go func(store *results.Store) {
+		if err := store.Save(res); err != nil {
+			log.Println(game.PIN, "could not archive results:", err)
 		}
+	}(game.Archive)
+}
+
+// schedule submits act to the game runner after d has elapsed, unless the game
+// has ended by then. It does not block the caller.
+func (game *Game) schedule(d time.Duration, act Action) {
+	action, done := game.Action, game.ctx.Done()
+	time.AfterFunc(d, func() {
+		select {
+		case action <- act:
+		case <-done:
+		}
+	})
+}
+
+// answerWindow returns the total time allowed for answering the current
+// question, including any extensions granted by the host.
+func (game *Game) answerWindow() time.Duration {
+	dur := time.Duration(game.Questions[game.state.CurrentQuestion].Duration) * time.Second
+	return dur + game.state.extraTime
+}
+
+// deadline returns the time at which the current answer window closes. Only
+// valid while accepting answers.
+func (game *Game) deadline() time.Time {
+	return game.state.answersAt.Add(game.answerWindow())
+}
+
+// gotoQuestion moves the game to the countdown for question i (zero indexed),
+// resetting all per-question state.
+func (game *Game) gotoQuestion(i int) {
+	game.sf = game.Question
+	game.state.CurrentQuestion = i
+
+	game.state.countdownDone = false
+	game.state.countdownPending = false
+	game.state.acceptingAnswers = false
+	game.state.lastPlayer = false
+	game.state.questionSkipped = false
+	game.state.questionDone = false
+	game.state.progressPending = false
+	game.state.extraTime = 0
+	for i := range game.state.Players {
+		game.state.Players[i].canAnswer = false
+		game.state.Players[i].answer = 0
+		game.state.Players[i].answeredAt = time.Time{}
 	}
 }
 
@@ -171,32 +472,37 @@ func (game *Game) WaitForHost() StateFunc {
 // snapshot of the current player state is taken such that new players do
 // not disrupt the existing players' game.
 func (game *Game) Question() StateFunc {
-	if game.state.countdownDone {
-		game.state.acceptingAnswers = true
-		return game.AcceptAnswers
-	}
-
-	q := struct {
-		quiz.Question
-		Index int `json:"index"`
-		Total int `json:"total"`
-	}{
+	q := QuestionInfo{
 		game.Questions[game.state.CurrentQuestion],
 		game.state.CurrentQuestion + 1,
 		len(game.Questions),
 	}
+	game.spectate(CommandNewQuestion, q)
 	go game.state.Host.SendMessage(CommandNewQuestion, q)
 
+	ends := Timestamp(game.now().Add(5 * time.Second))
 	for i, plr := range game.state.Players {
-		if plr.Connected {
+		if plr.Connected && !plr.Pending {
 			game.state.Players[i].canAnswer = true
 			go plr.SendMessage(CommandQuestionCount, struct {
-				Count int `json:"count"`
-			}{5})
+				Count int   `json:"count"`
+				Ends  int64 `json:"ends"`
+			}{5, ends})
 		}
 	}
 
-	return game.sf
+	return game.QuestionCountdown
+}
+
+// QuestionCountdown is active while the question is shown, waiting for the
+// host to complete the countdown before answers are accepted.
+func (game *Game) QuestionCountdown() StateFunc {
+	if game.state.countdownDone {
+		game.state.acceptingAnswers = true
+		return game.AcceptAnswers
+	}
+
+	return game.QuestionCountdown
 }
 
 // AcceptAnswers is active when the game is idle accepting answers until
@@ -222,28 +528,34 @@ func (game *Game) AcceptAnswers() StateFunc {
 		game.state.lastPlayer = true
 	}
 
+	// The answer window is frozen while paused, so keep waiting even if
+	// nobody is left to answer
 	game.state.acceptingAnswers = true
+	if game.state.paused && !game.state.questionSkipped {
+		return game.AcceptAnswers
+	}
 	if !pending || game.state.questionSkipped {
 		dats := make([]feedback, len(game.state.Players))
 
 		game.state.acceptingAnswers = false
 		game.state.countdownDone = false
 		game.state.lastPlayer = false
+		game.state.questionDone = true
 
-		clip := 6
+		clip := LeaderboardClip
 		if clip > len(game.state.Players) {
 			clip = len(game.state.Players)
 		}
 
-		game.state.Host.SendMessage(CommandQuestionOver, struct{}{})
+		game.sendHost(CommandQuestionOver, game.answerSummary())
 
 		for i, plr := range game.state.Players {
 			correct := false
-			dur := 0
+			var dur time.Duration
 
 			if plr.answer > 0 {
 				correct = game.Questions[game.state.CurrentQuestion].Answers[plr.answer-1].Correct
-				dur = game.Questions[game.state.CurrentQuestion].Duration
+				dur = game.answerWindow()
 			}
 
 			if correct {
@@ -253,8 +565,20 @@ func (game *Game) AcceptAnswers() StateFunc {
 				game.state.Players[i].Streak = 0
 			}
 
-			score := Score(correct, BasePoints, game.state.Players[i].Streak, plr.answeredAt.Sub(game.state.answersAt), time.Duration(dur)*time.Second)
+			score := Score(correct, BasePoints, game.state.Players[i].Streak, plr.answeredAt.Sub(game.state.answersAt), dur)
 			game.state.Players[i].Score += score
+			if plr.canAnswer {
+				ans := results.Answer{
+					Question: game.state.CurrentQuestion,
+					Answer:   plr.answer,
+					Correct:  correct,
+					Points:   score,
+				}
+				if plr.answer > 0 {
+					ans.Taken = plr.answeredAt.Sub(game.state.answersAt).Milliseconds()
+				}
+				game.state.Players[i].answers = append(plr.answers, ans)
+			}
 			dats[i] = feedback{
 				Info:    game.state.Players[i].Info(),
 				Correct: correct,
@@ -264,7 +588,7 @@ func (game *Game) AcceptAnswers() StateFunc {
 		}
 
 		board := NewLeaderboard(game.state.Players)
-		game.state.Host.SendMessage(CommandSeeResults, board[:clip])
+		game.sendHost(CommandSeeResults, board[:clip])
 		return game.Sustain
 	}
 
@@ -0,0 +1,1293 @@
+package game
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ejv2/gahoot/game/events"
+	"github.com/ejv2/gahoot/game/nick"
+	"github.com/ejv2/gahoot/game/quiz"
+	"github.com/ejv2/gahoot/game/results"
+	"github.com/ejv2/gahoot/game/snapshot"
+	"github.com/gorilla/websocket"
+)
+
+// testClient returns a client which is connected but silently discards every
+// message sent to it.
+func testClient() Client {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	return Client{
+		Connected: true,
+		Ctx:       ctx,
+		Cancel:    cancel,
+	}
+}
+
+// testConn returns the server side of a websocket connection to a client which
+// never sends anything. The connection is closed when the test ends.
+func testConn(t *testing.T) *websocket.Conn {
+	t.Helper()
+
+	conns := make(chan *websocket.Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := new(websocket.Upgrader).Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		conns <- conn
+	}))
+	t.Cleanup(srv.Close)
+
+	cl, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cl.Close() })
+	return <-conns
+}
+
+// testQuiz returns a quiz of two questions, each with two answers.
+func testQuiz() quiz.Quiz {
+	return quiz.Quiz{
+		Title: "Test quiz",
+		Questions: []quiz.Question{
+			{
+				Title:    "First",
+				Duration: 20,
+				Answers: []quiz.Answer{
+					{Title: "Right", Correct: true},
+					{Title: "Wrong", Correct: false},
+				},
+			},
+			{
+				Title:    "Second",
+				Duration: 10,
+				Answers: []quiz.Answer{
+					{Title: "Wrong", Correct: false},
+					{Title: "Right", Correct: true},
+				},
+			},
+		},
+	}
+}
+
+// testGame returns a running game with three connected players, sitting on the
+// countdown for the first question.
+func testGame(t *testing.T) *Game {
+	t.Helper()
+
+	g := NewGame(1111111111, testQuiz(), make(chan Pin, 1), Settings{})
+	t.Cleanup(g.cancel)
+
+	g.state.Host = &Host{testClient()}
+	for i := 1; i <= MinPlayers; i++ {
+		g.state.Players = append(g.state.Players, Player{
+			Client: testClient(),
+			ID:     i,
+		})
+	}
+
+	StartGame{}.Perform(&g)
+	g.sf = g.sf()
+	return &g
+}
+
+// perform runs a single iteration of the game runner with act.
+func perform(g *Game, act Action) {
+	act.Perform(g)
+	g.sf = g.sf()
+}
+
+// within reports if got is within tolerance of want.
+func within(got, want, tolerance int64) bool {
+	return got >= want-tolerance && got <= want+tolerance
+}
+
+// answerGame returns a game accepting answers to a twenty second question from
+// a single player, whose round trip time is rtt, with answers having opened
+// elapsed ago.
+func answerGame(t *testing.T, compensate bool, rtt, elapsed time.Duration) *Game {
+	t.Helper()
+
+	q := quiz.Quiz{
+		Title: "Test quiz",
+		Questions: []quiz.Question{{
+			Title:    "Question",
+			Duration: 20,
+			Answers:  []quiz.Answer{{Title: "Right", Correct: true}, {Title: "Wrong"}},
+		}},
+	}
+	g := NewGame(1111111111, q, make(chan Pin, 1), Settings{LatencyCompensation: compensate})
+	t.Cleanup(g.cancel)
+
+	g.state.Players = []Player{{ID: 1, canAnswer: true}}
+	g.state.Players[0].rtt = new(roundTrip)
+	g.state.Players[0].rtt.sample(rtt)
+	g.state.acceptingAnswers = true
+	g.state.answersAt = time.Now().Add(-elapsed)
+	return &g
+}
+
+func TestLatencyCompensation(t *testing.T) {
+	const tolerance = 50 * time.Millisecond
+	tests := []struct {
+		name    string
+		rtt     time.Duration
+		elapsed time.Duration
+		expect  time.Duration
+	}{
+		{"no estimate", 0, 5 * time.Second, 5 * time.Second},
+		{"half round trip", 200 * time.Millisecond, 5 * time.Second, 4900 * time.Millisecond},
+		{"capped", 2 * time.Second, 5 * time.Second, 5*time.Second - MaxLatencyCompensation},
+		{"before open", 400 * time.Millisecond, 100 * time.Millisecond, 0},
+		{"late within latency", 400 * time.Millisecond, 20100 * time.Millisecond, 19900 * time.Millisecond},
+		{"late beyond latency", 400 * time.Millisecond, 20500 * time.Millisecond, 20 * time.Second},
+		{"late and capped", 2 * time.Second, 20300 * time.Millisecond, 20 * time.Second},
+	}
+
+	for _, tt := range tests {
+		g := answerGame(t, true, tt.rtt, tt.elapsed)
+		Answer{1, 1}.Perform(g)
+
+		plr := g.state.Players[0]
+		if plr.answer != 1 {
+			t.Errorf("%s: answer not accepted", tt.name)
+			continue
+		}
+		got := plr.answeredAt.Sub(g.state.answersAt)
+		if got < tt.expect-tolerance || got > tt.expect+tolerance {
+			t.Errorf("%s: answer took %v, expected %v", tt.name, got, tt.expect)
+		}
+		if got > 20*time.Second {
+			t.Errorf("%s: answer recorded after deadline", tt.name)
+		}
+	}
+
+	// Without compensation, the answer is taken as it arrives
+	g := answerGame(t, false, 200*time.Millisecond, 5*time.Second)
+	Answer{1, 1}.Perform(g)
+	if got := g.state.Players[0].answeredAt.Sub(g.state.answersAt); got < 5*time.Second {
+		t.Errorf("compensation disabled: answer took %v, expected 5s", got)
+	}
+}
+
+func TestHostReconnect(t *testing.T) {
+	g := testGame(t)
+	perform(g, StartAnswer{})
+	// Nothing is connected, so with the game cancelled messages are
+	// dropped at once and the grace period is timed out by hand
+	g.cancel()
+
+	perform(g, HostDisconnect{})
+	if g.state.Host.Connected {
+		t.Fatal("host still connected after disconnect")
+	}
+	if !g.state.paused {
+		t.Error("running game not paused on host disconnect")
+	}
+	lost := g.state.hostLostAt
+	if lost.IsZero() {
+		t.Fatal("host loss time not recorded")
+	}
+	perform(g, HostDisconnect{})
+	if !g.state.hostLostAt.Equal(lost) {
+		t.Error("repeated disconnect restarted grace period")
+	}
+
+	perform(g, ConnectHost{Conn: testConn(t), fin: true})
+	if !g.state.Host.Connected {
+		t.Fatal("host did not reconnect within grace period")
+	}
+	if !g.state.hostLostAt.IsZero() {
+		t.Error("host loss time not cleared on reconnect")
+	}
+	if !g.state.paused {
+		t.Error("game resumed without the host")
+	}
+
+	host := g.state.Host
+	second := testConn(t)
+	perform(g, ConnectHost{Conn: second, cl: Client{conn: second}, fin: true})
+	if g.state.Host != host {
+		t.Error("connected host replaced by second host")
+	}
+
+	// The timeout from the first disconnect must not end the game once
+	// the host has returned, even if lost again
+	perform(g, hostTimeout{lost})
+	if g.sf == nil {
+		t.Fatal("game ended by stale grace period after reconnect")
+	}
+	perform(g, HostDisconnect{})
+	perform(g, hostTimeout{lost})
+	if g.sf == nil {
+		t.Fatal("game ended by grace period of earlier disconnect")
+	}
+	perform(g, hostTimeout{g.state.hostLostAt})
+	if g.sf != nil {
+		t.Error("game not ended after grace period expired")
+	}
+}
+
+func TestHostGraceExpiry(t *testing.T) {
+	g := NewGame(1111111111, testQuiz(), make(chan Pin, 1), Settings{HostGracePeriod: 10 * time.Millisecond})
+	t.Cleanup(g.cancel)
+	g.state.Host = &Host{testClient()}
+	g.sf = g.WaitForHost
+	g.sf = g.sf()
+
+	// The grace period is scheduled on the game runner, which ends the
+	// game once it has passed
+	perform(&g, HostDisconnect{})
+	select {
+	case act := <-g.Action:
+		perform(&g, act)
+	case <-time.After(time.Second):
+		t.Fatal("grace period did not expire")
+	}
+	if g.sf != nil {
+		t.Error("game not ended after grace period expired")
+	}
+}
+
+func TestSessionTokens(t *testing.T) {
+	g := NewGame(1111111111, testQuiz(), make(chan Pin, 1), Settings{})
+	g.state.Host = &Host{testClient()}
+
+	add := func(nick string) AddResult {
+		act := AddPlayer{Nick: nick, Result: make(chan AddResult, 1)}
+		act.Perform(&g)
+		return <-act.Result
+	}
+	alice, bob := add("alice"), add("bob")
+	if alice.Err != nil || bob.Err != nil {
+		t.Fatal("failed to add players:", alice.Err, bob.Err)
+	}
+	if alice.Token == "" || alice.Token == bob.Token {
+		t.Fatal("players not given distinct session tokens")
+	}
+	// Messages to the host are dropped at once with the game cancelled
+	g.cancel()
+
+	connect := func(tok string) *websocket.Conn {
+		conn := testConn(t)
+		ConnectPlayer{Conn: conn, cl: Client{conn: conn}, tok: tok, fin: true}.Perform(&g)
+		return conn
+	}
+	first := connect(alice.Token)
+	if !g.state.Players[alice.ID-1].Connected {
+		t.Fatal("player not connected with own token")
+	}
+	g.state.Players[alice.ID-1].Score = 500
+	g.state.Players[alice.ID-1].Streak = 2
+
+	// Someone else presenting the token must not take over the slot
+	connect(alice.Token)
+	if plr := g.state.Players[alice.ID-1]; !plr.Connected || plr.conn != first {
+		t.Error("player displaced by connection with stolen token")
+	}
+	connect("guess")
+	if len(g.state.Players) != 2 {
+		t.Error("player added by invalid token")
+	}
+
+	// Once disconnected, the token resumes the same slot
+	g.state.Players[alice.ID-1].Connected = false
+	connect(alice.Token)
+	plr := g.state.Players[alice.ID-1]
+	if !plr.Connected || plr.Score != 500 || plr.Streak != 2 {
+		t.Errorf("reconnection did not resume player: %+v", plr.Info())
+	}
+	if g.state.Players[bob.ID-1].Connected {
+		t.Error("other player connected by reconnection")
+	}
+}
+
+func TestPauseScoring(t *testing.T) {
+	g := testGame(t)
+	perform(g, StartAnswer{})
+
+	// Answers opened 14s ago, the first player answered after 1s and
+	// play was paused after 4s for 10s. Time taken on resume should
+	// therefore be 1s for the first player and 4s for the rest.
+	perform(g, Answer{1, 1})
+	now := time.Now()
+	g.state.answersAt = now.Add(-14 * time.Second)
+	g.state.Players[0].answeredAt = now.Add(-13 * time.Second)
+	perform(g, PauseGame{})
+	g.state.pausedAt = now.Add(-10 * time.Second)
+
+	perform(g, Answer{2, 1})
+	if g.state.Players[1].answer != 0 {
+		t.Fatal("answer accepted while paused")
+	}
+
+	perform(g, ResumeGame{})
+	for _, plr := range g.state.Players[1:] {
+		perform(g, Answer{plr.ID, 1})
+	}
+
+	if !g.state.questionDone {
+		t.Fatal("question did not end after all players answered")
+	}
+
+	early := Score(true, BasePoints, 1, time.Second, 20*time.Second)
+	if !within(g.state.Players[0].Score, early, 5) {
+		t.Errorf("player 1 scored %d for answer before pause, expected ~%d", g.state.Players[0].Score, early)
+	}
+	want := Score(true, BasePoints, 1, 4*time.Second, 20*time.Second)
+	for _, plr := range g.state.Players[1:] {
+		if !within(plr.Score, want, 5) {
+			t.Errorf("player %d scored %d after pause, expected ~%d", plr.ID, plr.Score, want)
+		}
+	}
+}
+
+func TestPauseCountdown(t *testing.T) {
+	g := testGame(t)
+	start := time.Now()
+
+	// The host finishes the countdown just after the game is paused, so
+	// answers must not open until the game is resumed
+	g.at = start
+	perform(g, PauseGame{})
+	g.at = start.Add(time.Second)
+	perform(g, StartAnswer{})
+	if g.state.acceptingAnswers {
+		t.Fatal("answers opened while paused")
+	}
+	perform(g, Answer{1, 1})
+	if g.state.Players[0].answer != 0 {
+		t.Fatal("answer accepted while paused")
+	}
+
+	g.at = start.Add(10 * time.Second)
+	perform(g, ResumeGame{})
+	if !g.state.acceptingAnswers {
+		t.Fatal("answers not opened on resume")
+	}
+	if !g.state.answersAt.Equal(g.at) {
+		t.Errorf("answers opened at %v after resume, expected 0s", g.state.answersAt.Sub(g.at))
+	}
+
+	g.at = start.Add(12 * time.Second)
+	for _, plr := range g.state.Players {
+		perform(g, Answer{plr.ID, 1})
+	}
+	if !g.state.questionDone {
+		t.Fatal("question did not end after all players answered")
+	}
+	want := Score(true, BasePoints, 1, 2*time.Second, 20*time.Second)
+	for _, plr := range g.state.Players {
+		if plr.Score != want {
+			t.Errorf("player %d scored %d, expected %d", plr.ID, plr.Score, want)
+		}
+	}
+}
+
+func TestResumeBeforeAnswers(t *testing.T) {
+	g := testGame(t)
+	start := time.Now()
+
+	// Answers opened 2s into a 10s pause, so only 8s of the answer window
+	// was lost to the pause
+	g.at = start
+	perform(g, PauseGame{})
+	g.state.countdownDone = true
+	g.state.answersAt = start.Add(2 * time.Second)
+	g.at = start.Add(10 * time.Second)
+	perform(g, ResumeGame{})
+	if !g.state.answersAt.Equal(g.at) {
+		t.Errorf("answers opened at %v after resume, expected 0s", g.state.answersAt.Sub(g.at))
+	}
+}
+
+func TestExtendScoring(t *testing.T) {
+	g := testGame(t)
+	perform(g, StartAnswer{})
+
+	// Extension past the end of the original window must still be able to
+	// score points
+	g.state.answersAt = time.Now().Add(-25 * time.Second)
+	perform(g, ExtendTime{10})
+	if g.state.extraTime != 10*time.Second {
+		t.Fatalf("expected 10s extension, got %v", g.state.extraTime)
+	}
+
+	for _, plr := range g.state.Players {
+		perform(g, Answer{plr.ID, 1})
+	}
+
+	want := Score(true, BasePoints, 1, 25*time.Second, 30*time.Second)
+	for _, plr := range g.state.Players {
+		if !within(plr.Score, want, 5) {
+			t.Errorf("player %d scored %d after extension, expected ~%d", plr.ID, plr.Score, want)
+		}
+	}
+
+	perform(g, ExtendTime{int(MaxExtraTime.Seconds()) * 2})
+	if g.state.extraTime != 10*time.Second {
+		t.Errorf("extension after question end changed extra time to %v", g.state.extraTime)
+	}
+}
+
+func TestJumpQuestion(t *testing.T) {
+	g := testGame(t)
+	perform(g, StartAnswer{})
+	perform(g, Answer{1, 2})
+
+	perform(g, JumpQuestion{2})
+	if g.state.CurrentQuestion != 1 {
+		t.Fatalf("expected question index 1, got %d", g.state.CurrentQuestion)
+	}
+	if g.state.countdownDone || g.state.acceptingAnswers || g.state.questionDone {
+		t.Error("per-question game state not reset by jump")
+	}
+	for _, plr := range g.state.Players {
+		if plr.Score != 0 || plr.Streak != 0 {
+			t.Errorf("player %d scored for abandoned question", plr.ID)
+		}
+		if plr.answer != 0 || !plr.answeredAt.IsZero() {
+			t.Errorf("player %d answer not reset by jump", plr.ID)
+		}
+		if !plr.canAnswer {
+			t.Errorf("player %d cannot answer jumped question", plr.ID)
+		}
+	}
+
+	// Repeating a question works from the results screen
+	perform(g, StartAnswer{})
+	for _, plr := range g.state.Players {
+		perform(g, Answer{plr.ID, 2})
+	}
+	perform(g, JumpQuestion{2})
+	if g.state.CurrentQuestion != 1 || g.state.questionDone {
+		t.Error("failed to repeat current question")
+	}
+
+	perform(g, JumpQuestion{3})
+	if g.state.CurrentQuestion != 1 {
+		t.Error("jumped to out of range question")
+	}
+}
+
+func TestAnswerSummary(t *testing.T) {
+	g := testGame(t)
+	perform(g, StartAnswer{})
+
+	perform(g, Answer{PlayerID: 1, Number: 1})
+	perform(g, Answer{PlayerID: 2, Number: 2})
+	if !g.state.progressPending {
+		t.Error("expected pending answer progress update")
+	}
+	if p := g.answerProgress(); p.Answered != 2 || p.Eligible != MinPlayers {
+		t.Errorf("progress: got %d/%d, expected 2/%d", p.Answered, p.Eligible, MinPlayers)
+	}
+
+	// Stale updates from a previous question must not be sent
+	perform(g, answerUpdate{1})
+	if !g.state.progressPending {
+		t.Error("stale answer update consumed pending progress")
+	}
+	perform(g, answerUpdate{0})
+	if g.state.progressPending {
+		t.Error("answer update did not clear pending progress")
+	}
+
+	perform(g, Answer{PlayerID: 3, Number: 1})
+	if !g.state.questionDone {
+		t.Fatal("question not finished after every player answered")
+	}
+
+	sum := g.answerSummary()
+	expect := []OptionCount{{2, true}, {1, false}}
+	if len(sum.Options) != len(expect) {
+		t.Fatalf("summary: got %d options, expected %d", len(sum.Options), len(expect))
+	}
+	for i, opt := range sum.Options {
+		if opt != expect[i] {
+			t.Errorf("option %d: got %+v, expected %+v", i+1, opt, expect[i])
+		}
+	}
+}
+
+func TestSpectatorFanout(t *testing.T) {
+	g := testGame(t)
+
+	fast, slow := testClient(), testClient()
+	fast.send = make(chan string, SpectatorBacklog)
+	slow.send = make(chan string, 1)
+	g.state.spectators = map[int]Spectator{
+		1: {Client: fast, ID: 1},
+		2: {Client: slow, ID: 2},
+	}
+
+	perform(g, PauseGame{})
+	perform(g, ResumeGame{})
+
+	if _, ok := g.state.spectators[2]; ok {
+		t.Error("slow spectator was not disconnected")
+	}
+	if _, ok := g.state.spectators[1]; !ok {
+		t.Fatal("fast spectator was disconnected")
+	}
+
+	expect := []string{CommandPaused, CommandResumed}
+	for _, verb := range expect {
+		select {
+		case msg := <-fast.send:
+			got, _, err := StringMessage(msg)
+			if err != nil || got != verb {
+				t.Errorf("spectator message: got %q, expected %q", got, verb)
+			}
+		default:
+			t.Errorf("spectator missing message %q", verb)
+		}
+	}
+}
+
+func TestRemotePending(t *testing.T) {
+	g := testGame(t)
+	remote := testClient()
+	remote.send = make(chan string, RemoteBacklog)
+	g.state.remote = &Remote{Client: remote, ID: 1}
+
+	perform(g, StartAnswer{})
+	perform(g, Answer{PlayerID: 2, Number: 1})
+	perform(g, KickPlayer{ID: MinPlayers + 1})
+	perform(g, PendingPlayers{1})
+
+	var pending []PlayerInfo
+	for len(remote.send) > 0 {
+		msg := <-remote.send
+		if verb, _, _ := StringMessage(msg); verb != CommandPending {
+			continue
+		}
+		if _, err := ParseMessage(msg, &pending); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(pending) != 2 || pending[0].ID != 1 || pending[1].ID != 3 {
+		t.Errorf("pending players: got %+v, expected IDs 1 and 3", pending)
+	}
+}
+
+func TestApproveJoins(t *testing.T) {
+	g := NewGame(1111111111, quiz.Quiz{}, make(chan Pin, 1), Settings{})
+	t.Cleanup(g.cancel)
+	g.ApproveJoins = true
+	g.state.Host = &Host{testClient()}
+
+	add := func(nick string) AddResult {
+		act := AddPlayer{Nick: nick, Result: make(chan AddResult, 1)}
+		act.Perform(&g)
+		return <-act.Result
+	}
+
+	alice, bob := add("alice"), add("bob")
+	if alice.Err != nil || bob.Err != nil {
+		t.Fatal("failed to add players:", alice.Err, bob.Err)
+	}
+	if !g.state.Players[alice.ID-1].Pending {
+		t.Error("player not pending with approval enabled")
+	}
+
+	ApprovePlayer{alice.ID}.Perform(&g)
+	RejectPlayer{bob.ID}.Perform(&g)
+	if g.state.Players[alice.ID-1].Pending {
+		t.Error("approved player still pending")
+	}
+	if _, ok := g.state.tokens[bob.Token]; ok {
+		t.Error("rejected player's token still valid")
+	}
+	if res := add("bob"); res.Err != nil {
+		t.Error("rejected player's nick not released:", res.Err)
+	}
+
+	board := NewLeaderboard(g.state.Players)
+	if len(board) != 1 || board[0].ID != alice.ID {
+		t.Errorf("leaderboard: got %+v, expected only approved player", board)
+	}
+}
+
+func TestNickUniqueness(t *testing.T) {
+	g := NewGame(1111111111, quiz.Quiz{}, make(chan Pin, 1), Settings{})
+	t.Cleanup(g.cancel)
+
+	add := func(nick string) error {
+		act := AddPlayer{Nick: nick, Result: make(chan AddResult, 1)}
+		act.Perform(&g)
+		return (<-act.Result).Err
+	}
+
+	if err := add("  Bob "); err != nil {
+		t.Fatal("failed to add player:", err)
+	}
+	if g.state.Players[0].Nick != "Bob" {
+		t.Errorf("nick not cleaned: got %q", g.state.Players[0].Nick)
+	}
+	for _, n := range []string{"bob", "BOB", "Ᏼob", "Bоb"} {
+		if err := add(n); !errors.Is(err, ErrorNickTaken) {
+			t.Errorf("add %q: got %v, expected %v", n, err, ErrorNickTaken)
+		}
+	}
+	if err := add("x"); !errors.Is(err, nick.ErrTooShort) {
+		t.Errorf("add short nick: got %v, expected %v", err, nick.ErrTooShort)
+	}
+}
+
+func TestGeneratedNames(t *testing.T) {
+	g := NewGame(1111111111, quiz.Quiz{}, make(chan Pin, 1), Settings{})
+	t.Cleanup(g.cancel)
+	g.GenerateNames = true
+	g.state.Host = &Host{testClient()}
+
+	act := AddPlayer{Nick: "ignored", Result: make(chan AddResult, 1)}
+	act.Perform(&g)
+	res := <-act.Result
+	if res.Err != nil {
+		t.Fatal("failed to add player:", res.Err)
+	}
+	g.state.Players[0].Client = testClient()
+
+	names := map[string]struct{}{g.state.Players[0].Nick: {}}
+	if _, ok := names["ignored"]; ok {
+		t.Fatal("chosen nick used despite generated names")
+	}
+	for i := 0; i < MaxRerolls+2; i++ {
+		RerollNick{res.ID}.Perform(&g)
+		names[g.state.Players[0].Nick] = struct{}{}
+	}
+	if len(g.state.namecache) != 1 {
+		t.Errorf("re-rolled names not released: %d cached", len(g.state.namecache))
+	}
+	if len(names) > MaxRerolls+1 {
+		t.Errorf("re-rolled %d times, expected at most %d", len(names)-1, MaxRerolls)
+	}
+}
+
+func TestModeration(t *testing.T) {
+	g := NewGame(1111111111, quiz.Quiz{}, make(chan Pin, 1), Settings{})
+	t.Cleanup(g.cancel)
+	g.state.Host = &Host{testClient()}
+
+	add := func(nick, addr, tok string) AddResult {
+		act := AddPlayer{Nick: nick, Addr: addr, Token: tok, Result: make(chan AddResult, 1)}
+		act.Perform(&g)
+		return <-act.Result
+	}
+
+	alice, bob, carol := add("alice", "10.0.0.1", ""), add("bob", "10.0.0.2", ""), add("carol", "10.0.0.3", "")
+	if alice.Err != nil || bob.Err != nil || carol.Err != nil {
+		t.Fatal("failed to add players:", alice.Err, bob.Err, carol.Err)
+	}
+	for i := range g.state.Players {
+		g.state.Players[i].Client = testClient()
+		g.state.Players[i].Connected = false
+	}
+
+	KickPlayer{alice.ID, "be nice"}.Perform(&g)
+	if res := add("alice", "10.0.0.1", alice.Token); res.Err != nil {
+		t.Error("kicked player could not rejoin:", res.Err)
+	}
+
+	BanPlayer{bob.ID, "", false}.Perform(&g)
+	if res := add("robert", "10.0.0.4", bob.Token); !errors.Is(res.Err, ErrorBanned) {
+		t.Errorf("banned token: got %v, expected %v", res.Err, ErrorBanned)
+	}
+	if res := add("robert", "10.0.0.2", ""); res.Err != nil {
+		t.Error("address banned without being asked:", res.Err)
+	}
+
+	BanPlayer{carol.ID, "", true}.Perform(&g)
+	if res := add("caroline", "10.0.0.3", ""); !errors.Is(res.Err, ErrorBanned) {
+		t.Errorf("banned address: got %v, expected %v", res.Err, ErrorBanned)
+	}
+
+	id := len(g.state.Players)
+	old := g.state.Players[id-1].Nick
+	g.state.Players[id-1].Client = testClient()
+	g.state.Players[id-1].Connected = false
+	RenamePlayer{id, "rude"}.Perform(&g)
+	if renamed := g.state.Players[id-1].Nick; renamed == old {
+		t.Error("player not renamed")
+	}
+	if res := add(old, "10.0.0.5", ""); res.Err != nil {
+		t.Error("old nick not released after rename:", res.Err)
+	}
+
+	if len(g.state.modlog) != 4 {
+		t.Errorf("moderation log: got %d entries, expected 4", len(g.state.modlog))
+	}
+	board := NewLeaderboard(g.state.Players)
+	for _, p := range board {
+		if p.ID == alice.ID || p.ID == bob.ID || p.ID == carol.ID {
+			t.Errorf("removed player %d on leaderboard", p.ID)
+		}
+	}
+
+	// A remote may moderate before any host has connected
+	g.state.Host = nil
+	KickPlayer{id, ""}.Perform(&g)
+	if !g.state.Players[id-1].Removed {
+		t.Error("player not kicked without a host")
+	}
+}
+
+func TestCloseReason(t *testing.T) {
+	long := closeReason("banned by host", strings.Repeat("é", 100))
+	if len(long) > MaxCloseReason || !utf8.ValidString(long) {
+		t.Errorf("close reason not truncated safely: %d bytes", len(long))
+	}
+	if r := closeReason("kicked by host", ""); r != "kicked by host" {
+		t.Errorf("got %q, expected bare prefix", r)
+	}
+}
+
+func TestReleaseSlots(t *testing.T) {
+	g := NewGame(1111111111, quiz.Quiz{}, make(chan Pin, 1), Settings{})
+	t.Cleanup(g.cancel)
+	g.state.Host = &Host{testClient()}
+
+	add := func(nick string) AddResult {
+		act := AddPlayer{Nick: nick, Result: make(chan AddResult, 1)}
+		act.Perform(&g)
+		return <-act.Result
+	}
+
+	idle, leaver := add("idle"), add("leaver")
+	if idle.Err != nil || leaver.Err != nil {
+		t.Fatal("failed to add players:", idle.Err, leaver.Err)
+	}
+	g.state.Players[leaver.ID-1].claimed = true
+
+	claimTimeout{idle.ID}.Perform(&g)
+	claimTimeout{leaver.ID}.Perform(&g)
+	if !g.state.Players[idle.ID-1].Removed {
+		t.Error("unclaimed slot not released")
+	}
+	if g.state.Players[leaver.ID-1].Removed {
+		t.Error("claimed slot released")
+	}
+
+	LeavePlayer{leaver.ID}.Perform(&g)
+	if _, ok := g.state.tokens[leaver.Token]; ok {
+		t.Error("leaving player's token still valid")
+	}
+	for _, n := range []string{"idle", "leaver"} {
+		if res := add(n); res.Err != nil {
+			t.Errorf("nick %q not released: %v", n, res.Err)
+		}
+	}
+
+	g.state.Status = GameRunning
+	LeavePlayer{len(g.state.Players)}.Perform(&g)
+	if g.state.Players[len(g.state.Players)-1].Removed {
+		t.Error("player left a running game")
+	}
+}
+
+func TestPlayerCaps(t *testing.T) {
+	g := NewGame(1111111111, quiz.Quiz{}, make(chan Pin, 1), Settings{MaxPlayers: 3, MaxPending: 2})
+	t.Cleanup(g.cancel)
+	g.state.Host = &Host{testClient()}
+
+	add := func(nick string) error {
+		act := AddPlayer{Nick: nick, Result: make(chan AddResult, 1)}
+		act.Perform(&g)
+		return (<-act.Result).Err
+	}
+
+	if err := add("one"); err != nil {
+		t.Fatal("failed to add player:", err)
+	}
+	if err := add("two"); err != nil {
+		t.Fatal("failed to add player:", err)
+	}
+	if err := add("three"); !errors.Is(err, ErrorJoinBusy) {
+		t.Errorf("too many joining: got %v, expected %v", err, ErrorJoinBusy)
+	}
+
+	g.state.Players[0].claimed = true
+	if err := add("three"); err != nil {
+		t.Fatal("failed to add player:", err)
+	}
+	g.state.Players[1].claimed = true
+	if err := add("four"); !errors.Is(err, ErrorGameFull) {
+		t.Errorf("full game: got %v, expected %v", err, ErrorGameFull)
+	}
+}
+
+func TestGameLimits(t *testing.T) {
+	c := NewCoordinator(Settings{MaxGames: 2, MaxHostGames: 1})
+
+	g, err := c.CreateGame(quiz.Quiz{}, Options{}, "10.0.0.1", "")
+	if err != nil {
+		t.Fatal("failed to create game:", err)
+	}
+	t.Cleanup(g.cancel)
+	if _, err := c.CreateGame(quiz.Quiz{}, Options{}, "10.0.0.1", ""); !errors.Is(err, ErrorTooManyHostGames) {
+		t.Errorf("second game from address: got %v, expected %v", err, ErrorTooManyHostGames)
+	}
+
+	g, err = c.CreateGame(quiz.Quiz{}, Options{}, "10.0.0.2", "")
+	if err != nil {
+		t.Fatal("failed to create game:", err)
+	}
+	t.Cleanup(g.cancel)
+	if _, err := c.CreateGame(quiz.Quiz{}, Options{}, "10.0.0.3", ""); !errors.Is(err, ErrorTooManyGames) {
+		t.Errorf("too many games: got %v, expected %v", err, ErrorTooManyGames)
+	}
+}
+
+func TestParsePin(t *testing.T) {
+	tests := []struct {
+		in  string
+		err error
+	}{
+		{"1111111110", nil},
+		{"4294967283", nil},
+		{"5724", ErrorInvalidPin},
+		{"100009", nil},
+		{"1111111111", ErrorPinMistyped},
+		{"1111111101", ErrorPinMistyped},
+		{"0000000001", ErrorInvalidPin},
+		{"4294967296", ErrorInvalidPin},
+		{"12345abcde", ErrorInvalidPin},
+		{"", ErrorInvalidPin},
+	}
+
+	for _, tc := range tests {
+		_, err := ParsePin(tc.in)
+		if !errors.Is(err, tc.err) {
+			t.Errorf("ParsePin(%q): got %v, expected %v", tc.in, err, tc.err)
+		}
+	}
+}
+
+func TestGeneratePin(t *testing.T) {
+	for length := MinPinLength; length <= MaxPinLength; length++ {
+		for i := 0; i < 100; i++ {
+			p := generatePin(length)
+			if len(p.String()) != length || !p.Validate() {
+				t.Fatalf("generated invalid %d digit PIN %s", length, p)
+			}
+
+			// Any single digit typo must be caught
+			s := []byte(p.String())
+			s[i%length] = '0' + (s[i%length]-'0'+1)%10
+			if _, err := ParsePin(string(s)); err == nil {
+				t.Errorf("typo in PIN %s not caught: %s", p, s)
+			}
+		}
+	}
+}
+
+func TestPinCooldown(t *testing.T) {
+	c := NewCoordinator(Settings{PinCooldown: time.Hour})
+
+	g, err := c.CreateGame(quiz.Quiz{}, Options{}, "", "")
+	if err != nil {
+		t.Fatal("failed to create game:", err)
+	}
+	free := func() bool {
+		c.mut.RLock()
+		defer c.mut.RUnlock()
+		return c.pinFree(g.PIN)
+	}
+	if free() {
+		t.Error("PIN of running game is free")
+	}
+
+	g.cancel()
+	for c.GameExists(g.PIN) {
+		time.Sleep(time.Millisecond)
+	}
+	if free() {
+		t.Error("PIN reusable straight after game ended")
+	}
+}
+
+func TestNoPins(t *testing.T) {
+	c := NewCoordinator(Settings{PinLength: MinPinLength})
+
+	// Every PIN of the shortest length is cooling down
+	until := time.Now().Add(time.Hour)
+	for i := 10000; i <= 99999; i++ {
+		body := strconv.Itoa(i)
+		pin, _ := strconv.ParseUint(body+strconv.Itoa(damm(body)), 10, 32)
+		c.cooldown[Pin(pin)] = until
+	}
+
+	if _, err := c.CreateGame(quiz.Quiz{}, Options{}, "", ""); !errors.Is(err, ErrorNoPins) {
+		t.Errorf("created game with no free PINs: got %v, expected %v", err, ErrorNoPins)
+	}
+}
+
+func TestArchiveResults(t *testing.T) {
+	store, err := results.NewStore(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := testGame(t)
+	g.Archive = &store
+	for i := range g.state.Players {
+		g.state.Players[i].Nick = "Player " + strconv.Itoa(i+1)
+	}
+	perform(g, StartAnswer{})
+	g.state.answersAt = time.Now().Add(-2 * time.Second)
+	perform(g, Answer{1, 1})
+	perform(g, Answer{2, 2})
+	perform(g, EndAnswer{})
+	if !g.state.questionDone {
+		t.Fatal("question did not end after being skipped")
+	}
+	g.archive()
+
+	var res results.Result
+	for i := 0; i < 100; i++ {
+		if res, err = store.Load(g.PIN.String(), g.ID); err != results.ErrNotFound {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res.Players) != MinPlayers || len(res.Questions) != len(g.Questions) {
+		t.Fatalf("got %d players and %d questions, expected %d and %d",
+			len(res.Players), len(res.Questions), MinPlayers, len(g.Questions))
+	}
+	first := res.Players[0]
+	if first.Rank != 1 || first.Correct != 1 || first.Score != g.state.Players[0].Score {
+		t.Errorf("got first place %+v, expected player 1", first)
+	}
+
+	want := map[string]results.Answer{
+		"Player 1": {Answer: 1, Correct: true, Taken: 2000},
+		"Player 2": {Answer: 2, Taken: 2000},
+		"Player 3": {},
+	}
+	for _, p := range res.Players {
+		if len(p.Answers) != 1 {
+			t.Errorf("%s: got %d answers, expected 1", p.Nick, len(p.Answers))
+			continue
+		}
+		got, w := p.Answers[0], want[p.Nick]
+		if got.Answer != w.Answer || got.Correct != w.Correct || !within(got.Taken, w.Taken, 50) {
+			t.Errorf("%s: got answer %+v, expected %+v", p.Nick, got, w)
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	store, err := events.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is connected, so cancel the game such that messages are
+	// dropped rather than blocking
+	g := NewGame(1111111111, testQuiz(), make(chan Pin, 1), Settings{
+		Events:              &store,
+		LatencyCompensation: true,
+	})
+	g.GenerateNames = true
+	g.cancel()
+	g.recordStart()
+	g.sf = g.WaitForHost
+
+	g.step(ConnectHost{fin: true})
+	for i := 0; i <= MinPlayers; i++ {
+		res := make(chan AddResult, 1)
+		g.step(AddPlayer{Addr: "192.0.2.1", Result: res})
+		g.step(ConnectPlayer{Addr: "192.0.2.1", tok: (<-res).Token, fin: true})
+	}
+	g.step(ConnectSpectator{fin: true})
+	g.step(RerollNick{1})
+	g.step(BanPlayer{ID: MinPlayers + 1, Reason: "testing"})
+	g.step(StartGame{})
+	g.step(StartAnswer{})
+	g.step(Answer{1, 1})
+	g.step(PauseGame{})
+	g.step(ResumeGame{})
+	g.step(Answer{2, 2})
+	g.step(EndAnswer{})
+	g.step(NextQuestion{})
+	g.step(StartAnswer{})
+	g.step(Answer{3, 2})
+	g.step(EndAnswer{})
+	g.closeLog()
+
+	path, _ := store.Path(g.PIN.String(), g.ID)
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	evs, err := events.Read(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replay := func(evs []events.Event) (State, error) {
+		r, err := NewReplay(evs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for {
+			if _, err := r.Step(); err == io.EOF {
+				return r.State(), nil
+			} else if err != nil {
+				return r.State(), err
+			}
+		}
+	}
+
+	st, err := replay(evs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(st.Players) != len(g.state.Players) {
+		t.Fatalf("replay has %d players, expected %d", len(st.Players), len(g.state.Players))
+	}
+	for i, plr := range st.Players {
+		want := g.state.Players[i]
+		if plr.Nick != want.Nick || plr.Score != want.Score || plr.Correct != want.Correct || plr.Banned != want.Banned {
+			t.Errorf("player %d: replayed %q with %d points, expected %q with %d points",
+				plr.ID, plr.Nick, plr.Score, want.Nick, want.Score)
+		}
+	}
+	if g.state.Players[0].Score == 0 || len(st.modlog) != 1 {
+		t.Errorf("replayed game did not play out: %+v", st)
+	}
+
+	// Tampering with an answer must be noticed
+	for i, ev := range evs {
+		if ev.Type == "Answer" {
+			evs[i].Data, _ = json.Marshal(Answer{1, 2})
+			break
+		}
+	}
+	if _, err := replay(evs); !errors.Is(err, ErrorReplay) {
+		t.Errorf("replaying altered log: got %v, expected %v", err, ErrorReplay)
+	}
+}
+
+func TestInspectUnrecorded(t *testing.T) {
+	store, err := events.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	reaper := make(chan Pin, 1)
+	g := NewGame(1111111111, testQuiz(), reaper, Settings{Events: &store})
+	// The runner works on its own copy, as for games made by the coordinator
+	run := g
+	go run.Run()
+
+	for i := 0; i < 3; i++ {
+		if _, err := g.Inspect(time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+	g.cancel()
+	<-reaper
+
+	path, _ := store.Path(g.PIN.String(), g.ID)
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	evs, err := events.Read(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ev := range evs {
+		if ev.Kind != events.KindStart && ev.Kind != events.KindEnd {
+			t.Errorf("inspecting the game recorded %s event %d", ev.Kind, ev.Seq)
+		}
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	store, err := snapshot.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := testGame(t)
+	g.PIN = generatePin(MaxPinLength)
+	for i := range g.state.Players {
+		g.state.Players[i].Nick = "Player " + strconv.Itoa(i+1)
+		g.state.Players[i].token = "token" + strconv.Itoa(i+1)
+		g.state.Players[i].box = new(outbox)
+	}
+	perform(g, StartAnswer{})
+	g.state.answersAt = time.Now().Add(-2 * time.Second)
+	perform(g, Answer{1, 1})
+
+	// Round trip through the store, as after a restart
+	if err := store.Save(g.snapshot()); err != nil {
+		t.Fatal(err)
+	}
+	snap, err := store.Load(g.PIN.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := restoreGame(snap, make(chan Pin, 1), Settings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(r.cancel)
+
+	if r.PIN != g.PIN || r.ID != g.ID || r.HostToken != g.HostToken {
+		t.Errorf("restored game %s (ID %s), expected %s (ID %s)", r.PIN, r.ID, g.PIN, g.ID)
+	}
+	if !r.state.paused || r.state.Host == nil || r.state.Host.Connected {
+		t.Error("restored game not paused waiting for host")
+	}
+	if id := r.state.tokens["token2"]; id != 2 {
+		t.Errorf("token for player 2 maps to player %d", id)
+	}
+	if r.state.Players[0].box.seq != g.state.Players[0].box.seq {
+		t.Error("restored player lost their message sequence")
+	}
+
+	// Nobody has reconnected yet, but the question must not end while
+	// the game is paused
+	r.sf = r.entry()
+	r.sf = r.sf()
+	if r.state.questionDone {
+		t.Fatal("question ended while paused after restore")
+	}
+
+	r.state.Host.Client = testClient()
+	for i := range r.state.Players {
+		r.state.Players[i].Client = testClient()
+	}
+	perform(&r, ResumeGame{})
+	perform(&r, Answer{2, 1})
+	perform(&r, Answer{3, 2})
+	if !r.state.questionDone {
+		t.Fatal("question did not end after all players answered")
+	}
+
+	want := Score(true, BasePoints, 1, 2*time.Second, 20*time.Second)
+	if got := r.state.Players[0].Score; !within(got, want, 5) {
+		t.Errorf("player 1 scored %d after restore, expected ~%d", got, want)
+	}
+}
+
+func TestCoordinatorRestore(t *testing.T) {
+	store, err := snapshot.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := testGame(t)
+	g.PIN = generatePin(MaxPinLength)
+	if err := store.Save(g.snapshot()); err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewCoordinator(Settings{Snapshots: &store, SnapshotInterval: time.Millisecond})
+	r, ok := c.GetGame(g.PIN)
+	if !ok {
+		t.Fatal("game not restored with its original PIN")
+	}
+
+	// The snapshot is removed once the restored game ends
+	req := make(chan State)
+	r.Request <- req
+	<-req
+	r.Action <- EndGame{"testing", false}
+	for i := 0; i < 100; i++ {
+		if _, err = store.Load(g.PIN.String()); err == snapshot.ErrNotFound {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != snapshot.ErrNotFound {
+		t.Errorf("snapshot of ended game: got %v, expected %v", err, snapshot.ErrNotFound)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	g := testGame(t)
+	perform(g, Drain{time.Now().Add(time.Hour)})
+	if g.sf == nil {
+		t.Fatal("game ended as soon as draining began")
+	}
+	perform(g, drainTimeout{})
+	if g.sf != nil {
+		t.Error("running game did not end at drain deadline")
+	}
+
+	c := NewCoordinator(Settings{DrainTimeout: 50 * time.Millisecond})
+	if _, err := c.CreateGame(testQuiz(), Options{}, "10.0.0.1", ""); err != nil {
+		t.Fatal("failed to create game:", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.Drain(ctx); err != nil {
+		t.Error("draining coordinator:", err)
+	}
+	if _, err := c.CreateGame(testQuiz(), Options{}, "10.0.0.1", ""); !errors.Is(err, ErrorDraining) {
+		t.Errorf("creating game while draining: got %v, expected %v", err, ErrorDraining)
+	}
+}
+
+func TestListGames(t *testing.T) {
+	c := NewCoordinator(Settings{})
+	g, err := c.CreateGame(testQuiz(), Options{}, "10.0.0.1", "")
+	if err != nil {
+		t.Fatal("failed to create game:", err)
+	}
+
+	games := c.ListGames(time.Second)
+	if len(games) != 1 {
+		t.Fatalf("listed %d games, expected 1", len(games))
+	}
+	info := games[0]
+	if info.PIN != g.PIN || info.Busy || info.Status != GameHostWaiting || info.HostAddr != "10.0.0.1" {
+		t.Errorf("got %+v, expected game %s waiting for host from 10.0.0.1", info, g.PIN)
+	}
+
+	owned, err := c.CreateGame(testQuiz(), Options{}, "10.0.0.2", "alice")
+	if err != nil {
+		t.Fatal("failed to create game:", err)
+	}
+	if n := len(c.ListGames(time.Second)); n != 2 {
+		t.Errorf("listed %d games, expected 2", n)
+	}
+	games = c.OwnedGames("alice", time.Second)
+	if len(games) != 1 || games[0].PIN != owned.PIN || games[0].Owner != "alice" {
+		t.Errorf("got %+v, expected only game %s owned by alice", games, owned.PIN)
+	}
+	if games := c.OwnedGames("bob", time.Second); len(games) != 0 {
+		t.Errorf("got %+v, expected no games owned by bob", games)
+	}
+
+	if err := g.Submit(EndGame{"testing", false}, time.Second); err != nil {
+		t.Fatal("ending game:", err)
+	}
+	<-g.ctx.Done()
+	if _, err := g.Inspect(time.Second); err != ErrorNoGame {
+		t.Errorf("inspecting ended game: got %v, expected %v", err, ErrorNoGame)
+	}
+}
@@ -1,21 +1,86 @@
 package game
 
 import (
+	"fmt"
 	"log"
 	"strconv"
+
+	"github.com/ejv2/gahoot/game/quiz"
+)
+
+// Game stages, as reported to a resynchronising host.
+const (
+	StageLobby     = "lobby"
+	StageCountdown = "countdown"
+	StageQuestion  = "question"
+	StageResults   = "results"
 )
 
+// QuestionInfo is a message object containing a question in the quiz and its
+// position in the quiz. It should only be used for formatted transmission
+// over a websocket.
+type QuestionInfo struct {
+	quiz.Question
+	Index int `json:"index"`
+	Total int `json:"total"`
+}
+
+// RosterEntry is a PlayerInfo annotated with the player's connection and
+// approval state.
+type RosterEntry struct {
+	PlayerInfo
+	Connected bool `json:"connected"`
+	Pending   bool `json:"pending"`
+}
+
+// AnswerProgress is a message object reporting how many of the players
+// eligible to answer the current question have done so.
+type AnswerProgress struct {
+	Answered int `json:"answered"`
+	Eligible int `json:"eligible"`
+}
+
+// OptionCount is the number of players who chose a single answer option.
+type OptionCount struct {
+	Count   int  `json:"count"`
+	Correct bool `json:"correct"`
+}
+
+// AnswerSummary is a message object containing the final distribution of
+// answers to a question, in the same order as the question's answers.
+type AnswerSummary struct {
+	AnswerProgress
+	Options []OptionCount `json:"options"`
+}
+
+// Resync is a message object containing everything a host needs to resume
+// from wherever the game currently is, after having been disconnected.
+type Resync struct {
+	Stage       string        `json:"stage"`
+	Paused      bool          `json:"paused"`
+	Players     []RosterEntry `json:"players"`
+	Question    *QuestionInfo `json:"question"`
+	Deadline    int64         `json:"deadline"`
+	Leaderboard Leaderboard   `json:"leaderboard"`
+	Answers     AnswerSummary `json:"answers"`
+	ModLog      []ModEntry    `json:"modlog"`
+}
+
 // The Host of a game is the client which receives incoming question texts and
 // which handles time synchronisation for the rest of the game.
 type Host struct {
 	Client
 }
 
+// Run is the host runner thread. It continually receives from the host's
+// websocket connection, translating commands into actions for the game
+// runner, until the connection is lost. Losing the host does not end the
+// game immediately; see HostDisconnect.
 func (h Host) Run(ev chan Action) {
 	h.Open()
 	defer func() {
 		select {
-		case ev <- EndGame{"host disconnect", false}:
+		case ev <- HostDisconnect{}:
 		case <-h.Ctx.Done():
 		}
 
@@ -33,27 +98,19 @@ readloop:
 		}
 
 		switch cmd {
-		case MessageCountdown:
-			time, err := strconv.ParseInt(data, 10, 32)
-			if err != nil {
-				log.Println("invalid countdown timer:", data)
-				break
+		case MessageClockSync:
+			if err := h.SyncClock(data); err != nil {
+				log.Println("host: invalid clock sync:", data)
 			}
-			ev <- StartGame{int(time)}
-		case MessageKick:
-			id, err := strconv.ParseInt(data, 10, 32)
+		default:
+			act, err := controlAction(cmd, data)
 			if err != nil {
+				log.Println("host:", err)
 				break
 			}
-			ev <- KickPlayer{int(id)}
-		case MessageStartGame:
-			ev <- StartGame{}
-		case MessageNextQuestion:
-			ev <- NextQuestion{}
-		case MessageAnswerNow:
-			ev <- StartAnswer{}
-		case MessageQuestionEnd:
-			ev <- EndAnswer{}
+			if act != nil {
+				ev <- act
+			}
 		}
 
 		select {
@@ -63,3 +120,149 @@ readloop:
 		}
 	}
 }
+
+// controlAction translates a game control command from a host into the
+// action it requests. If cmd is not a control command, the returned action is
+// nil.
+func controlAction(cmd, data string) (Action, error) {
+	switch cmd {
+	case MessageCountdown:
+		time, err := strconv.ParseInt(data, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid countdown timer: %s", data)
+		}
+		return StartGame{int(time)}, nil
+	case MessageKick:
+		var m modRequest
+		if err := m.parse(data); err != nil {
+			return nil, err
+		}
+		return KickPlayer{m.ID, m.Reason}, nil
+	case MessageBan:
+		var m modRequest
+		if err := m.parse(data); err != nil {
+			return nil, err
+		}
+		return BanPlayer{m.ID, m.Reason, m.Address}, nil
+	case MessageRename:
+		var m modRequest
+		if err := m.parse(data); err != nil {
+			return nil, err
+		}
+		return RenamePlayer{m.ID, m.Reason}, nil
+	case MessageStartGame:
+		return StartGame{}, nil
+	case MessageNextQuestion:
+		return NextQuestion{}, nil
+	case MessageAnswerNow:
+		return StartAnswer{}, nil
+	case MessageQuestionEnd:
+		return EndAnswer{}, nil
+	case MessagePause:
+		return PauseGame{}, nil
+	case MessageResume:
+		return ResumeGame{}, nil
+	case MessageExtendTime:
+		secs, err := strconv.ParseInt(data, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time extension: %s", data)
+		}
+		return ExtendTime{int(secs)}, nil
+	case MessageJump:
+		idx, err := strconv.ParseInt(data, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid question index: %s", data)
+		}
+		return JumpQuestion{int(idx)}, nil
+	case MessageApprove:
+		id, err := strconv.ParseInt(data, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid player ID: %s", data)
+		}
+		return ApprovePlayer{int(id)}, nil
+	case MessageReject:
+		id, err := strconv.ParseInt(data, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid player ID: %s", data)
+		}
+		return RejectPlayer{int(id)}, nil
+	}
+
+	return nil, nil
+}
+
+// hostResync builds the state required for a host to resume the game from
+// the current point.
+func (game *Game) hostResync() Resync {
+	r := Resync{
+		Stage:   StageLobby,
+		Paused:  game.state.paused,
+		Players: make([]RosterEntry, 0, len(game.state.Players)),
+		ModLog:  game.state.modlog,
+	}
+	for _, plr := range game.state.Players {
+		if !plr.Removed {
+			r.Players = append(r.Players, RosterEntry{plr.Info(), plr.Connected, plr.Pending})
+		}
+	}
+	if game.state.Status != GameRunning {
+		return r
+	}
+
+	r.Question = &QuestionInfo{
+		game.Questions[game.state.CurrentQuestion],
+		game.state.CurrentQuestion + 1,
+		len(game.Questions),
+	}
+	r.Answers.AnswerProgress = game.answerProgress()
+	switch {
+	case game.state.questionDone:
+		r.Stage = StageResults
+		r.Answers = game.answerSummary()
+		r.Leaderboard = NewLeaderboard(game.state.Players)
+		if len(r.Leaderboard) > LeaderboardClip {
+			r.Leaderboard = r.Leaderboard[:LeaderboardClip]
+		}
+	case game.state.countdownDone:
+		r.Stage = StageQuestion
+		r.Deadline = Timestamp(game.deadline())
+	default:
+		r.Stage = StageCountdown
+	}
+
+	return r
+}
+
+// answerProgress counts the answers received so far for the current question.
+func (game *Game) answerProgress() AnswerProgress {
+	var p AnswerProgress
+	for _, plr := range game.state.Players {
+		if plr.canAnswer {
+			p.Eligible++
+			if plr.answer > 0 {
+				p.Answered++
+			}
+		}
+	}
+
+	return p
+}
+
+// answerSummary tallies the answers given for the current question by option.
+func (game *Game) answerSummary() AnswerSummary {
+	answers := game.Questions[game.state.CurrentQuestion].Answers
+	sum := AnswerSummary{
+		AnswerProgress: game.answerProgress(),
+		Options:        make([]OptionCount, len(answers)),
+	}
+	for i, ans := range answers {
+		sum.Options[i].Correct = ans.Correct
+	}
+	for _, plr := range game.state.Players {
+		if plr.canAnswer && plr.answer > 0 && plr.answer <= len(answers) {
+			sum.Options[plr.answer-1].Count++
+		}
+	}
+
+	return sum
+}
@@ -0,0 +1,202 @@
+package game
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ejv2/gahoot/game/nick"
+)
+
+// Moderation actions recorded in the moderation log.
+const (
+	ModKick   = "kick"
+	ModBan    = "ban"
+	ModRename = "rename"
+	ModReject = "reject"
+)
+
+// MaxCloseReason is the longest close reason which fits in a websocket close
+// frame, which is limited to 125 bytes including the two byte status code.
+const MaxCloseReason = 123
+
+// ModEntry is a single entry in a game's moderation log.
+type ModEntry struct {
+	Time   int64  `json:"time"`
+	Action string `json:"action"`
+	Player string `json:"player"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// modRequest is the body of a host moderation command. For compatibility
+// with older hosts, a bare player ID is also accepted.
+type modRequest struct {
+	ID      int    `json:"id"`
+	Reason  string `json:"reason"`
+	Address bool   `json:"addr"`
+}
+
+func (m *modRequest) parse(data string) error {
+	if id, err := strconv.ParseInt(data, 10, 32); err == nil {
+		m.ID = int(id)
+		return nil
+	}
+	if err := json.Unmarshal([]byte(data), m); err != nil {
+		return fmt.Errorf("invalid moderation request: %s", data)
+	}
+
+	m.Reason = strings.TrimSpace(m.Reason)
+	return nil
+}
+
+// closeReason formats a close message for a removed player, truncating the
+// host's reason so that the whole message fits in a close frame.
+func closeReason(prefix, reason string) string {
+	if reason == "" {
+		return prefix
+	}
+
+	msg := prefix + ": " + reason
+	for len(msg) > MaxCloseReason {
+		_, size := utf8.DecodeLastRuneInString(msg)
+		msg = msg[:len(msg)-size]
+	}
+	return msg
+}
+
+// moderate records a moderation action against plr in the moderation log and
+// informs the host, if connected.
+func (game *Game) moderate(plr *Player, action, reason string) {
+	e := ModEntry{
+		Time:   Timestamp(game.now()),
+		Action: action,
+		Player: plr.Nick,
+		Reason: reason,
+	}
+	game.state.modlog = append(game.state.modlog, e)
+
+	if game.state.Host != nil {
+		game.sendHost(CommandModLog, e)
+	}
+}
+
+// removePlayer takes plr out of the game, disconnecting them with the
+// message why. Their session token is revoked and their nickname released,
+// but their slot is kept so that player IDs do not change.
+func (game *Game) removePlayer(plr *Player, why string) {
+	plr.Removed = true
+	delete(game.state.namecache, nick.Skeleton(plr.Nick))
+	delete(game.state.tokens, plr.token)
+
+	if plr.Connected {
+		plr.Connected = false
+		plr.CloseReason(why)
+		plr.Cancel()
+	}
+	if game.state.Host != nil {
+		game.sendHost(CommandRemovePlayer, plr.Info())
+	}
+}
+
+// banned returns true if either a client address or session token has been
+// banned from this game.
+func (game *Game) banned(addr, tok string) bool {
+	if _, ok := game.state.bannedAddrs[addr]; ok && addr != "" {
+		return true
+	}
+	_, ok := game.state.bannedTokens[tok]
+	return ok && tok != ""
+}
+
+// KickPlayer disconnects a player from this game. The player's slot is freed
+// and they may join again under any free nickname.
+type KickPlayer struct {
+	ID     int
+	Reason string
+}
+
+func (k KickPlayer) Perform(game *Game) {
+	if k.ID <= 0 || k.ID > len(game.state.Players) {
+		log.Printf("attempted to kick invalid player (ID: %d) [%s]", k.ID, game.PIN)
+		return
+	}
+	plr := &game.state.Players[k.ID-1]
+	if plr.Removed {
+		return
+	}
+
+	log.Printf("%s (ID: %d) kicked from %s", plr.Nick, plr.ID, game.PIN)
+	game.moderate(plr, ModKick, k.Reason)
+	game.removePlayer(plr, closeReason("kicked by host", k.Reason))
+}
+
+// BanPlayer disconnects a player from this game and prevents them from
+// rejoining. The player's session token is always banned. If Address is set,
+// any client from the same address is also turned away, which may affect
+// other players behind the same network.
+type BanPlayer struct {
+	ID      int
+	Reason  string
+	Address bool
+}
+
+func (b BanPlayer) Perform(game *Game) {
+	if b.ID <= 0 || b.ID > len(game.state.Players) {
+		log.Printf("attempted to ban invalid player (ID: %d) [%s]", b.ID, game.PIN)
+		return
+	}
+	plr := &game.state.Players[b.ID-1]
+	if plr.Banned {
+		return
+	}
+
+	if game.state.bannedTokens == nil {
+		game.state.bannedTokens = make(map[string]struct{})
+		game.state.bannedAddrs = make(map[string]struct{})
+	}
+	plr.Banned = true
+	game.state.bannedTokens[plr.token] = struct{}{}
+	if b.Address && plr.addr != "" {
+		game.state.bannedAddrs[plr.addr] = struct{}{}
+	}
+
+	log.Printf("%s (ID: %d) banned from %s", plr.Nick, plr.ID, game.PIN)
+	game.moderate(plr, ModBan, b.Reason)
+	if !plr.Removed {
+		game.removePlayer(plr, closeReason("banned by host", b.Reason))
+	}
+}
+
+// RenamePlayer replaces an unsuitable nickname with a generated one. The
+// player may not re-roll the new name.
+type RenamePlayer struct {
+	ID     int
+	Reason string
+}
+
+func (r RenamePlayer) Perform(game *Game) {
+	if r.ID <= 0 || r.ID > len(game.state.Players) {
+		log.Printf("attempted to rename invalid player (ID: %d) [%s]", r.ID, game.PIN)
+		return
+	}
+	plr := &game.state.Players[r.ID-1]
+	if plr.Removed {
+		return
+	}
+
+	game.moderate(plr, ModRename, r.Reason)
+	name := game.generateNick()
+	delete(game.state.namecache, nick.Skeleton(plr.Nick))
+	game.state.namecache[nick.Skeleton(name)] = struct{}{}
+	log.Printf("%s (ID: %d) renamed to %s [%s]", plr.Nick, plr.ID, name, game.PIN)
+	plr.Nick = name
+	plr.rerolls = MaxRerolls
+
+	game.sendNick(plr)
+	if !plr.Pending {
+		game.sendHost(CommandRenamePlayer, plr.Info())
+	}
+}
@@ -0,0 +1,60 @@
+package nick
+
+// confusables maps characters to the lower case Latin character which they
+// are most easily mistaken for. This is a small subset of the Unicode
+// confusables data, covering the scripts most likely to be used to imitate
+// another player's name. Characters which NFKC normalisation already folds
+// (such as full width forms) are not listed.
+//
+// Note that "l", "i" and "1" all map to "l", as do their look-alikes.
+var confusables = map[rune]rune{
+	// Latin
+	'1': 'l', 'I': 'l', 'i': 'l', '|': 'l', 'ı': 'l',
+	'0': 'o',
+	'ſ': 'f',
+
+	// Greek
+	'Α': 'a', 'α': 'a', 'Β': 'b', 'β': 'b', 'Ε': 'e', 'ε': 'e',
+	'Ζ': 'z', 'Η': 'h', 'Ι': 'l', 'ι': 'l', 'Κ': 'k', 'κ': 'k',
+	'Μ': 'm', 'Ν': 'n', 'ν': 'v', 'Ο': 'o', 'ο': 'o', 'Ρ': 'p',
+	'ρ': 'p', 'Τ': 't', 'τ': 't', 'Υ': 'y', 'υ': 'u', 'Χ': 'x',
+	'χ': 'x', 'ϲ': 'c', 'Ϲ': 'c',
+
+	// Cyrillic
+	'А': 'a', 'а': 'a', 'В': 'b', 'в': 'b', 'Е': 'e', 'е': 'e',
+	'К': 'k', 'к': 'k', 'М': 'm', 'м': 'm', 'Н': 'h', 'н': 'h',
+	'О': 'o', 'о': 'o', 'Р': 'p', 'р': 'p', 'С': 'c', 'с': 'c',
+	'Т': 't', 'т': 't', 'У': 'y', 'у': 'y', 'Х': 'x', 'х': 'x',
+	'Ѕ': 's', 'ѕ': 's', 'І': 'l', 'і': 'l', 'Ј': 'j', 'ј': 'j',
+	'Ԁ': 'd', 'ԁ': 'd', 'Ԛ': 'q', 'ԛ': 'q', 'Ԝ': 'w', 'ԝ': 'w',
+	'Ү': 'y', 'ү': 'y', 'Һ': 'h', 'һ': 'h', 'Ӏ': 'l', 'ӏ': 'l',
+	'Ь': 'b', 'ь': 'b', 'Г': 'r', 'г': 'r', 'П': 'n', 'п': 'n',
+
+	// Cherokee
+	'Ꭺ': 'a', 'Ᏼ': 'b', 'Ꮯ': 'c', 'Ꭰ': 'd', 'Ꭼ': 'e', 'Ꮐ': 'g',
+	'Ꮋ': 'h', 'Ꭻ': 'j', 'Ꮶ': 'k', 'Ꮮ': 'l', 'Ꮇ': 'm', 'Ꮎ': 'o',
+	'Ꮲ': 'p', 'Ꮪ': 's', 'Ꭲ': 't', 'Ꮩ': 'v', 'Ꮃ': 'w', 'Ꮓ': 'z',
+
+	// Armenian
+	'օ': 'o', 'ս': 'u', 'ց': 'g', 'հ': 'h', 'ո': 'n',
+}
+
+// leet maps characters commonly substituted for letters to the letter they
+// stand in for. As it is applied after confusables, "1" and "0" have already
+// become "l" and "o", and stand-ins for "i" must likewise map to "l".
+var leet = map[rune]rune{
+	'2': 'z',
+	'3': 'e',
+	'4': 'a',
+	'5': 's',
+	'6': 'g',
+	'7': 't',
+	'8': 'b',
+	'9': 'g',
+	'@': 'a',
+	'$': 's',
+	'!': 'l',
+	'+': 't',
+	'€': 'e',
+	'£': 'l',
+}
@@ -0,0 +1,18 @@
+// Package nick implements the nickname policy for players joining a game.
+//
+// Nicknames are first cleaned, which normalises them to Unicode NFKC, strips
+// control and formatting characters and collapses runs of whitespace. The
+// cleaned nickname is what other players see. It is then checked against the
+// policy's length limits and word blocklist.
+//
+// Uniqueness is decided by a nickname's skeleton rather than its text. The
+// skeleton folds case and maps characters which look alike (such as the
+// Cyrillic "о" and the Latin "o", or "l", "I" and "1") to a single
+// representative, in the spirit of the Unicode confusables algorithm (UTS
+// #39). Two nicknames with the same skeleton would look the same on screen,
+// so cannot both be used in one game.
+//
+// Blocklist matching undoes common leetspeak substitutions and ignores
+// anything other than letters, such that "b.a.d" and "b4d" are both caught by
+// the word "bad". Blocked words are matched anywhere within the nickname.
+package nick
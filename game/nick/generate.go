@@ -0,0 +1,49 @@
+package nick
+
+import (
+	_ "embed"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// MaxGenerateAttempts is the number of random names tried by Generate before
+// falling back to adding a number to the name.
+const MaxGenerateAttempts = 32
+
+// Word lists for generated names. Every combination of adjective and animal
+// must pass the default nickname policy.
+var (
+	//go:embed adjectives.txt
+	adjectiveList string
+	//go:embed animals.txt
+	animalList string
+
+	adjectives = strings.Fields(adjectiveList)
+	animals    = strings.Fields(animalList)
+)
+
+// Generate returns a random friendly name, such as "Happy Otter", for which
+// taken returns false. taken is passed the skeleton of each candidate name.
+func Generate(taken func(skel string) bool) string {
+	var name string
+	for i := 0; i < MaxGenerateAttempts; i++ {
+		name = randomName()
+		if !taken(Skeleton(name)) {
+			return name
+		}
+	}
+
+	// Unlucky or very large game; number the last name tried
+	for i := 2; ; i++ {
+		n := name + " " + strconv.Itoa(i)
+		if !taken(Skeleton(n)) {
+			return n
+		}
+	}
+}
+
+// randomName returns a random combination of adjective and animal.
+func randomName() string {
+	return adjectives[rand.Intn(len(adjectives))] + " " + animals[rand.Intn(len(animals))]
+}
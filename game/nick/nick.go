@@ -0,0 +1,175 @@
+package nick
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Default policy limits.
+const (
+	DefaultMinLength = 2
+	DefaultMaxLength = 20
+)
+
+// Nickname rejection reasons.
+var (
+	ErrEmpty    = errors.New("nick: nickname is empty")
+	ErrTooShort = errors.New("nick: nickname too short")
+	ErrTooLong  = errors.New("nick: nickname too long")
+	ErrBlocked  = errors.New("nick: nickname not allowed")
+)
+
+// A Policy decides which nicknames are acceptable. The zero value is a valid
+// policy using the default length limits with no blocked words.
+type Policy struct {
+	// MinLength and MaxLength are the limits on the length of a cleaned
+	// nickname, in characters. If zero, the defaults are used.
+	MinLength int
+	MaxLength int
+
+	// blocked contains the folded form of each blocked word.
+	blocked []string
+}
+
+// NewPolicy returns a new policy with the given length limits, which blocks
+// any nickname containing a word in blocklist.
+func NewPolicy(min, max int, blocklist []string) Policy {
+	p := Policy{MinLength: min, MaxLength: max}
+	for _, w := range blocklist {
+		if f := fold(w); f != "" {
+			p.blocked = append(p.blocked, f)
+		}
+	}
+
+	return p
+}
+
+// LoadBlocklist reads a list of blocked words from the file at path, which
+// contains one word per line. Blank lines and lines beginning with "#" are
+// ignored.
+func LoadBlocklist(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("nick: load blocklist: %w", err)
+	}
+	defer f.Close()
+
+	var words []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		l := strings.TrimSpace(s.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		words = append(words, l)
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("nick: load blocklist: %w", err)
+	}
+
+	return words, nil
+}
+
+// Check cleans nick and checks it against the policy. The cleaned nickname is
+// returned if acceptable, else an error describing why it was rejected.
+func (p Policy) Check(nick string) (string, error) {
+	min, max := p.MinLength, p.MaxLength
+	if min <= 0 {
+		min = DefaultMinLength
+	}
+	if max <= 0 {
+		max = DefaultMaxLength
+	}
+
+	nick = Clean(nick)
+	n := utf8.RuneCountInString(nick)
+	switch {
+	case n == 0:
+		return "", ErrEmpty
+	case n < min:
+		return "", ErrTooShort
+	case n > max:
+		return "", ErrTooLong
+	}
+
+	f := fold(nick)
+	for _, w := range p.blocked {
+		if strings.Contains(f, w) {
+			return "", ErrBlocked
+		}
+	}
+
+	return nick, nil
+}
+
+// Clean normalises nick to NFKC, removes control and formatting characters
+// and collapses all whitespace to single spaces.
+func Clean(nick string) string {
+	nick = norm.NFKC.String(nick)
+
+	b := &strings.Builder{}
+	space := false
+	for _, r := range nick {
+		switch {
+		case unicode.IsSpace(r):
+			space = b.Len() > 0
+			continue
+		case unicode.IsControl(r), unicode.Is(unicode.Cf, r), !unicode.IsPrint(r):
+			continue
+		}
+
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// Skeleton returns the form of nick used to decide uniqueness. Nicknames
+// which would look the same on screen have the same skeleton.
+func Skeleton(nick string) string {
+	nick = norm.NFD.String(Clean(nick))
+
+	b := &strings.Builder{}
+	for _, r := range nick {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if c, ok := confusables[r]; ok {
+			r = c
+		}
+		r = unicode.ToLower(r)
+		if c, ok := confusables[r]; ok {
+			r = c
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// fold returns the letters of s, with confusables and leetspeak
+// substitutions undone, for matching against blocked words.
+func fold(s string) string {
+	b := &strings.Builder{}
+	for _, r := range Skeleton(s) {
+		if l, ok := leet[r]; ok {
+			r = l
+		}
+		if unicode.IsLetter(r) {
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,105 @@
+package nick
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestClean(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{"bob", "bob"},
+		{"  bob  ", "bob"},
+		{"bob\t\n the  builder", "bob the builder"},
+		{"b\u200bo\u0000b", "bob"},
+		{"Ｂｏｂ", "Bob"},
+	}
+
+	for _, tt := range tests {
+		if got := Clean(tt.in); got != tt.out {
+			t.Errorf("Clean(%q): got %q, expected %q", tt.in, got, tt.out)
+		}
+	}
+}
+
+func TestSkeleton(t *testing.T) {
+	same := [][]string{
+		{"Bob", "bob", "BOB", "Ᏼob", "Bоb", "bób"},
+		{"Bill", "bi11", "BiII", "bіll"},
+		{"Zoe", "Z0e", "zoе"},
+	}
+	for _, set := range same {
+		want := Skeleton(set[0])
+		for _, n := range set[1:] {
+			if got := Skeleton(n); got != want {
+				t.Errorf("Skeleton(%q) = %q, expected same as %q (%q)", n, got, set[0], want)
+			}
+		}
+	}
+
+	if Skeleton("bob") == Skeleton("rob") {
+		t.Error("distinct nicknames share a skeleton")
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	p := NewPolicy(3, 8, []string{"bad", "Worse", "kill"})
+
+	tests := []struct {
+		in  string
+		err error
+	}{
+		{"alice", nil},
+		{"  al  ", ErrTooShort},
+		{"\u200b\t", ErrEmpty},
+		{"abcdefghi", ErrTooLong},
+		{"verybad", ErrBlocked},
+		{"b4d guy", ErrBlocked},
+		{"b.a.d", ErrBlocked},
+		{"W0R5E", ErrBlocked},
+		{"вad", ErrBlocked},
+		{"badge", ErrBlocked},
+		{"k!ll", ErrBlocked},
+		{"K!!!", ErrBlocked},
+		{"ki11", ErrBlocked},
+		{"bead", nil},
+	}
+
+	for _, tt := range tests {
+		_, err := p.Check(tt.in)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("Check(%q): got error %v, expected %v", tt.in, err, tt.err)
+		}
+	}
+
+	var zero Policy
+	if _, err := zero.Check("x"); !errors.Is(err, ErrTooShort) {
+		t.Error("zero policy did not apply default minimum length")
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	var p Policy
+	for _, a := range adjectives {
+		for _, b := range animals {
+			if _, err := p.Check(a + " " + b); err != nil {
+				t.Errorf("generated name %q rejected by default policy: %v", a+" "+b, err)
+			}
+		}
+	}
+
+	// Every name must be unique, even past the number of combinations
+	used := make(map[string]struct{})
+	taken := func(skel string) bool {
+		_, ok := used[skel]
+		return ok
+	}
+	for i := 0; i < len(adjectives)*len(animals)+10; i++ {
+		n := Generate(taken)
+		if taken(Skeleton(n)) {
+			t.Fatalf("generated duplicate name %q", n)
+		}
+		used[Skeleton(n)] = struct{}{}
+	}
+}
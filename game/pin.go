@@ -0,0 +1,100 @@
+package game
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+)
+
+// PIN length limits, in digits including the check digit.
+const (
+	MinPinLength = 6
+	MaxPinLength = 10
+)
+
+// PIN value limits. The smallest PIN is the smallest number of MinPinLength
+// digits, and the largest must fit in a Pin.
+const (
+	MinGamePin = 100000
+	MaxGamePin = 4294967295
+)
+
+// PIN errors.
+var (
+	ErrorInvalidPin  = fmt.Errorf("game: invalid game PIN")
+	ErrorPinMistyped = fmt.Errorf("game: game PIN check digit mismatch")
+)
+
+// Pin is the PIN which can be used to join a game. The last digit is a check
+// digit computed over the others, which catches any single mistyped digit and
+// any swap of two adjacent digits.
+// It is constrained at an int32, as that is the smallest int type which
+// provides enough space without allowing any higher inputs.
+type Pin uint32
+
+// String formats the game PIN in decimal. PINs never begin with a zero.
+func (p Pin) String() string {
+	return strconv.FormatUint(uint64(p), 10)
+}
+
+// Validate returns true if a game pin is within the required limits and has a
+// correct check digit.
+func (p Pin) Validate() bool {
+	return p >= MinGamePin && p <= MaxGamePin && damm(p.String()) == 0
+}
+
+// ParsePin parses a game PIN entered by a user. Returns ErrorPinMistyped if
+// it is a plausible PIN with the wrong check digit, which most likely means
+// the user made a typo, or ErrorInvalidPin if it is not a PIN at all.
+func ParsePin(s string) (Pin, error) {
+	i, err := strconv.ParseUint(s, 10, 32)
+	if err != nil || i < MinGamePin {
+		return 0, ErrorInvalidPin
+	}
+	if !Pin(i).Validate() {
+		return 0, ErrorPinMistyped
+	}
+
+	return Pin(i), nil
+}
+
+// generatePin generates a pseudorandom game PIN of length digits, the last of
+// which is the check digit, ensuring to eliminate possible overflows.
+func generatePin(length int) Pin {
+	lo := int64(1)
+	for i := 2; i < length; i++ {
+		lo *= 10
+	}
+	hi := lo*10 - 1
+	if max := int64(MaxGamePin-9) / 10; hi > max {
+		hi = max
+	}
+
+	body := strconv.FormatInt(rand.Int63n(hi-lo+1)+lo, 10)
+	pin, _ := strconv.ParseUint(body+strconv.Itoa(damm(body)), 10, 32)
+	return Pin(pin)
+}
+
+// dammTable is the quasigroup used by the Damm algorithm.
+var dammTable = [10][10]int{
+	{0, 3, 1, 7, 5, 9, 8, 6, 4, 2},
+	{7, 0, 9, 2, 1, 5, 4, 8, 6, 3},
+	{4, 2, 0, 6, 8, 7, 1, 3, 5, 9},
+	{1, 7, 5, 0, 9, 8, 3, 4, 2, 6},
+	{6, 1, 2, 3, 0, 4, 5, 9, 7, 8},
+	{3, 6, 7, 4, 2, 0, 9, 5, 8, 1},
+	{5, 8, 6, 9, 7, 2, 0, 1, 3, 4},
+	{8, 9, 4, 5, 3, 6, 2, 0, 1, 7},
+	{9, 4, 3, 8, 6, 1, 7, 2, 0, 5},
+	{2, 5, 8, 1, 4, 3, 6, 7, 9, 0},
+}
+
+// damm returns the Damm check digit of the decimal digits in s. A number
+// followed by its own check digit has a check digit of zero.
+func damm(s string) int {
+	interim := 0
+	for _, c := range s {
+		interim = dammTable[interim][c-'0']
+	}
+	return interim
+}
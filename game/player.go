@@ -5,6 +5,8 @@ import (
 	"sort"
 	"strconv"
 	"time"
+
+	"github.com/ejv2/gahoot/game/results"
 )
 
 // PlayerInfo is a message object, mirroring the PlayerData interface on
@@ -38,10 +40,22 @@ type Player struct {
 	Streak  int
 
 	Banned bool
-
+	// Removed players have been kicked, banned or rejected. Their slot is
+	// kept so that player IDs remain stable, but they take no further
+	// part in the game.
+	Removed bool
+	// Pending players are waiting for the host to approve them, and take
+	// no part in the game until they are.
+	Pending bool
+
+	token      string
+	addr       string
+	claimed    bool
+	rerolls    int
 	canAnswer  bool
 	answeredAt time.Time
 	answer     int
+	answers    []results.Answer
 }
 
 // Run is the game runner thread. It continually receives from the "conn"
@@ -78,6 +92,22 @@ readloop:
 				return
 			}
 			ev <- Answer{p.ID, int(ans)}
+		case MessageAcknowledge:
+			if err := p.Acknowledge(data); err != nil {
+				log.Println(p.Nick, "sent invalid acknowledgement", data)
+				p.CloseReason(err.Error())
+				return
+			}
+		case MessageClockSync:
+			if err := p.SyncClock(data); err != nil {
+				log.Println(p.Nick, "sent invalid clock sync", data)
+				p.CloseReason(err.Error())
+				return
+			}
+		case MessageReroll:
+			ev <- RerollNick{p.ID}
+		case MessageLeave:
+			ev <- LeavePlayer{p.ID}
 		default:
 			log.Println(p.ID, "sent bad message", cmd)
 			p.CloseReason("invalid command")
@@ -96,6 +126,11 @@ readloop:
 	p.Close()
 }
 
+// Address returns the address from which the player last connected.
+func (p Player) Address() string {
+	return p.addr
+}
+
 // Info extracts a player's information into a PlayerInfo struct, stuitable for
 // websocket transmission to a client.
 func (p Player) Info() PlayerInfo {
@@ -113,10 +148,13 @@ func (p Player) Info() PlayerInfo {
 type Leaderboard []PlayerInfo
 
 // NewLeaderboard copies all players from plrs into a new leaderboard.
+// Players still waiting for approval or removed from the game are left out.
 func NewLeaderboard(plrs []Player) (l Leaderboard) {
-	l = make([]PlayerInfo, len(plrs))
-	for i, p := range plrs {
-		l[i] = p.Info()
+	l = make([]PlayerInfo, 0, len(plrs))
+	for _, p := range plrs {
+		if !p.Pending && !p.Removed {
+			l = append(l, p.Info())
+		}
 	}
 	sort.Sort(l)
 	return l
@@ -5,6 +5,7 @@ import (
 	"hash"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -55,14 +56,25 @@ type Manager struct {
 	qs map[string]Quiz
 	// cats contains registered categories
 	cats map[string]bool
+	// hidden contains the hashes of quizzes hidden from listings
+	hidden map[string]bool
+}
+
+// Entry is a quiz held by the manager, along with its bookkeeping.
+type Entry struct {
+	Quiz
+	Hash   string
+	Hidden bool
+	Loaded time.Time
 }
 
 // NewManager allocates and returns a GameManager ready for use.
 func NewManager() Manager {
 	return Manager{
-		mut:  new(sync.RWMutex),
-		qs:   make(map[string]Quiz),
-		cats: make(map[string]bool),
+		mut:    new(sync.RWMutex),
+		qs:     make(map[string]Quiz),
+		cats:   make(map[string]bool),
+		hidden: make(map[string]bool),
 	}
 }
 
@@ -170,6 +182,85 @@ func (m *Manager) LoadDir(path string) ([]Quiz, error) {
 	return qs, errs
 }
 
+// Unload removes the quiz with the stringified hash h, returning false if
+// there is no such quiz.
+func (m *Manager) Unload(h string) bool {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	if _, ok := m.qs[h]; !ok {
+		return false
+	}
+	delete(m.qs, h)
+	delete(m.hidden, h)
+	m.recategorise()
+	return true
+}
+
+// SetHidden hides or shows the quiz with the stringified hash h in listings.
+// Hidden quizzes may still be played by anybody with a direct link. Returns
+// false if there is no such quiz.
+func (m *Manager) SetHidden(h string, hidden bool) bool {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	if _, ok := m.qs[h]; !ok {
+		return false
+	}
+	if hidden {
+		m.hidden[h] = true
+	} else {
+		delete(m.hidden, h)
+	}
+	m.recategorise()
+	return true
+}
+
+// ReloadDir replaces every quiz loaded from the filesystem with those now
+// found under path, leaving quizzes from any other source untouched. Hidden
+// quizzes stay hidden if they are found again. Errors are as for LoadDir, and
+// nothing is replaced if a fatal error is encountered.
+func (m *Manager) ReloadDir(path string) ([]Quiz, error) {
+	fresh := NewManager()
+	qs, err := fresh.LoadDir(path)
+	if _, ok := err.(LoadDirError); err != nil && !ok {
+		return nil, err
+	}
+
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	for h, q := range m.qs {
+		if q.source == SourceFilesystem {
+			delete(m.qs, h)
+		}
+	}
+	for h, q := range fresh.qs {
+		if _, ok := m.qs[h]; !ok {
+			m.qs[h] = q
+		}
+	}
+	for h := range m.hidden {
+		if _, ok := m.qs[h]; !ok {
+			delete(m.hidden, h)
+		}
+	}
+	m.recategorise()
+
+	return qs, err
+}
+
+// recategorise rebuilds the set of categories from the loaded quizzes. The
+// caller must hold m.mut.
+func (m *Manager) recategorise() {
+	m.cats = make(map[string]bool)
+	for h, q := range m.qs {
+		if !m.hidden[h] {
+			m.cats[q.Category] = true
+		}
+	}
+}
+
 // Get fetches a quiz with the corresponding hash.
 func (m *Manager) Get(h hash.Hash) (Quiz, bool) {
 	m.mut.RLock()
@@ -192,19 +283,38 @@ func (m *Manager) GetString(h string) (Quiz, bool) {
 	return q, ok
 }
 
-// GetAll returns every registered quiz from the quiz map.
+// GetAll returns every registered quiz from the quiz map which is not hidden.
 func (m *Manager) GetAll() []Quiz {
 	m.mut.RLock()
 	defer m.mut.RUnlock()
 
 	all := make([]Quiz, 0, len(m.qs))
-	for _, quiz := range m.qs {
-		all = append(all, quiz)
+	for h, quiz := range m.qs {
+		if !m.hidden[h] {
+			all = append(all, quiz)
+		}
 	}
 
 	return all
 }
 
+// Entries returns every registered quiz, including those which are hidden,
+// sorted by title.
+func (m *Manager) Entries() []Entry {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+
+	ents := make([]Entry, 0, len(m.qs))
+	for h, q := range m.qs {
+		ents = append(ents, Entry{q, h, m.hidden[h], q.inserted})
+	}
+	sort.Slice(ents, func(i, j int) bool {
+		return ents[i].Title < ents[j].Title
+	})
+
+	return ents
+}
+
 // GetCategories returns all recognised distinct categories from loaded game
 // archives. The returned slice is guaranteed to contain no duplicates or
 // unused categories.
@@ -156,6 +156,75 @@ func TestGetAll(t *testing.T) {
 	}
 }
 
+func TestHideUnload(t *testing.T) {
+	mgr := NewManager()
+	for _, elem := range QuizTests {
+		mgr.Load(elem)
+	}
+
+	h := QuizTests[0].String()
+	if !mgr.SetHidden(h, true) {
+		t.Fatal("hiding loaded quiz failed")
+	}
+	if len(mgr.GetAll()) != len(QuizTests)-1 || len(mgr.Entries()) != len(QuizTests) {
+		t.Errorf("hidden quiz: listed %d of %d entries", len(mgr.GetAll()), len(mgr.Entries()))
+	}
+	if _, ok := mgr.GetString(h); !ok {
+		t.Error("hidden quiz could not be fetched directly")
+	}
+	for _, cat := range mgr.GetCategories() {
+		if cat == "Technology" {
+			t.Error("category of hidden quiz still listed")
+		}
+	}
+
+	if !mgr.Unload(h) || mgr.Unload(h) {
+		t.Error("expected unloading to succeed exactly once")
+	}
+	if _, ok := mgr.GetString(h); ok || len(mgr.Entries()) != len(QuizTests)-1 {
+		t.Error("unloaded quiz still present")
+	}
+}
+
+func TestReloadDir(t *testing.T) {
+	mgr := NewManager()
+	if _, err := mgr.LoadDir(QuizDir); err != nil {
+		t.Fatal(err)
+	}
+	up := QuizTests[0]
+	up.source = SourceUpload
+	mgr.Load(up)
+
+	// Hide one quiz from disk and unload the rest
+	hidden := ""
+	for _, e := range mgr.Entries() {
+		if e.source != SourceFilesystem {
+			continue
+		}
+		if hidden == "" {
+			hidden = e.Hash
+			mgr.SetHidden(hidden, true)
+		} else {
+			mgr.Unload(e.Hash)
+		}
+	}
+
+	qs, err := mgr.ReloadDir(QuizDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(qs) != Contained || len(mgr.Entries()) != Contained+1 {
+		t.Errorf("reloaded %d quizzes, holding %d, expected %d and %d", len(qs), len(mgr.Entries()), Contained, Contained+1)
+	}
+	if len(mgr.GetAll()) != Contained {
+		t.Error("hidden quiz was shown again after reload")
+	}
+
+	if _, err := mgr.ReloadDir("doesnt-exist"); err == nil || len(mgr.Entries()) != Contained+1 {
+		t.Error("failed reload changed quizzes or returned no error")
+	}
+}
+
 func TestConcurrent(t *testing.T) {
 	mgr := NewManager()
 
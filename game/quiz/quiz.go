@@ -3,6 +3,7 @@ package quiz
 import (
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"hash"
 	"io"
@@ -25,6 +26,9 @@ const (
 	SourceUpload
 )
 
+// ErrInvalid is returned when a quiz cannot be played.
+var ErrInvalid = errors.New("quiz: invalid quiz")
+
 // An Answer is one option in a single question in a quiz.
 // It is simply a response title and a boolean for if the response is
 // acceptable as correct.
@@ -98,6 +102,40 @@ func LoadQuiz(src io.Reader, origin int) (Quiz, error) {
 	return q, nil
 }
 
+// Validate returns an error wrapping ErrInvalid if q cannot be played. A quiz
+// must have a title and at least one question, each of which must have a
+// title, a positive time limit and at least two answers, of which at least one
+// is correct.
+func (q Quiz) Validate() error {
+	if q.Title == "" {
+		return fmt.Errorf("%w: no title", ErrInvalid)
+	}
+	if len(q.Questions) == 0 {
+		return fmt.Errorf("%w: no questions", ErrInvalid)
+	}
+
+	for i, qn := range q.Questions {
+		switch {
+		case qn.Title == "":
+			return fmt.Errorf("%w: question %d: no title", ErrInvalid, i+1)
+		case qn.Duration <= 0:
+			return fmt.Errorf("%w: question %d: time must be positive", ErrInvalid, i+1)
+		case len(qn.Answers) < 2:
+			return fmt.Errorf("%w: question %d: fewer than two answers", ErrInvalid, i+1)
+		}
+
+		correct := false
+		for _, a := range qn.Answers {
+			correct = correct || a.Correct
+		}
+		if !correct {
+			return fmt.Errorf("%w: question %d: no correct answer", ErrInvalid, i+1)
+		}
+	}
+
+	return nil
+}
+
 // Hash returns the unique hash associated with this game instance. Hashes for
 // Gahoot game archives are performed based on JSON output including EVERY
 // field in the struct, even if those were not present in the original version.
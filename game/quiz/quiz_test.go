@@ -1,6 +1,7 @@
 package quiz_test
 
 import (
+	"errors"
 	"strings"
 	"testing"
 
@@ -83,3 +84,38 @@ func TestHash(t *testing.T) {
 		}
 	}
 }
+
+func TestValidate(t *testing.T) {
+	valid := func() quiz.Quiz {
+		return quiz.Quiz{
+			Title: "Quiz",
+			Questions: []quiz.Question{{
+				Title:    "Question",
+				Duration: 10,
+				Answers:  []quiz.Answer{{Title: "Yes", Correct: true}, {Title: "No"}},
+			}},
+		}
+	}
+	if err := valid().Validate(); err != nil {
+		t.Error("valid quiz rejected:", err)
+	}
+
+	tests := []struct {
+		Name   string
+		Modify func(q *quiz.Quiz)
+	}{
+		{"no title", func(q *quiz.Quiz) { q.Title = "" }},
+		{"no questions", func(q *quiz.Quiz) { q.Questions = nil }},
+		{"no question title", func(q *quiz.Quiz) { q.Questions[0].Title = "" }},
+		{"no time", func(q *quiz.Quiz) { q.Questions[0].Duration = 0 }},
+		{"one answer", func(q *quiz.Quiz) { q.Questions[0].Answers = q.Questions[0].Answers[:1] }},
+		{"no correct answer", func(q *quiz.Quiz) { q.Questions[0].Answers[0].Correct = false }},
+	}
+	for _, elem := range tests {
+		q := valid()
+		elem.Modify(&q)
+		if err := q.Validate(); !errors.Is(err, quiz.ErrInvalid) {
+			t.Errorf("%s: expected %v, got %v", elem.Name, quiz.ErrInvalid, err)
+		}
+	}
+}
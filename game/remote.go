@@ -0,0 +1,243 @@
+package game
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// RemoteBacklog is the number of messages which may be queued for a remote
+// control before it is considered too slow and disconnected.
+const RemoteBacklog = 64
+
+// A Remote is a secondary host control connection, such as a phone held by a
+// host who is away from the main screen. It receives the same messages as a
+// spectator and may send a subset of the host's control commands.
+//
+// Commands which only change the game state (kicking, pausing, resuming and
+// extending time) are performed directly. Commands which move the game between
+// screens (starting, skipping, moving on and jumping) are relayed to the host,
+// which remains the display and the game's timekeeper, and performs them as if
+// pressed on the main screen.
+//
+// Only one remote may be connected at once; a new remote replaces the old.
+// Losing the remote has no effect on the game.
+type Remote struct {
+	Client
+	ID int
+}
+
+// RemoteCommand is a message object containing a control command relayed from
+// a remote to the host.
+type RemoteCommand struct {
+	Action string `json:"action"`
+	Index  int    `json:"index,omitempty"`
+}
+
+// Run is the remote runner thread. It continually receives from the remote's
+// websocket connection, translating commands into actions for the game runner,
+// until the connection is lost.
+func (r Remote) Run(ev chan Action) {
+	r.Open()
+	defer func() {
+		select {
+		case ev <- RemoteDisconnect{r.ID}:
+		case <-r.Ctx.Done():
+		}
+
+		r.Cancel()
+		log.Printf("%s (remote) disconnected", r.conn.RemoteAddr())
+	}()
+
+readloop:
+	for {
+		cmd, data, err := r.ReadString()
+		if err != nil {
+			log.Println("remote:", err)
+			r.CloseReason(err.Error())
+			return
+		}
+
+		switch cmd {
+		case MessageClockSync:
+			if err := r.SyncClock(data); err != nil {
+				log.Println("remote: invalid clock sync:", data)
+			}
+		case MessagePending:
+			ev <- PendingPlayers{r.ID}
+		case MessageStartGame, MessageNextQuestion, MessageQuestionEnd:
+			ev <- RelayCommand{RemoteCommand{Action: cmd}}
+		case MessageJump:
+			idx, err := strconv.ParseInt(data, 10, 32)
+			if err != nil {
+				log.Println("remote: invalid question index:", data)
+				break
+			}
+			ev <- RelayCommand{RemoteCommand{Action: cmd, Index: int(idx)}}
+		case MessageKick, MessagePause, MessageResume, MessageExtendTime:
+			act, err := controlAction(cmd, data)
+			if err != nil {
+				log.Println("remote:", err)
+				break
+			}
+			ev <- act
+		default:
+			log.Println("remote: ignoring command", cmd)
+		}
+
+		select {
+		case <-r.Ctx.Done():
+			break readloop
+		default:
+		}
+	}
+}
+
+// ConnectRemote initializes a remote control connection, performing the
+// startup handshake asynchronously. When this is complete, submits a new
+// action to the game runner to install the remote, replacing any existing
+// remote.
+//
+// The handshake must contain the game's remote token.
+type ConnectRemote struct {
+	Conn *websocket.Conn
+	cl   Client
+	tok  string
+	fin  bool
+}
+
+func (c ConnectRemote) handleConnection(game Game) {
+	// Enforce 30s handshake deadline to stop deadlocking of the game thread
+	c.Conn.SetReadDeadline(time.Now().Add(time.Second * 30))
+	defer c.Conn.SetReadDeadline(*new(time.Time))
+
+	// Temporary client object. See ConnectPlayer for details.
+	c.cl = Client{
+		Connected: true,
+		conn:      c.Conn,
+		send:      nil,
+		Ctx:       context.Background(),
+	}
+	verb, err := c.cl.ReadMessage(&c.tok)
+	switch {
+	case err != nil:
+		c.cl.CloseReason(err.Error())
+		return
+	case verb != MessageRemote:
+		c.cl.CloseReason("expected first message to be REMOTE")
+		return
+	case subtle.ConstantTimeCompare([]byte(c.tok), []byte(game.RemoteToken)) != 1:
+		c.cl.CloseReason("invalid remote token")
+		return
+	}
+
+	c.fin = true
+	select {
+	case game.Action <- c:
+	case <-game.ctx.Done():
+		c.cl.Close()
+	}
+}
+
+func (c ConnectRemote) handleInsertion(game *Game) {
+	deadline, ok := game.ctx.Deadline()
+	if !ok {
+		panic("connectremote: found game with no deadline")
+	}
+
+	if game.state.remote != nil {
+		log.Println("Remote for", game.PIN.String(), "replaced")
+		game.state.remote.CloseReason("replaced by another remote")
+		game.state.remote.Cancel()
+	}
+
+	game.state.nextSpectator++
+	ctx, cancel := context.WithDeadline(game.ctx, deadline)
+	r := &Remote{
+		Client: Client{
+			Connected: true,
+			conn:      c.Conn,
+			Ctx:       ctx,
+			Cancel:    cancel,
+			send:      make(chan string, RemoteBacklog),
+			rec:       game.recipient("remote " + strconv.Itoa(game.state.nextSpectator)),
+		},
+		ID: game.state.nextSpectator,
+	}
+	game.state.remote = r
+	game.start(r.Client, r.Run)
+
+	log.Println("Remote connected to", game.PIN.String())
+	r.offer(FormatMessage(CommandResync, game.hostResync()))
+}
+
+func (c ConnectRemote) Perform(game *Game) {
+	if !c.fin {
+		go c.handleConnection(*game)
+		return
+	}
+
+	c.handleInsertion(game)
+}
+
+// RemoteDisconnect informs the game runner that the remote with the given ID
+// has been lost.
+type RemoteDisconnect struct {
+	ID int
+}
+
+func (r RemoteDisconnect) Perform(game *Game) {
+	if game.state.remote != nil && game.state.remote.ID == r.ID {
+		game.state.remote = nil
+	}
+}
+
+// RelayCommand passes a control command from the remote on to the host.
+type RelayCommand struct {
+	Command RemoteCommand
+}
+
+func (r RelayCommand) Perform(game *Game) {
+	if game.state.Host == nil || !game.state.Host.Connected {
+		log.Println(game.PIN, "remote command", r.Command.Action, "with no host: dropped")
+		return
+	}
+
+	go game.state.Host.SendMessage(CommandRemote, r.Command)
+}
+
+// PendingPlayers sends the remote with the given ID the list of players which
+// have yet to answer the current question.
+type PendingPlayers struct {
+	ID int
+}
+
+func (p PendingPlayers) Perform(game *Game) {
+	if game.state.remote == nil || game.state.remote.ID != p.ID {
+		return
+	}
+
+	pending := make([]PlayerInfo, 0)
+	if game.state.acceptingAnswers {
+		for _, plr := range game.state.Players {
+			if plr.canAnswer && plr.answer <= 0 {
+				pending = append(pending, plr.Info())
+			}
+		}
+	}
+
+	if !game.offer(game.state.remote.Client, FormatMessage(CommandPending, pending)) {
+		game.dropRemote()
+	}
+}
+
+// dropRemote disconnects a remote which is not keeping up with its messages.
+func (game *Game) dropRemote() {
+	log.Println("remote fell behind in", game.PIN.String(), "- disconnecting")
+	game.state.remote.Cancel()
+	game.state.remote = nil
+}
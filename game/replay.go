@@ -0,0 +1,475 @@
+package game
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ejv2/gahoot/game/events"
+	"github.com/ejv2/gahoot/game/nick"
+	"github.com/ejv2/gahoot/game/quiz"
+)
+
+// Replay errors.
+var (
+	ErrorReplay = fmt.Errorf("game: replay does not match event log")
+)
+
+// setup is the data of the start event of a game's event log, which is
+// everything needed to create the game again for a replay.
+type setup struct {
+	PIN      Pin       `json:"pin"`
+	ID       string    `json:"id"`
+	Quiz     quiz.Quiz `json:"quiz"`
+	Settings Settings  `json:"settings"`
+	Options  Options   `json:"options"`
+}
+
+// recordable is an Action which must be written to the event log in a
+// different form to itself, usually because it holds a connection or a secret.
+type recordable interface {
+	Action
+	record() interface{}
+}
+
+// handshake is the form in the event log of the actions which connect a
+// client. Session tokens are only recorded as digests.
+type handshake struct {
+	Addr  string `json:"addr,omitempty"`
+	Token string `json:"token,omitempty"`
+	Fin   bool   `json:"fin"`
+}
+
+func (c ConnectHost) record() interface{} {
+	return handshake{Fin: c.fin}
+}
+
+func (c ConnectPlayer) record() interface{} {
+	return handshake{Addr: c.Addr, Token: tokenDigest(c.tok), Fin: c.fin}
+}
+
+func (c ConnectSpectator) record() interface{} {
+	return handshake{Fin: c.fin}
+}
+
+func (c ConnectRemote) record() interface{} {
+	return handshake{Fin: c.fin}
+}
+
+func (p AddPlayer) record() interface{} {
+	return AddPlayer{Nick: p.Nick, Addr: p.Addr, Token: tokenDigest(p.Token)}
+}
+
+// tokenDigest returns the digest of a session token which is recorded in the
+// event log in place of the token itself.
+func tokenDigest(tok string) string {
+	if tok == "" {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(tok))
+	return hex.EncodeToString(sum[:16])
+}
+
+// replayActions decode each type of action from its form in the event log.
+// Handshakes which had not yet finished did nothing to the game, so decode to
+// a nil action.
+var replayActions = map[string]func(data []byte) (Action, error){
+	"ConnectHost": func(data []byte) (Action, error) {
+		var h handshake
+		if err := json.Unmarshal(data, &h); err != nil || !h.Fin {
+			return nil, err
+		}
+		return ConnectHost{fin: true}, nil
+	},
+	"ConnectPlayer": func(data []byte) (Action, error) {
+		var h handshake
+		if err := json.Unmarshal(data, &h); err != nil || !h.Fin {
+			return nil, err
+		}
+		return ConnectPlayer{Addr: h.Addr, tok: h.Token, fin: true}, nil
+	},
+	"ConnectSpectator": func(data []byte) (Action, error) {
+		var h handshake
+		if err := json.Unmarshal(data, &h); err != nil || !h.Fin {
+			return nil, err
+		}
+		return ConnectSpectator{fin: true}, nil
+	},
+	"ConnectRemote": func(data []byte) (Action, error) {
+		var h handshake
+		if err := json.Unmarshal(data, &h); err != nil || !h.Fin {
+			return nil, err
+		}
+		return ConnectRemote{fin: true}, nil
+	},
+	"AddPlayer": func(data []byte) (Action, error) {
+		p := AddPlayer{Result: make(chan AddResult, 1)}
+		err := json.Unmarshal(data, &p)
+		return p, err
+	},
+
+	"HostDisconnect":   decodeAction[HostDisconnect],
+	"hostTimeout":      decodeAction[hostTimeout],
+	"claimTimeout":     decodeAction[claimTimeout],
+	"LeavePlayer":      decodeAction[LeavePlayer],
+	"RerollNick":       decodeAction[RerollNick],
+	"ConnectionUpdate": decodeAction[ConnectionUpdate],
+	"ApprovePlayer":    decodeAction[ApprovePlayer],
+	"RejectPlayer":     decodeAction[RejectPlayer],
+	"StartGame":        decodeAction[StartGame],
+	"NextQuestion":     decodeAction[NextQuestion],
+	"JumpQuestion":     decodeAction[JumpQuestion],
+	"PauseGame":        decodeAction[PauseGame],
+	"ResumeGame":       decodeAction[ResumeGame],
+	"ExtendTime":       decodeAction[ExtendTime],
+	"StartAnswer":      decodeAction[StartAnswer],
+	"EndAnswer":        decodeAction[EndAnswer],
+	"Answer":           decodeAction[Answer],
+	"answerUpdate":     decodeAction[answerUpdate],
+	"SendResults":      decodeAction[SendResults],
+	"EndGame":          decodeAction[EndGame],
+	"Drain":            decodeAction[Drain],
+	"drainTimeout":     decodeAction[drainTimeout],
+	"KickPlayer":       decodeAction[KickPlayer],
+	"BanPlayer":        decodeAction[BanPlayer],
+	"RenamePlayer":     decodeAction[RenamePlayer],
+	"RemoteDisconnect": decodeAction[RemoteDisconnect],
+	"RelayCommand":     decodeAction[RelayCommand],
+	"PendingPlayers":   decodeAction[PendingPlayers],
+	"RemoveSpectator":  decodeAction[RemoveSpectator],
+}
+
+// decodeAction decodes an action which is recorded as itself.
+func decodeAction[T Action](data []byte) (Action, error) {
+	var act T
+	err := json.Unmarshal(data, &act)
+	return act, err
+}
+
+// openLog creates the event log for this game, if event logs are enabled.
+func (game *Game) openLog() {
+	if game.Events == nil {
+		return
+	}
+
+	l, err := game.Events.Create(game.PIN.String(), game.ID)
+	if err != nil {
+		log.Println(game.PIN, "could not create event log:", err)
+		return
+	}
+	game.events = l
+}
+
+// step advances the game runner by one iteration, performing act, and records
+// the step in the event log.
+func (game *Game) step(act Action) {
+	game.at, game.draws = time.Now().Round(0), nil
+	act.Perform(game)
+	game.sf = game.sf()
+	game.dirty = true
+
+	game.record(act)
+}
+
+// now returns the time at which the current step of the game runner began.
+// All timekeeping on the game runner uses now, such that a replay keeps the
+// same time as the original game.
+func (game *Game) now() time.Time {
+	if game.at.IsZero() {
+		return time.Now()
+	}
+	return game.at
+}
+
+// draw returns a value which the game takes from outside itself, such as a
+// random name, as generated by gen. Values drawn by an action are recorded
+// with it in the event log, and during a replay are drawn from the log instead
+// of calling gen.
+func (game *Game) draw(gen func() string) string {
+	if game.replaying {
+		if len(game.draws) == 0 {
+			panic("draw: no values left to draw")
+		}
+		v := game.draws[0]
+		game.draws = game.draws[1:]
+		return v
+	}
+
+	v := gen()
+	if game.events != nil {
+		game.draws = append(game.draws, v)
+	}
+	return v
+}
+
+// newToken returns a new secret session token. Only a digest of the token is
+// drawn, which takes the place of the token during a replay.
+func (game *Game) newToken() string {
+	if game.replaying {
+		return game.draw(nil)
+	}
+
+	tok := generateToken()
+	game.draw(func() string { return tokenDigest(tok) })
+	return tok
+}
+
+// generateNick returns a random nickname which is not yet in use.
+func (game *Game) generateNick() string {
+	return game.draw(func() string { return nick.Generate(game.nickTaken) })
+}
+
+// checkNick checks a nickname against the game's nickname policy, which
+// belongs to the server rather than the game, so is drawn. A rejected name is
+// drawn as the error message prefixed with a NUL, which can never appear in a
+// cleaned name.
+func (game *Game) checkNick(name string) (string, error) {
+	var err error
+	res := game.draw(func() string {
+		var clean string
+		if clean, err = game.Nicknames.Check(name); err != nil {
+			return "\x00" + err.Error()
+		}
+		return clean
+	})
+
+	if err != nil {
+		return "", err
+	} else if game.replaying && strings.HasPrefix(res, "\x00") {
+		return "", errors.New(res[1:])
+	}
+	return res, nil
+}
+
+// latency returns the estimated latency to plr, as measured by its runner.
+func (game *Game) latency(plr Player) time.Duration {
+	l, _ := strconv.ParseInt(game.draw(func() string {
+		return strconv.FormatInt(int64(plr.Latency()), 10)
+	}), 10, 64)
+	return time.Duration(l)
+}
+
+// offer queues msg for c without blocking, returning false if c is not keeping
+// up. This depends on c's connection, so is drawn.
+func (game *Game) offer(c Client, msg string) bool {
+	return game.draw(func() string {
+		return strconv.FormatBool(c.offer(msg))
+	}) == "true"
+}
+
+// start launches run, the runner for the newly connected client c. Clients
+// replayed from an event log have no connection, so no runner; the actions
+// which their runners produced are read from the log instead.
+func (game *Game) start(c Client, run func(ev chan Action)) {
+	if c.conn == nil {
+		return
+	}
+	go run(game.Action)
+}
+
+// checksum summarises the state of the game, such that a replay can check it
+// has reached the same state as the original game.
+func (game *Game) checksum() string {
+	h := fnv.New64a()
+	s := &game.state
+	fmt.Fprintf(h, "%d %d %t %t %t %t %d", s.Status, s.CurrentQuestion,
+		s.acceptingAnswers, s.questionDone, s.paused, s.Host != nil && s.Host.Connected, len(s.spectators))
+	for _, p := range s.Players {
+		fmt.Fprintf(h, "|%d %q %d %d %d %d %t %t %t %t %t", p.ID, p.Nick, p.Score, p.Correct, p.Streak,
+			p.answer, p.canAnswer, p.Connected, p.Pending, p.Removed, p.Banned)
+	}
+
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// record writes a step of the game runner to the event log, along with any
+// values drawn while performing it.
+func (game *Game) record(act Action) {
+	if game.events == nil {
+		return
+	}
+
+	var v interface{} = act
+	if r, ok := act.(recordable); ok {
+		v = r.record()
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Println(game.PIN, "could not record action:", err)
+		return
+	}
+
+	game.logEvent(events.Event{
+		Time:  game.at,
+		Kind:  events.KindAction,
+		Type:  reflect.TypeOf(act).Name(),
+		Data:  data,
+		Draws: game.draws,
+		Check: game.checksum(),
+	})
+}
+
+// recordStart writes the start event to the event log, which records how the
+// game was set up.
+func (game *Game) recordStart() {
+	if game.events == nil {
+		return
+	}
+
+	data, err := json.Marshal(setup{game.PIN, game.ID, game.Quiz, game.Settings, game.Options})
+	if err != nil {
+		log.Println(game.PIN, "could not record game setup:", err)
+		return
+	}
+	game.logEvent(events.Event{Kind: events.KindStart, Data: data})
+}
+
+// closeLog writes the end event to the event log and closes it.
+func (game *Game) closeLog() {
+	if game.events == nil {
+		return
+	}
+
+	game.logEvent(events.Event{Kind: events.KindEnd})
+	if err := game.events.Close(); err != nil {
+		log.Println(game.PIN, "event log:", err)
+	}
+}
+
+func (game *Game) logEvent(e events.Event) {
+	if err := game.events.Append(e); err != nil && err != events.ErrClosed {
+		log.Println(game.PIN, "event log:", err)
+	}
+}
+
+// recipient identifies a client in the game's event log, such that messages
+// sent to it are recorded. A nil recipient records nothing.
+type recipient struct {
+	log  *events.Log
+	name string
+}
+
+// recipient returns the recipient for the client called name, or nil if the
+// game keeps no event log.
+func (game *Game) recipient(name string) *recipient {
+	if game.events == nil {
+		return nil
+	}
+	return &recipient{game.events, name}
+}
+
+// sent records that msg was sent to the recipient.
+func (r *recipient) sent(msg string) {
+	if r == nil {
+		return
+	}
+
+	data, _ := json.Marshal(msg)
+	err := r.log.Append(events.Event{Kind: events.KindSend, To: r.name, Data: data})
+	if err != nil && err != events.ErrClosed {
+		log.Println("event log:", err)
+	}
+}
+
+// Replay recreates a game from its event log, rebuilding its state one step
+// at a time by performing the recorded actions again. Times, and any values
+// which the game took from outside itself, are taken from the log, so the
+// replay reaches exactly the state which the original game did.
+type Replay struct {
+	game   *Game
+	events []events.Event
+	next   int
+}
+
+// NewReplay prepares a replay of the game recorded in evs, which must begin
+// with the log's start event.
+func NewReplay(evs []events.Event) (*Replay, error) {
+	if len(evs) == 0 || evs[0].Kind != events.KindStart {
+		return nil, events.ErrNoStart
+	}
+
+	var s setup
+	if err := json.Unmarshal(evs[0].Data, &s); err != nil {
+		return nil, fmt.Errorf("game: replay: %w", err)
+	}
+
+	g := NewGame(s.PIN, s.Quiz, nil, s.Settings)
+	g.ID, g.Options = s.ID, s.Options
+	g.replaying = true
+	g.sf = g.WaitForHost
+
+	// Nothing is connected during a replay, so with the game cancelled
+	// messages are dropped at once and scheduled actions never fire. Both
+	// are already in the log.
+	g.cancel()
+
+	return &Replay{game: &g, events: evs, next: 1}, nil
+}
+
+// Game returns the game being replayed.
+func (r *Replay) Game() *Game {
+	return r.game
+}
+
+// State returns the current state of the replayed game.
+func (r *Replay) State() State {
+	return r.game.state
+}
+
+// Step applies the next event from the log to the game, and returns it. Sent
+// messages do not affect the game, so are returned without effect. Once every
+// event has been applied, Step returns io.EOF.
+func (r *Replay) Step() (ev events.Event, err error) {
+	if r.next >= len(r.events) {
+		return events.Event{}, io.EOF
+	}
+	ev = r.events[r.next]
+	r.next++
+
+	if ev.Kind != events.KindAction {
+		return ev, nil
+	}
+	if r.game.sf == nil {
+		return ev, fmt.Errorf("%w: event %d: game has already ended", ErrorReplay, ev.Seq)
+	}
+
+	dec, ok := replayActions[ev.Type]
+	if !ok {
+		return ev, fmt.Errorf("%w: event %d: unknown action %q", ErrorReplay, ev.Seq, ev.Type)
+	}
+	act, err := dec(ev.Data)
+	if err != nil {
+		return ev, fmt.Errorf("%w: event %d: %v", ErrorReplay, ev.Seq, err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("%w: event %d: %v", ErrorReplay, ev.Seq, p)
+		}
+	}()
+
+	game := r.game
+	game.at, game.draws = ev.Time, ev.Draws
+	if act != nil {
+		act.Perform(game)
+	}
+	game.sf = game.sf()
+
+	if len(game.draws) > 0 {
+		return ev, fmt.Errorf("%w: event %d: %d values left undrawn", ErrorReplay, ev.Seq, len(game.draws))
+	}
+	if ev.Check != "" && ev.Check != game.checksum() {
+		return ev, fmt.Errorf("%w: event %d: state differs", ErrorReplay, ev.Seq)
+	}
+	return ev, nil
+}
@@ -0,0 +1,8 @@
+// Package results implements the optional archive of finished game results,
+// such that hosts can review and download them after the game has ended.
+//
+// Results are stored as one JSON file per game, named after the game PIN and
+// a unique game ID. PINs are reused, so the ID is needed to tell apart games
+// which were given the same PIN. The ID is also secret, such that the results
+// of a game can only be found by those who were given the link.
+package results
@@ -0,0 +1,280 @@
+package results
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Archive errors.
+var (
+	ErrNotFound = errors.New("results: not found")
+	ErrInvalid  = errors.New("results: invalid PIN or game ID")
+)
+
+// Answer is one player's answer to one question.
+type Answer struct {
+	// Question is the zero-indexed question number.
+	Question int `json:"question"`
+	// Answer is the one-indexed answer chosen, or zero if the player did
+	// not answer in time.
+	Answer  int  `json:"answer"`
+	Correct bool `json:"correct"`
+	// Taken is the time taken to answer, in milliseconds.
+	Taken  int64 `json:"taken_ms"`
+	Points int64 `json:"points"`
+}
+
+// Player is one player's final standing in a game.
+type Player struct {
+	Rank    int      `json:"rank"`
+	Nick    string   `json:"name"`
+	Score   int64    `json:"score"`
+	Correct int      `json:"correct"`
+	Answers []Answer `json:"answers"`
+}
+
+// Result is the record of a finished game.
+type Result struct {
+	ID    string `json:"id"`
+	PIN   string `json:"pin"`
+	Title string `json:"title"`
+	// Owner is the account which created the game, if any.
+	Owner     string    `json:"owner,omitempty"`
+	Questions []string  `json:"questions"`
+	Started   time.Time `json:"started"`
+	Finished  time.Time `json:"finished"`
+	// Players are sorted by rank, first place first.
+	Players []Player `json:"players"`
+}
+
+// WriteCSV writes r to w as a spreadsheet, with one row for each player and
+// four columns for each question. Cells are escaped with escapeCell.
+func (r Result) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	write := func(row []string) error {
+		for i := range row {
+			row[i] = escapeCell(row[i])
+		}
+		return cw.Write(row)
+	}
+
+	head := []string{"Rank", "Name", "Score", "Correct"}
+	for i := range r.Questions {
+		q := "Q" + strconv.Itoa(i+1)
+		head = append(head, q+" answer", q+" correct", q+" time (s)", q+" points")
+	}
+	if err := write(head); err != nil {
+		return err
+	}
+
+	for _, p := range r.Players {
+		row := []string{
+			strconv.Itoa(p.Rank),
+			p.Nick,
+			strconv.FormatInt(p.Score, 10),
+			strconv.Itoa(p.Correct),
+		}
+		cols := make([]string, len(r.Questions)*4)
+		for _, a := range p.Answers {
+			if a.Question < 0 || a.Question >= len(r.Questions) {
+				continue
+			}
+
+			c := cols[a.Question*4:]
+			c[0], c[1] = "", strconv.FormatBool(a.Correct)
+			if a.Answer > 0 {
+				c[0] = strconv.Itoa(a.Answer)
+				c[2] = strconv.FormatFloat(float64(a.Taken)/1000, 'f', 2, 64)
+			}
+			c[3] = strconv.FormatInt(a.Points, 10)
+		}
+
+		if err := write(append(row, cols...)); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// escapeCell prefixes cell with a quote if it begins with a character which
+// would make a spreadsheet treat it as a formula, such that a player cannot
+// name themselves into running one on the host's computer.
+func escapeCell(cell string) string {
+	if cell != "" && strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+		return "'" + cell
+	}
+	return cell
+}
+
+// Store is an archive of game results in a directory on disk. Results older
+// than the retention period are deleted.
+type Store struct {
+	mut       *sync.Mutex
+	dir       string
+	retention time.Duration
+}
+
+// NewStore opens a results archive in dir, creating it if needed, and prunes
+// expired results. If retention is zero, results are kept forever.
+func NewStore(dir string, retention time.Duration) (Store, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return Store{}, fmt.Errorf("results: %w", err)
+	}
+
+	s := Store{
+		mut:       new(sync.Mutex),
+		dir:       dir,
+		retention: retention,
+	}
+	return s, s.Prune()
+}
+
+// path returns the file in which the results for pin and id are stored.
+func (s Store) path(pin, id string) (string, error) {
+	if !digits(pin, "0123456789") || !digits(id, "0123456789abcdef") {
+		return "", ErrInvalid
+	}
+
+	return filepath.Join(s.dir, pin+"-"+id+".json"), nil
+}
+
+// digits returns true if s is non-empty and made up only of runes in set.
+func digits(s, set string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !strings.ContainsRune(set, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// Save writes r to the archive, first removing any expired results.
+func (s Store) Save(r Result) error {
+	path, err := s.path(r.PIN, r.ID)
+	if err != nil {
+		return err
+	}
+	buf, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("results: %w", err)
+	}
+
+	if err := s.Prune(); err != nil {
+		return err
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if err := os.WriteFile(path, buf, 0o640); err != nil {
+		return fmt.Errorf("results: %w", err)
+	}
+	return nil
+}
+
+// Load reads the results for the game with the given PIN and ID.
+func (s Store) Load(pin, id string) (Result, error) {
+	path, err := s.path(pin, id)
+	if err != nil {
+		return Result{}, err
+	}
+
+	s.mut.Lock()
+	buf, err := os.ReadFile(path)
+	s.mut.Unlock()
+	if errors.Is(err, os.ErrNotExist) {
+		return Result{}, ErrNotFound
+	} else if err != nil {
+		return Result{}, fmt.Errorf("results: %w", err)
+	}
+
+	var r Result
+	if err := json.Unmarshal(buf, &r); err != nil {
+		return Result{}, fmt.Errorf("results: %w", err)
+	}
+	if s.expired(r.Finished) {
+		return Result{}, ErrNotFound
+	}
+	return r, nil
+}
+
+// Owned returns the results of every game created by the account owner, most
+// recently finished first. Files which cannot be read are skipped.
+func (s Store) Owned(owner string) ([]Result, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	ents, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("results: %w", err)
+	}
+
+	var ret []Result
+	for _, ent := range ents {
+		if ent.IsDir() || filepath.Ext(ent.Name()) != ".json" {
+			continue
+		}
+		buf, err := os.ReadFile(filepath.Join(s.dir, ent.Name()))
+		if err != nil {
+			continue
+		}
+
+		var r Result
+		if json.Unmarshal(buf, &r) != nil || r.Owner != owner || s.expired(r.Finished) {
+			continue
+		}
+		ret = append(ret, r)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Finished.After(ret[j].Finished)
+	})
+	return ret, nil
+}
+
+// Prune deletes every result which has outlived the retention period.
+func (s Store) Prune() error {
+	if s.retention == 0 {
+		return nil
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	ents, err := os.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("results: %w", err)
+	}
+	for _, ent := range ents {
+		if ent.IsDir() || filepath.Ext(ent.Name()) != ".json" {
+			continue
+		}
+		info, err := ent.Info()
+		if err != nil || !s.expired(info.ModTime()) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.dir, ent.Name())); err != nil {
+			return fmt.Errorf("results: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (s Store) expired(t time.Time) bool {
+	return s.retention != 0 && time.Since(t) > s.retention
+}
@@ -0,0 +1,171 @@
+package results
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testResult() Result {
+	return Result{
+		ID:        "0123456789abcdef",
+		PIN:       "1234567890",
+		Title:     "Test quiz",
+		Questions: []string{"First", "Second"},
+		Started:   time.Now().Add(-time.Minute),
+		Finished:  time.Now(),
+		Players: []Player{
+			{Rank: 1, Nick: "Alice", Score: 1500, Correct: 1, Answers: []Answer{
+				{Question: 0, Answer: 1, Correct: true, Taken: 2500, Points: 1500},
+				{Question: 1, Answer: 3, Taken: 1000},
+			}},
+			{Rank: 2, Nick: "Bob, Jr.", Answers: []Answer{
+				{Question: 0},
+			}},
+		},
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	s, err := NewStore(t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := testResult()
+	if err := s.Save(want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Load(want.PIN, want.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != want.Title || len(got.Players) != 2 || len(got.Players[0].Answers) != 2 {
+		t.Errorf("got %+v after round trip, expected %+v", got, want)
+	}
+
+	if _, err := s.Load(want.PIN, "fedcba9876543210"); err != ErrNotFound {
+		t.Errorf("loading unknown ID: got %v, expected %v", err, ErrNotFound)
+	}
+	for _, id := range []string{"", "../results", "0123456789ABCDEF"} {
+		if _, err := s.Load(want.PIN, id); err != ErrInvalid {
+			t.Errorf("loading ID %q: got %v, expected %v", id, err, ErrInvalid)
+		}
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewStore(dir, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := testResult()
+	old.Finished = time.Now().Add(-2 * time.Hour)
+	if err := s.Save(old); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Load(old.PIN, old.ID); err != ErrNotFound {
+		t.Errorf("loading expired result: got %v, expected %v", err, ErrNotFound)
+	}
+
+	path := filepath.Join(dir, old.PIN+"-"+old.ID+".json")
+	then := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(path, then, then); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Prune(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expired result not pruned: %v", err)
+	}
+}
+
+func TestOwned(t *testing.T) {
+	s, err := NewStore(t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, second, other, anon := testResult(), testResult(), testResult(), testResult()
+	first.Owner, second.Owner, other.Owner = "alice", "alice", "bob"
+	second.ID, other.ID, anon.ID = "1111111111111111", "2222222222222222", "3333333333333333"
+	second.Finished = first.Finished.Add(time.Minute)
+	for _, r := range []Result{first, second, other, anon} {
+		if err := s.Save(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := s.Owned("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].ID != second.ID || got[1].ID != first.ID {
+		t.Errorf("got %+v, expected alice's two results, newest first", got)
+	}
+	if got, _ := s.Owned("carol"); len(got) != 0 {
+		t.Errorf("got %+v, expected no results for carol", got)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	// Names which a spreadsheet would take as a formula are escaped
+	r := testResult()
+	r.Players = append(r.Players, Player{Rank: 3, Nick: "=HYPERLINK(\"http://example.com\")", Answers: []Answer{
+		{Question: 1},
+	}})
+
+	var buf bytes.Buffer
+	if err := r.WriteCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"Rank", "Name", "Score", "Correct", "Q1 answer", "Q1 correct", "Q1 time (s)", "Q1 points", "Q2 answer", "Q2 correct", "Q2 time (s)", "Q2 points"},
+		{"1", "Alice", "1500", "1", "1", "true", "2.50", "1500", "3", "false", "1.00", "0"},
+		{"2", "Bob, Jr.", "0", "0", "", "false", "", "0", "", "", "", ""},
+		{"3", "'=HYPERLINK(\"http://example.com\")", "0", "0", "", "", "", "", "", "false", "", "0"},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("got %d rows, expected %d", len(rows), len(want))
+	}
+	for i := range want {
+		for j := range want[i] {
+			if rows[i][j] != want[i][j] {
+				t.Errorf("row %d column %d: got %q, expected %q", i, j, rows[i][j], want[i][j])
+			}
+		}
+	}
+}
+
+func TestEscapeCell(t *testing.T) {
+	tests := []struct {
+		cell, want string
+	}{
+		{"", ""},
+		{"Alice", "Alice"},
+		{"a=1", "a=1"},
+		{"=1+1", "'=1+1"},
+		{"+1", "'+1"},
+		{"-1", "'-1"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\t=1", "'\t=1"},
+		{"\r=1", "'\r=1"},
+	}
+
+	for _, tt := range tests {
+		if got := escapeCell(tt.cell); got != tt.want {
+			t.Errorf("escapeCell(%q) = %q, expected %q", tt.cell, got, tt.want)
+		}
+	}
+}
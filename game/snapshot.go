@@ -0,0 +1,279 @@
+package game
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ejv2/gahoot/game/nick"
+	"github.com/ejv2/gahoot/game/snapshot"
+)
+
+// SnapshotInterval is the default time between snapshots of a running game.
+const SnapshotInterval = time.Second * 10
+
+// ErrorRestore is returned when a game cannot be restored from a snapshot.
+var ErrorRestore = fmt.Errorf("game: cannot restore game")
+
+// snapshot captures the current state of the game, such that it can be
+// restored by restoreGame. Must only be called by the game runner.
+func (game *Game) snapshot() snapshot.Snapshot {
+	st := &game.state
+	now := time.Now()
+	deadline, _ := game.ctx.Deadline()
+	opts, _ := json.Marshal(game.Options)
+
+	snap := snapshot.Snapshot{
+		PIN:             game.PIN.String(),
+		ID:              game.ID,
+		Created:         game.Created,
+		Taken:           now,
+		Remaining:       deadline.Sub(now),
+		Quiz:            game.Quiz,
+		QuizHash:        game.Quiz.String(),
+		Options:         opts,
+		HostToken:       game.HostToken,
+		WatchToken:      game.WatchToken,
+		RemoteToken:     game.RemoteToken,
+		HostAddr:        game.creator,
+		Owner:           game.Owner,
+		Status:          int(st.Status),
+		Started:         st.startedAt,
+		CurrentQuestion: st.CurrentQuestion,
+		CountdownDone:   st.countdownDone,
+		QuestionSkipped: st.questionSkipped,
+		QuestionDone:    st.questionDone,
+		ExtraTime:       st.extraTime,
+	}
+	if st.Host == nil {
+		snap.Status = GameHostWaiting
+	}
+	if len(st.modlog) > 0 {
+		snap.ModLog, _ = json.Marshal(st.modlog)
+	}
+	if st.countdownDone {
+		end := now
+		if st.paused {
+			end = st.pausedAt
+		}
+		snap.Elapsed = end.Sub(st.answersAt)
+	}
+	for addr := range st.bannedAddrs {
+		snap.BannedAddrs = append(snap.BannedAddrs, addr)
+	}
+	for tok := range st.bannedTokens {
+		snap.BannedTokens = append(snap.BannedTokens, tok)
+	}
+
+	snap.Players = make([]snapshot.Player, len(st.Players))
+	for i, plr := range st.Players {
+		p := snapshot.Player{
+			ID:        plr.ID,
+			Nick:      plr.Nick,
+			Score:     plr.Score,
+			Correct:   plr.Correct,
+			Streak:    plr.Streak,
+			Banned:    plr.Banned,
+			Removed:   plr.Removed,
+			Pending:   plr.Pending,
+			Token:     plr.token,
+			Addr:      plr.addr,
+			Claimed:   plr.claimed,
+			Rerolls:   plr.rerolls,
+			CanAnswer: plr.canAnswer,
+			Answer:    plr.answer,
+			Answers:   plr.answers,
+		}
+		if plr.answer > 0 {
+			p.Answered = plr.answeredAt.Sub(st.answersAt)
+		}
+		if plr.box != nil {
+			p.Seq, p.Unacked = plr.box.snapshot()
+		}
+		snap.Players[i] = p
+	}
+
+	return snap
+}
+
+// snapshot returns the sequence number of the last message pushed to the
+// outbox and every message still awaiting acknowledgement.
+func (o *outbox) snapshot() (uint64, []snapshot.Message) {
+	o.mut.Lock()
+	defer o.mut.Unlock()
+
+	msgs := make([]snapshot.Message, len(o.pending))
+	for i, n := range o.pending {
+		msgs[i] = snapshot.Message{Seq: n.seq, Critical: n.critical, Text: n.msg}
+	}
+	return o.seq, msgs
+}
+
+// snapshotter saves each snapshot received from ch to store, such that the
+// game runner is never held up by the disk. Once ch is closed at the end of
+// the game, the game's snapshot is removed.
+func snapshotter(store *snapshot.Store, pin Pin, ch <-chan snapshot.Snapshot) {
+	for snap := range ch {
+		if err := store.Save(snap); err != nil {
+			log.Println(pin, "could not save snapshot:", err)
+		}
+	}
+
+	if err := store.Remove(pin.String()); err != nil {
+		log.Println(pin, "could not remove snapshot:", err)
+	}
+}
+
+// saveSnapshot hands a new snapshot to the snapshotter, replacing any which
+// it has not yet got round to saving.
+func (game *Game) saveSnapshot() {
+	select {
+	case <-game.snapshots:
+	default:
+	}
+	game.snapshots <- game.snapshot()
+	game.dirty = false
+}
+
+// restoreGame rebuilds a game from a snapshot. The restored game is held as
+// though its host had just disconnected, giving the host the usual grace
+// period to reconnect, and is paused if it was running. Nobody is connected,
+// but every client may reconnect using its original token.
+//
+// Restored games are not logged, as their event log cannot be replayed from
+// the point of the snapshot.
+func restoreGame(snap snapshot.Snapshot, reaper chan Pin, settings Settings) (Game, error) {
+	pin, err := ParsePin(snap.PIN)
+	if err != nil {
+		return Game{}, fmt.Errorf("%w: %s", ErrorRestore, err)
+	}
+	if snap.Remaining <= 0 {
+		return Game{}, fmt.Errorf("%w: game has run out of time", ErrorRestore)
+	}
+	if snap.CurrentQuestion < 0 || snap.CurrentQuestion >= len(snap.Quiz.Questions) {
+		return Game{}, fmt.Errorf("%w: invalid question %d", ErrorRestore, snap.CurrentQuestion)
+	}
+
+	settings.Events = nil
+	game := NewGame(pin, snap.Quiz, reaper, settings)
+	game.cancel()
+	game.ctx, game.cancel = context.WithTimeout(context.Background(), snap.Remaining)
+
+	if err := json.Unmarshal(snap.Options, &game.Options); err != nil {
+		return Game{}, fmt.Errorf("%w: options: %s", ErrorRestore, err)
+	}
+	game.ID = snap.ID
+	game.Created = snap.Created
+	game.HostToken = snap.HostToken
+	game.WatchToken = snap.WatchToken
+	game.RemoteToken = snap.RemoteToken
+	game.creator = snap.HostAddr
+	game.Owner = snap.Owner
+
+	now := time.Now()
+	st := &game.state
+	if len(snap.ModLog) > 0 {
+		if err := json.Unmarshal(snap.ModLog, &st.modlog); err != nil {
+			return Game{}, fmt.Errorf("%w: moderation log: %s", ErrorRestore, err)
+		}
+	}
+	st.Status = Status(snap.Status)
+	st.startedAt = snap.Started
+	st.CurrentQuestion = snap.CurrentQuestion
+	st.countdownDone = snap.CountdownDone
+	st.acceptingAnswers = snap.CountdownDone && !snap.QuestionDone
+	st.questionSkipped = snap.QuestionSkipped
+	st.questionDone = snap.QuestionDone
+	st.extraTime = snap.ExtraTime
+	st.answersAt = now.Add(-snap.Elapsed)
+
+	st.namecache = make(map[string]struct{})
+	st.tokens = make(map[string]int)
+	st.bannedAddrs = make(map[string]struct{})
+	st.bannedTokens = make(map[string]struct{})
+	for _, addr := range snap.BannedAddrs {
+		st.bannedAddrs[addr] = struct{}{}
+	}
+	for _, tok := range snap.BannedTokens {
+		st.bannedTokens[tok] = struct{}{}
+	}
+
+	for i, p := range snap.Players {
+		if p.ID != i+1 {
+			return Game{}, fmt.Errorf("%w: player %d out of order", ErrorRestore, p.ID)
+		}
+
+		box := &outbox{seq: p.Seq}
+		for _, m := range p.Unacked {
+			box.pending = append(box.pending, numbered{m.Seq, m.Critical, m.Text})
+		}
+		plr := Player{
+			Client: Client{
+				Connected: false,
+				send:      make(chan string),
+				box:       box,
+			},
+			ID:        p.ID,
+			Nick:      p.Nick,
+			Score:     p.Score,
+			Correct:   p.Correct,
+			Streak:    p.Streak,
+			Banned:    p.Banned,
+			Removed:   p.Removed,
+			Pending:   p.Pending,
+			token:     p.Token,
+			addr:      p.Addr,
+			claimed:   p.Claimed,
+			rerolls:   p.Rerolls,
+			canAnswer: p.CanAnswer,
+			answer:    p.Answer,
+			answers:   p.Answers,
+		}
+		if p.Answer > 0 {
+			plr.answeredAt = st.answersAt.Add(p.Answered)
+		}
+		st.Players = append(st.Players, plr)
+
+		if p.Removed {
+			continue
+		}
+		st.namecache[nick.Skeleton(p.Nick)] = struct{}{}
+		st.tokens[p.Token] = p.ID
+		if !p.Claimed {
+			game.schedule(game.ClaimTimeout, claimTimeout{p.ID})
+		}
+	}
+
+	// A host which never connected is still waiting on its first
+	// connection, so there is nothing to hold the game for
+	if st.Status == GameHostWaiting {
+		return game, nil
+	}
+
+	st.Host = &Host{}
+	st.hostLostAt = now
+	game.schedule(game.HostGracePeriod, hostTimeout{now})
+	if st.Status == GameRunning {
+		st.paused = true
+		st.pausedAt = now
+	}
+	return game, nil
+}
+
+// entry returns the state in which the game runner begins. A new game waits
+// for its host, whereas a restored game carries on from the point at which
+// it was snapshotted.
+func (game *Game) entry() StateFunc {
+	switch {
+	case game.state.Status != GameRunning:
+		return game.WaitForHost
+	case game.state.questionDone:
+		return game.Sustain
+	case game.state.countdownDone:
+		return game.AcceptAnswers
+	default:
+		return game.QuestionCountdown
+	}
+}
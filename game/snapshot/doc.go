@@ -0,0 +1,9 @@
+// Package snapshot implements the optional store of running game snapshots,
+// such that games survive a restart or crash of the server.
+//
+// Each running game is periodically saved as one JSON file, named after the
+// game PIN, which is replaced each time a newer snapshot is taken and removed
+// once the game ends. Snapshots hold the secret tokens of the host and every
+// player, so that they may reconnect to the restored game, and so must be
+// kept private.
+package snapshot
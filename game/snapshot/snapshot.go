@@ -0,0 +1,217 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ejv2/gahoot/game/quiz"
+	"github.com/ejv2/gahoot/game/results"
+)
+
+// Snapshot store errors.
+var (
+	ErrNotFound = errors.New("snapshot: not found")
+	ErrInvalid  = errors.New("snapshot: invalid PIN")
+	ErrQuiz     = errors.New("snapshot: quiz does not match its hash")
+)
+
+// Message is a numbered message which a client has not yet acknowledged.
+type Message struct {
+	Seq      uint64 `json:"seq"`
+	Critical bool   `json:"critical,omitempty"`
+	Text     string `json:"text"`
+}
+
+// Player is the state of one player in a game.
+type Player struct {
+	ID      int    `json:"id"`
+	Nick    string `json:"name"`
+	Score   int64  `json:"score"`
+	Correct int    `json:"correct"`
+	Streak  int    `json:"streak"`
+	Banned  bool   `json:"banned,omitempty"`
+	Removed bool   `json:"removed,omitempty"`
+	Pending bool   `json:"pending,omitempty"`
+
+	Token   string `json:"token"`
+	Addr    string `json:"addr"`
+	Claimed bool   `json:"claimed,omitempty"`
+	Rerolls int    `json:"rerolls,omitempty"`
+
+	// CanAnswer is true if the player may answer the current question.
+	// Answer is the answer they gave, if any, and Answered the time after
+	// answers opened at which they gave it.
+	CanAnswer bool             `json:"can_answer,omitempty"`
+	Answer    int              `json:"answer,omitempty"`
+	Answered  time.Duration    `json:"answered,omitempty"`
+	Answers   []results.Answer `json:"answers,omitempty"`
+
+	// Seq is the number of the last message sent to the player, and
+	// Unacked those still awaiting acknowledgement.
+	Seq     uint64    `json:"seq"`
+	Unacked []Message `json:"unacked,omitempty"`
+}
+
+// Snapshot is the state of a running game at one point in time.
+type Snapshot struct {
+	PIN     string    `json:"pin"`
+	ID      string    `json:"id"`
+	Created time.Time `json:"created"`
+	Taken   time.Time `json:"taken"`
+	// Remaining is the time left before the game reaches its maximum
+	// running time.
+	Remaining time.Duration `json:"remaining"`
+
+	Quiz     quiz.Quiz `json:"quiz"`
+	QuizHash string    `json:"quiz_hash"`
+	// Options are the options chosen by the host, as encoded by the game.
+	Options json.RawMessage `json:"options"`
+
+	HostToken   string `json:"host_token"`
+	WatchToken  string `json:"watch_token"`
+	RemoteToken string `json:"remote_token"`
+	// HostAddr is the address from which the game was created.
+	HostAddr string `json:"host_addr"`
+	// Owner is the account which created the game, if any.
+	Owner string `json:"owner,omitempty"`
+
+	Status          int       `json:"status"`
+	Started         time.Time `json:"started,omitempty"`
+	CurrentQuestion int       `json:"question"`
+	CountdownDone   bool      `json:"countdown_done,omitempty"`
+	QuestionSkipped bool      `json:"question_skipped,omitempty"`
+	QuestionDone    bool      `json:"question_done,omitempty"`
+	// Elapsed is the answer time which had passed for the current
+	// question, not counting time spent paused, and ExtraTime the extra
+	// answer time granted by the host.
+	Elapsed   time.Duration `json:"elapsed,omitempty"`
+	ExtraTime time.Duration `json:"extra_time,omitempty"`
+
+	Players      []Player `json:"players"`
+	BannedAddrs  []string `json:"banned_addrs,omitempty"`
+	BannedTokens []string `json:"banned_tokens,omitempty"`
+	// ModLog is the host's moderation log, as encoded by the game.
+	ModLog json.RawMessage `json:"modlog,omitempty"`
+}
+
+// Store is a directory of game snapshots on disk.
+type Store struct {
+	dir string
+}
+
+// NewStore opens a snapshot store in dir, creating it if needed.
+func NewStore(dir string) (Store, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return Store{}, fmt.Errorf("snapshot: %w", err)
+	}
+
+	return Store{dir}, nil
+}
+
+// path returns the file in which the snapshot for pin is stored.
+func (s Store) path(pin string) (string, error) {
+	if pin == "" || strings.Trim(pin, "0123456789") != "" {
+		return "", ErrInvalid
+	}
+
+	return filepath.Join(s.dir, pin+".json"), nil
+}
+
+// Save writes snap to the store, replacing any earlier snapshot of the same
+// game. The previous snapshot is kept intact until the new one has been fully
+// written, such that a crash mid-save never loses the game.
+func (s Store) Save(snap Snapshot) error {
+	path, err := s.path(snap.PIN)
+	if err != nil {
+		return err
+	}
+	buf, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("snapshot: %w", err)
+	}
+
+	f, err := os.CreateTemp(s.dir, ".tmp-"+snap.PIN+"-*")
+	if err != nil {
+		return fmt.Errorf("snapshot: %w", err)
+	}
+	tmp := f.Name()
+	if _, err = f.Write(buf); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("snapshot: %w", err)
+	}
+	return nil
+}
+
+// Load reads the snapshot for the game with the given PIN, checking that its
+// quiz is intact.
+func (s Store) Load(pin string) (Snapshot, error) {
+	path, err := s.path(pin)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	buf, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Snapshot{}, ErrNotFound
+	} else if err != nil {
+		return Snapshot{}, fmt.Errorf("snapshot: %w", err)
+	}
+
+	var snap Snapshot
+	if err := json.Unmarshal(buf, &snap); err != nil {
+		return Snapshot{}, fmt.Errorf("snapshot: %s: %w", pin, err)
+	}
+	if snap.PIN != pin {
+		return Snapshot{}, fmt.Errorf("snapshot: %s: holds game %s", pin, snap.PIN)
+	}
+	if snap.Quiz.String() != snap.QuizHash {
+		return Snapshot{}, ErrQuiz
+	}
+	return snap, nil
+}
+
+// List returns the PIN of every game with a snapshot in the store.
+func (s Store) List() ([]string, error) {
+	ents, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot: %w", err)
+	}
+
+	var pins []string
+	for _, e := range ents {
+		pin := strings.TrimSuffix(e.Name(), ".json")
+		if e.Type().IsRegular() && pin != e.Name() {
+			if _, err := s.path(pin); err == nil {
+				pins = append(pins, pin)
+			}
+		}
+	}
+	return pins, nil
+}
+
+// Remove deletes the snapshot for the game with the given PIN, if any.
+func (s Store) Remove(pin string) error {
+	path, err := s.path(pin)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("snapshot: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package snapshot
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ejv2/gahoot/game/quiz"
+)
+
+func testSnapshot() Snapshot {
+	q := quiz.Quiz{
+		Title:   "Test quiz",
+		Created: time.Now(),
+		Questions: []quiz.Question{
+			{Title: "First", Duration: 10, Answers: []quiz.Answer{{Title: "Yes", Correct: true}, {Title: "No"}}},
+		},
+	}
+	return Snapshot{
+		PIN:      "1234567890",
+		ID:       "0123456789abcdef",
+		Taken:    time.Now(),
+		Quiz:     q,
+		QuizHash: q.String(),
+		Players: []Player{
+			{ID: 1, Nick: "Alice", Score: 1500, Token: "abc", Seq: 2, Unacked: []Message{{2, true, "ques#2 {}"}}},
+		},
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := testSnapshot()
+	for i := 0; i < 2; i++ {
+		want.Players[0].Score += 100
+		if err := s.Save(want); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := s.Load(want.PIN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Quiz.Title != want.Quiz.Title || len(got.Players) != 1 || got.Players[0].Score != 1700 ||
+		len(got.Players[0].Unacked) != 1 {
+		t.Errorf("got %+v after round trip, expected %+v", got, want)
+	}
+
+	pins, err := s.List()
+	if err != nil || len(pins) != 1 || pins[0] != want.PIN {
+		t.Errorf("listing store: got %v (err %v), expected [%s]", pins, err, want.PIN)
+	}
+	if ents, _ := os.ReadDir(dir); len(ents) != 1 {
+		t.Errorf("store holds %d files, expected 1", len(ents))
+	}
+
+	if err := s.Remove(want.PIN); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Load(want.PIN); err != ErrNotFound {
+		t.Errorf("loading removed snapshot: got %v, expected %v", err, ErrNotFound)
+	}
+	for _, pin := range []string{"", "../1234", "12a4"} {
+		if _, err := s.Load(pin); err != ErrInvalid {
+			t.Errorf("loading PIN %q: got %v, expected %v", pin, err, ErrInvalid)
+		}
+	}
+}
+
+func TestQuizHash(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snap := testSnapshot()
+	snap.QuizHash = "0000"
+	if err := s.Save(snap); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Load(snap.PIN); err != ErrQuiz {
+		t.Errorf("loading altered quiz: got %v, expected %v", err, ErrQuiz)
+	}
+
+	os.WriteFile(filepath.Join(dir, "1111.json"), []byte("{"), 0o600)
+	if _, err := s.Load("1111"); err == nil {
+		t.Error("loaded truncated snapshot without error")
+	}
+}
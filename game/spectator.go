@@ -0,0 +1,207 @@
+package game
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Spectator mechanism constants.
+const (
+	// MaxSpectators is the maximum number of spectators which may watch a
+	// single game.
+	MaxSpectators = 32
+	// SpectatorBacklog is the number of messages which may be queued for a
+	// spectator before it is considered too slow and disconnected.
+	SpectatorBacklog = 64
+)
+
+// A Spectator is a read-only client which receives a copy of every message
+// sent to the host, for use as a secondary display such as a projector or a
+// stream overlay. Spectators can never control the game.
+//
+// Messages are queued for spectators without blocking, such that any number of
+// slow spectators cannot hold up the game runner. A spectator which falls too
+// far behind is disconnected.
+type Spectator struct {
+	Client
+	ID int
+}
+
+// Run is the spectator runner thread. It continually receives from the
+// spectator's websocket connection until the connection is lost, answering
+// clock synchronisation requests and ignoring everything else.
+func (s Spectator) Run(ev chan Action) {
+	s.Open()
+	defer func() {
+		select {
+		case ev <- RemoveSpectator{s.ID}:
+		case <-s.Ctx.Done():
+		}
+
+		s.Cancel()
+		log.Printf("%s (spectator) disconnected", s.conn.RemoteAddr())
+	}()
+
+readloop:
+	for {
+		cmd, data, err := s.ReadString()
+		if err != nil {
+			log.Println("spectator:", err)
+			s.CloseReason(err.Error())
+			return
+		}
+
+		switch cmd {
+		case MessageClockSync:
+			if err := s.SyncClock(data); err != nil {
+				log.Println("spectator: invalid clock sync:", data)
+			}
+		default:
+			log.Println("spectator: ignoring command", cmd)
+		}
+
+		select {
+		case <-s.Ctx.Done():
+			break readloop
+		default:
+		}
+	}
+}
+
+// ConnectSpectator initializes a spectator's connection, performing the
+// startup handshake asynchronously. When this is complete, submits a new
+// action to the game runner to install the spectator, which is immediately
+// synchronised with the current game state.
+//
+// The handshake must contain the game's watch token.
+type ConnectSpectator struct {
+	Conn *websocket.Conn
+	cl   Client
+	tok  string
+	fin  bool
+}
+
+func (c ConnectSpectator) handleConnection(game Game) {
+	// Enforce 30s handshake deadline to stop deadlocking of the game thread
+	c.Conn.SetReadDeadline(time.Now().Add(time.Second * 30))
+	defer c.Conn.SetReadDeadline(*new(time.Time))
+
+	// Temporary client object. See ConnectPlayer for details.
+	c.cl = Client{
+		Connected: true,
+		conn:      c.Conn,
+		send:      nil,
+		Ctx:       context.Background(),
+	}
+	verb, err := c.cl.ReadMessage(&c.tok)
+	switch {
+	case err != nil:
+		c.cl.CloseReason(err.Error())
+		return
+	case verb != MessageWatch:
+		c.cl.CloseReason("expected first message to be WATCH")
+		return
+	case subtle.ConstantTimeCompare([]byte(c.tok), []byte(game.WatchToken)) != 1:
+		c.cl.CloseReason("invalid watch token")
+		return
+	}
+
+	c.fin = true
+	select {
+	case game.Action <- c:
+	case <-game.ctx.Done():
+		c.cl.Close()
+	}
+}
+
+func (c ConnectSpectator) handleInsertion(game *Game) {
+	if game.state.spectators == nil {
+		game.state.spectators = make(map[int]Spectator)
+	}
+	if len(game.state.spectators) >= MaxSpectators {
+		c.cl.CloseReason("too many spectators")
+		return
+	}
+
+	deadline, ok := game.ctx.Deadline()
+	if !ok {
+		panic("connectspectator: found game with no deadline")
+	}
+
+	game.state.nextSpectator++
+	ctx, cancel := context.WithDeadline(game.ctx, deadline)
+	s := Spectator{
+		Client: Client{
+			Connected: true,
+			conn:      c.Conn,
+			Ctx:       ctx,
+			Cancel:    cancel,
+			send:      make(chan string, SpectatorBacklog),
+			rec:       game.recipient("spectator " + strconv.Itoa(game.state.nextSpectator)),
+		},
+		ID: game.state.nextSpectator,
+	}
+	game.state.spectators[s.ID] = s
+	game.start(s.Client, s.Run)
+
+	log.Println("Spectator", s.ID, "joined", game.PIN.String())
+	s.offer(FormatMessage(CommandResync, game.hostResync()))
+}
+
+func (c ConnectSpectator) Perform(game *Game) {
+	if !c.fin {
+		go c.handleConnection(*game)
+		return
+	}
+
+	c.handleInsertion(game)
+}
+
+// RemoveSpectator removes a spectator whose connection has been lost.
+type RemoveSpectator struct {
+	ID int
+}
+
+func (r RemoveSpectator) Perform(game *Game) {
+	delete(game.state.spectators, r.ID)
+}
+
+// spectate queues a copy of a host message for every spectator and the
+// remote, if any. Those which are not keeping up are disconnected.
+func (game *Game) spectate(verb string, body interface{}) {
+	if len(game.state.spectators) == 0 && game.state.remote == nil {
+		return
+	}
+
+	msg := FormatMessage(verb, body)
+	if game.state.remote != nil && !game.offer(game.state.remote.Client, msg) {
+		game.dropRemote()
+	}
+
+	// Spectators are visited in order so that replays drop the same ones
+	ids := make([]int, 0, len(game.state.spectators))
+	for id := range game.state.spectators {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		s := game.state.spectators[id]
+		if !game.offer(s.Client, msg) {
+			log.Println("spectator", id, "fell behind in", game.PIN.String(), "- disconnecting")
+			s.Cancel()
+			delete(game.state.spectators, id)
+		}
+	}
+}
+
+// sendHost sends a message to the host, and a copy to every spectator.
+func (game *Game) sendHost(verb string, body interface{}) {
+	game.spectate(verb, body)
+	game.state.Host.SendMessage(verb, body)
+}
@@ -7,14 +7,22 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator"
 
+	"github.com/ejv2/gahoot/accounts"
 	"github.com/ejv2/gahoot/config"
 	"github.com/ejv2/gahoot/game"
+	"github.com/ejv2/gahoot/game/events"
+	"github.com/ejv2/gahoot/game/nick"
 	"github.com/ejv2/gahoot/game/quiz"
+	"github.com/ejv2/gahoot/game/results"
+	"github.com/ejv2/gahoot/game/snapshot"
+	"github.com/ejv2/gahoot/oidc"
 )
 
 // Core application paths.
@@ -23,13 +31,34 @@ const (
 	PathTemplates = PathFrontend + string(os.PathSeparator) + "templates"
 	PathStatic    = PathFrontend + string(os.PathSeparator) + "static"
 	PathConfig    = "config.gahoot"
+	PathOpenAPI   = PathFrontend + string(os.PathSeparator) + "openapi.json"
 )
 
+// Cookie names.
+const (
+	// HostCookie stores the secret host token for a game, scoped to that
+	// game's host page.
+	HostCookie = "host_token"
+	// PlayerCookie is the prefix of the cookie which stores the secret
+	// session token for a player. Each game has its own cookie, which is
+	// visible to the join page so that banned players can be recognised.
+	PlayerCookie = "player_token_"
+	// SessionCookie stores the login session token of a host account.
+	SessionCookie = "session"
+	// OIDCStateCookie stores the state of a login through the identity
+	// provider while the host is away logging in.
+	OIDCStateCookie = "oidc_state"
+)
+
+// QRScale is the width in pixels of each module of QR code PNG images.
+const QRScale = 8
+
 // Application lifetime state.
 var (
 	Config      config.Config
 	Coordinator game.Coordinator
 	QuizManager quiz.Manager
+	Archive     *results.Store
 
 	vd *validator.Validate
 )
@@ -77,8 +106,110 @@ func main() {
 		}
 	}
 
+	// Init nickname policy
+	var blocklist []string
+	if Config.NickBlocklist != "" {
+		blocklist, err = nick.LoadBlocklist(Config.NickBlocklist)
+		if err != nil {
+			log.Fatal("error loading nickname blocklist:", err)
+		}
+	}
+
+	// Init results archive
+	if Config.ResultsDir != "" {
+		store, err := results.NewStore(Config.ResultsDir, Config.ResultsRetention)
+		if err != nil {
+			log.Fatal("error opening results archive:", err)
+		}
+		Archive = &store
+	}
+
+	// Init event logs
+	var evlogs *events.Store
+	if Config.EventLogDir != "" {
+		store, err := events.NewStore(Config.EventLogDir)
+		if err != nil {
+			log.Fatal("error opening event log directory:", err)
+		}
+		evlogs = &store
+	}
+
+	// Init host accounts
+	if Config.AccountsFile != "" {
+		store, err := accounts.Load(Config.AccountsFile)
+		if err != nil {
+			log.Fatal("error loading host accounts:", err)
+		}
+		Accounts = &store
+	}
+
+	// Init identity provider
+	if Config.OIDCIssuer != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		OIDC, err = oidc.Discover(ctx, oidc.Config{
+			Issuer:       Config.OIDCIssuer,
+			ClientID:     Config.OIDCClientID,
+			ClientSecret: Config.OIDCClientSecret,
+			RedirectURL:  strings.TrimSuffix(Config.SiteLink, "/") + "/login/oidc/callback",
+			Domains:      Config.OIDCDomains,
+			Groups:       Config.OIDCGroups,
+			GroupClaim:   Config.OIDCGroupClaim,
+		})
+		cancel()
+		if err != nil {
+			log.Fatal("error contacting identity provider:", err)
+		}
+	}
+	if loginEnabled() {
+		Sessions = accounts.NewSessions(accounts.SessionTime)
+	}
+
+	// Init admin audit log
+	adminEnabled := Config.AdminPassword != "" || len(Config.OIDCAdmins) > 0
+	if adminEnabled {
+		Audit, err = OpenAuditLog(Config.AdminAuditLog)
+		if err != nil {
+			log.Fatal("error opening admin audit log:", err)
+		}
+	}
+
+	// Init JSON API
+	if len(Config.APIKeys) > 0 {
+		OpenAPI, err = loadOpenAPI(PathOpenAPI)
+		if err != nil {
+			log.Fatal("error loading API description:", err)
+		}
+	}
+
+	// Init game snapshots
+	var snapshots *snapshot.Store
+	if Config.SnapshotDir != "" {
+		store, err := snapshot.NewStore(Config.SnapshotDir)
+		if err != nil {
+			log.Fatal("error opening snapshot directory:", err)
+		}
+		snapshots = &store
+	}
+
 	// Init game coordinator
-	Coordinator = game.NewCoordinator(Config.GameTimeout)
+	Coordinator = game.NewCoordinator(game.Settings{
+		MaxGameTime:         Config.GameTimeout,
+		HostGracePeriod:     Config.HostGrace,
+		ClaimTimeout:        Config.ClaimTimeout,
+		PinCooldown:         Config.PinCooldown,
+		PinLength:           Config.PinLength,
+		LatencyCompensation: Config.LatencyCompensation,
+		Nicknames:           nick.NewPolicy(Config.NickMinLength, Config.NickMaxLength, blocklist),
+		MaxGames:            Config.MaxGames,
+		MaxHostGames:        Config.MaxHostGames,
+		MaxPlayers:          Config.MaxPlayers,
+		MaxPending:          Config.MaxPending,
+		Archive:             Archive,
+		Events:              evlogs,
+		Snapshots:           snapshots,
+		SnapshotInterval:    Config.SnapshotInterval,
+		DrainTimeout:        Config.DrainTimeout,
+	})
 
 	// Banner
 	log.Printf("Gahoot! v%d.%d.%d server starting...", MajorVersion, MinorVersion, PatchVersion)
@@ -86,6 +217,15 @@ func main() {
 	if len(qs) > 0 {
 		log.Printf("Loaded %d quizzes from disk", len(qs))
 	}
+	if Accounts != nil {
+		log.Printf("Loaded %d host accounts", Accounts.Len())
+	}
+	if OIDC != nil {
+		log.Printf("Hosts may log in through %s", Config.OIDCIssuer)
+	}
+	if len(Config.APIKeys) > 0 {
+		log.Printf("JSON API enabled for %d clients", len(Config.APIKeys))
+	}
 
 	// Startup and listen
 	router := gin.New()
@@ -115,7 +255,23 @@ func main() {
 	router.GET("/", handleRoot)
 	router.GET("/join", handleJoin)
 
-	create := router.Group("/create/")
+	if loginEnabled() {
+		router.GET("/login", sessionAuth(false), handleLogin)
+		router.POST("/login", handleLoginPost)
+		router.POST("/logout", handleLogout)
+		if OIDC != nil {
+			router.GET("/login/oidc", handleOIDCLogin)
+			router.GET("/login/oidc/callback", handleOIDCCallback)
+		}
+
+		account := router.Group("/account/", sessionAuth(true))
+		{
+			account.GET("/", handleAccount)
+			account.GET("/host/:pin", handleAccountHost)
+		}
+	}
+
+	create := router.Group("/create/", sessionAuth(Config.LoginCreate))
 	{
 		create.GET("/", handleCreate)
 		create.GET("/find", handleFind)
@@ -129,27 +285,75 @@ func main() {
 	play := router.Group("/play/")
 	{
 		play.GET("/game/:pin", handleGame)
-		play.GET("/host/:pin", handleHost)
+		play.GET("/host/:pin", sessionAuth(Config.LoginHost), handleHost)
+		play.GET("/watch/:pin", handleWatch)
+		play.GET("/remote/:pin", handleRemote)
+		play.GET("/qr/:file", handleQR)
+	}
+
+	router.GET("/results/:pin/:file", handleResults)
+
+	if adminEnabled {
+		admin := router.Group("/admin/", adminAuth(Config.AdminUser, Config.AdminPassword))
+		{
+			admin.GET("/", handleAdmin)
+			admin.GET("/game/:pin", handleAdminGame)
+			admin.POST("/game/:pin/end", handleAdminEndGame)
+			admin.POST("/quiz/:hash/hide", handleAdminHideQuiz)
+			admin.POST("/quiz/:hash/unload", handleAdminUnloadQuiz)
+			admin.POST("/quiz/reload", handleAdminReload)
+
+			admin.GET("/api/games", handleAdminGamesAPI)
+			admin.GET("/api/games/:pin", handleAdminGameAPI)
+			admin.GET("/api/quizzes", handleAdminQuizzesAPI)
+		}
 	}
 
 	api := router.Group("/api/")
 	{
 		api.GET("/play/:pin", handlePlayAPI)
-		api.GET("/host/:pin", handleHostAPI)
+		api.GET("/host/:pin", sessionAuth(Config.LoginHost), handleHostAPI)
+		api.GET("/watch/:pin", handleWatchAPI)
+		api.GET("/remote/:pin", handleRemoteAPI)
+	}
+
+	if len(Config.APIKeys) > 0 {
+		router.GET("/api/v1/openapi.json", handleOpenAPI)
+
+		v1 := router.Group("/api/v1/", apiAuth(Config.APIKeys))
+		{
+			v1.POST("/games", handleAPICreateGame)
+			v1.GET("/games", handleAPIGames)
+			v1.GET("/games/:pin", handleAPIGame)
+			v1.GET("/games/:pin/players", handleAPIPlayers)
+			v1.GET("/results/:pin/:id", handleAPIResults)
+			v1.GET("/quizzes", handleAPIQuizzes)
+		}
 	}
 
 	errchan := make(chan error, 1)
 	sigchan := make(chan os.Signal, 1)
 
-	signal.Notify(sigchan, os.Interrupt)
+	signal.Notify(sigchan, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		err := srv.ListenAndServe()
 		errchan <- err
 	}()
 
 	select {
-	case <-sigchan:
-		log.Println("Caught interrupt signal. Terminating gracefully...")
+	case sig := <-sigchan:
+		log.Println("Caught", sig, "signal. Letting games finish before terminating (signal again to skip)...")
+		drainctx, stop := context.WithCancel(context.Background())
+		go func() {
+			<-sigchan
+			stop()
+		}()
+		if err := Coordinator.Drain(drainctx); err != nil {
+			log.Println("Not all games finished:", err)
+		}
+		stop()
+
+		log.Println("Terminating gracefully...")
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 		err := srv.Shutdown(ctx)
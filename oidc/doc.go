@@ -0,0 +1,9 @@
+// Package oidc implements login through an OpenID Connect identity provider,
+// using the authorization code flow with PKCE.
+//
+// The provider is found through its discovery document, and the ID token
+// returned for each login is checked against the provider's published signing
+// keys before it is trusted. Only RS256 and ES256 signatures are accepted.
+// Logins may be limited to users with an email address in certain domains, or
+// to members of certain groups, as asserted by the provider.
+package oidc
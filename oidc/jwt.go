@@ -0,0 +1,314 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Token checks.
+const (
+	// ClockSkew is the difference allowed between the clocks of the server
+	// and the provider when checking token times.
+	ClockSkew = time.Minute
+	// keyRefresh is the minimum time between fetches of the signing keys,
+	// such that tokens with unknown key IDs cannot be used to flood the
+	// provider with requests.
+	keyRefresh = time.Minute
+)
+
+// jwk is a JSON web key, as published by the provider.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA keys
+	N string `json:"n"`
+	E string `json:"e"`
+	// Elliptic curve keys
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey returns the key described by k, or an error if it is not an RSA or
+// P-256 key.
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	b := base64.RawURLEncoding
+	switch k.Kty {
+	case "RSA":
+		n, err := b.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := b.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		exp := new(big.Int).SetBytes(e)
+		if !exp.IsInt64() || exp.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("RSA exponent too large")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := b.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := b.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, fmt.Errorf("point not on curve")
+		}
+		return pub, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// keySet is the set of signing keys published by the provider, which are
+// fetched again when a token is signed with an unknown key.
+type keySet struct {
+	client *http.Client
+	url    string
+
+	mut     *sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+func newKeySet(client *http.Client, url string) *keySet {
+	return &keySet{
+		client: client,
+		url:    url,
+		mut:    new(sync.Mutex),
+		keys:   make(map[string]crypto.PublicKey),
+	}
+}
+
+// refresh fetches the current signing keys. Keys which cannot be used are
+// skipped. The caller must not hold ks.mut.
+func (ks *keySet) refresh(ctx context.Context) error {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := getJSON(ctx, ks.client, ks.url, &set); err != nil {
+		return err
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if pub, err := k.publicKey(); err == nil {
+			keys[k.Kid] = pub
+		}
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("%s: no usable signing keys", ks.url)
+	}
+
+	ks.mut.Lock()
+	ks.keys, ks.fetched = keys, time.Now()
+	ks.mut.Unlock()
+	return nil
+}
+
+// key returns the key with the given ID, fetching the keys again if it is
+// unknown and they have not been fetched recently.
+func (ks *keySet) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	ks.mut.Lock()
+	pub, ok := ks.keys[kid]
+	stale := time.Since(ks.fetched) > keyRefresh
+	ks.mut.Unlock()
+	if ok {
+		return pub, nil
+	}
+	if !stale {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+
+	if err := ks.refresh(ctx); err != nil {
+		return nil, err
+	}
+	ks.mut.Lock()
+	pub, ok = ks.keys[kid]
+	ks.mut.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	return pub, nil
+}
+
+// audience is the "aud" claim, which may be either one string or an array.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if json.Unmarshal(b, &s) == nil {
+		*a = audience{s}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(a))
+}
+
+// claims are the claims of an ID token which are checked or used.
+type claims struct {
+	Issuer          string   `json:"iss"`
+	Subject         string   `json:"sub"`
+	Audience        audience `json:"aud"`
+	AuthorizedParty string   `json:"azp"`
+	Expiry          float64  `json:"exp"`
+	IssuedAt        float64  `json:"iat"`
+	Nonce           string   `json:"nonce"`
+
+	Email         string `json:"email"`
+	EmailVerified *bool  `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+// verify checks the signature and claims of the ID token raw, which must have
+// been issued to this client for the login with the given nonce, and returns
+// the identity which it asserts.
+func (p *Provider) verify(ctx context.Context, raw, nonce string) (Identity, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return Identity{}, fmt.Errorf("%w: malformed", ErrToken)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Identity{}, fmt.Errorf("%w: header: %s", ErrToken, err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: signature: %s", ErrToken, err)
+	}
+	pub, err := p.keys.key(ctx, header.Kid)
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: %s", ErrToken, err)
+	}
+	if err := checkSignature(header.Alg, pub, parts[0]+"."+parts[1], sig); err != nil {
+		return Identity{}, fmt.Errorf("%w: %s", ErrToken, err)
+	}
+
+	var c claims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return Identity{}, fmt.Errorf("%w: claims: %s", ErrToken, err)
+	}
+	var all map[string]json.RawMessage
+	if err := decodeSegment(parts[1], &all); err != nil {
+		return Identity{}, fmt.Errorf("%w: claims: %s", ErrToken, err)
+	}
+
+	now := time.Now()
+	switch {
+	case c.Issuer != p.cfg.Issuer:
+		return Identity{}, fmt.Errorf("%w: issued by %q", ErrToken, c.Issuer)
+	case !containsExact(c.Audience, p.cfg.ClientID):
+		return Identity{}, fmt.Errorf("%w: not issued to this client", ErrToken)
+	case len(c.Audience) > 1 && c.AuthorizedParty != p.cfg.ClientID:
+		return Identity{}, fmt.Errorf("%w: not authorized for this client", ErrToken)
+	case c.Expiry == 0 || now.Add(-ClockSkew).After(unixTime(c.Expiry)):
+		return Identity{}, fmt.Errorf("%w: expired", ErrToken)
+	case now.Add(ClockSkew).Before(unixTime(c.IssuedAt)):
+		return Identity{}, fmt.Errorf("%w: issued in the future", ErrToken)
+	case c.Nonce != nonce:
+		return Identity{}, fmt.Errorf("%w: nonce does not match", ErrToken)
+	case c.Subject == "":
+		return Identity{}, fmt.Errorf("%w: no subject", ErrToken)
+	}
+
+	id := Identity{Subject: c.Subject, Name: c.Name}
+	// Addresses are only trusted if the provider vouches for them, as some
+	// let users set any address they like
+	if c.Email != "" && c.EmailVerified != nil && *c.EmailVerified {
+		id.Email = c.Email
+	}
+	if g, ok := all[p.cfg.GroupClaim]; ok {
+		var groups audience
+		if json.Unmarshal(g, &groups) == nil {
+			id.Groups = groups
+		}
+	}
+
+	return id, nil
+}
+
+// checkSignature checks that sig is a valid signature of signed by pub using
+// the algorithm alg.
+func checkSignature(alg string, pub crypto.PublicKey, signed string, sig []byte) error {
+	sum := sha256.Sum256([]byte(signed))
+	switch alg {
+	case "RS256":
+		key, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("RS256 token signed with non-RSA key")
+		}
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig); err != nil {
+			return fmt.Errorf("bad signature")
+		}
+	case "ES256":
+		key, ok := pub.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("ES256 token signed with non-EC key")
+		}
+		if len(sig) != 64 {
+			return fmt.Errorf("bad signature")
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(key, sum[:], r, s) {
+			return fmt.Errorf("bad signature")
+		}
+	default:
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+
+	return nil
+}
+
+// decodeSegment decodes one base64 encoded JSON segment of a token into v.
+func decodeSegment(seg string, v interface{}) error {
+	buf, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(buf, v)
+}
+
+// unixTime converts a token time, in seconds since the epoch, to a time.Time.
+func unixTime(t float64) time.Time {
+	return time.Unix(int64(t), 0)
+}
+
+// containsExact reports if any element of list is s.
+func containsExact(list []string, s string) bool {
+	for _, elem := range list {
+		if elem == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,333 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Login defaults.
+const (
+	// LoginTime is the time within which a login must be finished once
+	// begun.
+	LoginTime = time.Minute * 10
+	// MaxPending is the maximum number of logins which may be waiting to be
+	// finished at once.
+	MaxPending = 1000
+	// DefaultGroupClaim is the ID token claim which lists the groups to
+	// which the user belongs, if not configured.
+	DefaultGroupClaim = "groups"
+)
+
+// Login errors.
+var (
+	ErrDiscovery = errors.New("oidc: discovery failed")
+	ErrExchange  = errors.New("oidc: code exchange failed")
+	ErrToken     = errors.New("oidc: invalid ID token")
+	ErrState     = errors.New("oidc: unknown or expired login")
+	ErrBusy      = errors.New("oidc: too many logins in progress")
+	ErrDenied    = errors.New("oidc: user not allowed to log in")
+)
+
+// Config describes an identity provider and how to log in through it.
+type Config struct {
+	// Issuer is the URL which identifies the provider, under which its
+	// discovery document is found.
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the address on this server to which the provider
+	// sends users back once they have logged in.
+	RedirectURL string
+
+	// Domains, if not empty, limits logins to users whose email address
+	// is in one of these domains.
+	Domains []string
+	// Groups, if not empty, limits logins to users who belong to one of
+	// these groups, as listed in the GroupClaim claim of the ID token. If
+	// GroupClaim is empty, defaults to DefaultGroupClaim.
+	Groups     []string
+	GroupClaim string
+
+	// Client makes requests to the provider. If nil, defaults to a client
+	// with a ten second timeout.
+	Client *http.Client
+}
+
+// Identity is a user who has logged in through the provider.
+type Identity struct {
+	Subject string
+	// Email is the email address of the user, or empty if they have none
+	// or the provider does not say that it is verified.
+	Email  string
+	Name   string
+	Groups []string
+}
+
+// Username returns the name by which the user is known on this server: their
+// email address or, if they have none, their subject identifier prefixed with
+// "oidc:", which cannot be mistaken for the name of a local account.
+func (id Identity) Username() string {
+	if id.Email != "" {
+		return id.Email
+	}
+	return "oidc:" + id.Subject
+}
+
+// pending is a login which has been begun but not yet finished.
+type pending struct {
+	nonce    string
+	verifier string
+	next     string
+	expires  time.Time
+}
+
+// Provider is an identity provider through which users may log in. It is safe
+// for concurrent use.
+type Provider struct {
+	cfg      Config
+	authURL  string
+	tokenURL string
+	keys     *keySet
+
+	mut     *sync.Mutex
+	pending map[string]pending
+}
+
+// discovery is the part of the provider's discovery document which is used.
+type discovery struct {
+	Issuer   string `json:"issuer"`
+	AuthURL  string `json:"authorization_endpoint"`
+	TokenURL string `json:"token_endpoint"`
+	JWKSURL  string `json:"jwks_uri"`
+}
+
+// Discover fetches the discovery document and signing keys of the provider
+// described by cfg.
+func Discover(ctx context.Context, cfg Config) (*Provider, error) {
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if cfg.GroupClaim == "" {
+		cfg.GroupClaim = DefaultGroupClaim
+	}
+
+	var doc discovery
+	wellKnown := strings.TrimSuffix(cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := getJSON(ctx, cfg.Client, wellKnown, &doc); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrDiscovery, err)
+	}
+	if doc.Issuer != cfg.Issuer {
+		return nil, fmt.Errorf("%w: issuer %q does not match %q", ErrDiscovery, doc.Issuer, cfg.Issuer)
+	}
+	if doc.AuthURL == "" || doc.TokenURL == "" || doc.JWKSURL == "" {
+		return nil, fmt.Errorf("%w: missing endpoints", ErrDiscovery)
+	}
+
+	keys := newKeySet(cfg.Client, doc.JWKSURL)
+	if err := keys.refresh(ctx); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrDiscovery, err)
+	}
+
+	return &Provider{
+		cfg:      cfg,
+		authURL:  doc.AuthURL,
+		tokenURL: doc.TokenURL,
+		keys:     keys,
+		mut:      new(sync.Mutex),
+		pending:  make(map[string]pending),
+	}, nil
+}
+
+// getJSON fetches the JSON document at u into v.
+func getJSON(ctx context.Context, client *http.Client, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", u, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// randomString returns a random, unguessable string.
+func randomString() string {
+	var buf [24]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		panic("oidc: system random source failed: " + err.Error())
+	}
+	return hex.EncodeToString(buf[:])
+}
+
+// Begin begins a new login, after which the user is to be sent back to next.
+// It returns the address of the provider's login page, to which the user must
+// be sent, and the state of the login, which must be kept by the user's
+// browser to prove that it began the login.
+func (p *Provider) Begin(next string) (string, string, error) {
+	state := randomString()
+	login := pending{
+		nonce:    randomString(),
+		verifier: randomString() + randomString(),
+		next:     next,
+		expires:  time.Now().Add(LoginTime),
+	}
+
+	now := time.Now()
+	p.mut.Lock()
+	for s, l := range p.pending {
+		if now.After(l.expires) {
+			delete(p.pending, s)
+		}
+	}
+	if len(p.pending) >= MaxPending {
+		p.mut.Unlock()
+		return "", "", ErrBusy
+	}
+	p.pending[state] = login
+	p.mut.Unlock()
+
+	challenge := sha256.Sum256([]byte(login.verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.RedirectURL},
+		"scope":                 {"openid email profile"},
+		"state":                 {state},
+		"nonce":                 {login.nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(p.authURL, "?") {
+		sep = "&"
+	}
+
+	return p.authURL + sep + q.Encode(), state, nil
+}
+
+// Finish finishes the login with the given state, exchanging the code given by
+// the provider for the user's identity. It returns the identity, once checked
+// against the allowed domains and groups, and the page to which the user is
+// to be sent. Each login may only be finished once.
+func (p *Provider) Finish(ctx context.Context, state, code string) (Identity, string, error) {
+	p.mut.Lock()
+	login, ok := p.pending[state]
+	delete(p.pending, state)
+	p.mut.Unlock()
+	if !ok || time.Now().After(login.expires) {
+		return Identity{}, "", ErrState
+	}
+
+	raw, err := p.exchange(ctx, code, login.verifier)
+	if err != nil {
+		return Identity{}, "", err
+	}
+	id, err := p.verify(ctx, raw, login.nonce)
+	if err != nil {
+		return Identity{}, "", err
+	}
+	if err := p.allowed(id); err != nil {
+		return Identity{}, "", err
+	}
+
+	return id, login.next, nil
+}
+
+// exchange exchanges an authorization code for an ID token at the token
+// endpoint.
+func (p *Provider) exchange(ctx context.Context, code, verifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"client_id":     {p.cfg.ClientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrExchange, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	resp, err := p.cfg.Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrExchange, err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken     string `json:"id_token"`
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return "", fmt.Errorf("%w: %s: %s", ErrExchange, resp.Status, err)
+	}
+	if body.Error != "" {
+		return "", fmt.Errorf("%w: %s: %s", ErrExchange, body.Error, body.Description)
+	}
+	if resp.StatusCode != http.StatusOK || body.IDToken == "" {
+		return "", fmt.Errorf("%w: %s: no ID token", ErrExchange, resp.Status)
+	}
+
+	return body.IDToken, nil
+}
+
+// allowed returns ErrDenied if id is not in an allowed domain or group.
+func (p *Provider) allowed(id Identity) error {
+	if len(p.cfg.Domains) > 0 {
+		_, domain, _ := strings.Cut(id.Email, "@")
+		if !containsFold(p.cfg.Domains, domain) {
+			return fmt.Errorf("%w: email %q not in an allowed domain", ErrDenied, id.Email)
+		}
+	}
+	if len(p.cfg.Groups) > 0 {
+		ok := false
+		for _, g := range id.Groups {
+			if containsFold(p.cfg.Groups, g) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return fmt.Errorf("%w: %s not in an allowed group", ErrDenied, id.Username())
+		}
+	}
+
+	return nil
+}
+
+// containsFold reports if any element of list is s, ignoring case.
+func containsFold(list []string, s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, elem := range list {
+		if strings.EqualFold(elem, s) {
+			return true
+		}
+	}
+	return false
+}
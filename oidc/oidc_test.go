@@ -0,0 +1,393 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testClient = "gahoot"
+	testSecret = "s3cret"
+	testReturn = "https://gahoot.example/login/oidc/callback"
+)
+
+// fakeIdP is a tiny identity provider which logs in every user straight away,
+// as whoever its claims say.
+type fakeIdP struct {
+	*httptest.Server
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+
+	mut sync.Mutex
+	// Claims given to the next user to log in
+	claims map[string]interface{}
+	// Codes waiting to be exchanged, with the nonce and PKCE challenge of
+	// their login
+	codes map[string][2]string
+}
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	rk, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ek, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idp := &fakeIdP{rsaKey: rk, ecKey: ek, codes: make(map[string][2]string)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", idp.discovery)
+	mux.HandleFunc("/authorize", idp.authorize)
+	mux.HandleFunc("/token", idp.token)
+	mux.HandleFunc("/jwks", idp.jwks)
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+
+	idp.setClaims(nil)
+	return idp
+}
+
+// setClaims sets the claims given to the next user, on top of valid defaults.
+func (idp *fakeIdP) setClaims(extra map[string]interface{}) {
+	c := map[string]interface{}{
+		"iss":            idp.URL,
+		"sub":            "1234",
+		"aud":            testClient,
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"iat":            time.Now().Unix(),
+		"email":          "alice@school.example",
+		"email_verified": true,
+		"name":           "Alice",
+	}
+	for k, v := range extra {
+		if v == nil {
+			delete(c, k)
+		} else {
+			c[k] = v
+		}
+	}
+
+	idp.mut.Lock()
+	idp.claims = c
+	idp.mut.Unlock()
+}
+
+func (idp *fakeIdP) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 idp.URL,
+		"authorization_endpoint": idp.URL + "/authorize",
+		"token_endpoint":         idp.URL + "/token",
+		"jwks_uri":               idp.URL + "/jwks",
+	})
+}
+
+func (idp *fakeIdP) jwks(w http.ResponseWriter, r *http.Request) {
+	b := base64.RawURLEncoding
+	pad := func(i *big.Int) string {
+		buf := make([]byte, 32)
+		return b.EncodeToString(i.FillBytes(buf))
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]string{
+			{
+				"kty": "RSA", "kid": "rsa", "use": "sig",
+				"n": b.EncodeToString(idp.rsaKey.N.Bytes()),
+				"e": b.EncodeToString(big.NewInt(int64(idp.rsaKey.E)).Bytes()),
+			},
+			{
+				"kty": "EC", "kid": "ec", "crv": "P-256",
+				"x": pad(idp.ecKey.X), "y": pad(idp.ecKey.Y),
+			},
+			{"kty": "oct", "kid": "hmac", "k": "c2VjcmV0"},
+		},
+	})
+}
+
+func (idp *fakeIdP) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != testClient || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	code := randomString()
+	idp.mut.Lock()
+	idp.codes[code] = [2]string{q.Get("nonce"), q.Get("code_challenge")}
+	idp.mut.Unlock()
+
+	back := q.Get("redirect_uri") + "?" + url.Values{"code": {code}, "state": {q.Get("state")}}.Encode()
+	http.Redirect(w, r, back, http.StatusFound)
+}
+
+func (idp *fakeIdP) token(w http.ResponseWriter, r *http.Request) {
+	fail := func(code string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": code})
+	}
+
+	user, pass, _ := r.BasicAuth()
+	if user != testClient || pass != testSecret {
+		fail("invalid_client")
+		return
+	}
+	idp.mut.Lock()
+	login, ok := idp.codes[r.PostFormValue("code")]
+	delete(idp.codes, r.PostFormValue("code"))
+	claims := make(map[string]interface{}, len(idp.claims)+1)
+	for k, v := range idp.claims {
+		claims[k] = v
+	}
+	idp.mut.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !ok || r.PostFormValue("redirect_uri") != testReturn ||
+		base64.RawURLEncoding.EncodeToString(sum[:]) != login[1] {
+		fail("invalid_grant")
+		return
+	}
+
+	if _, ok := claims["nonce"]; !ok {
+		claims["nonce"] = login[0]
+	}
+	json.NewEncoder(w).Encode(map[string]string{
+		"access_token": "unused",
+		"token_type":   "Bearer",
+		"id_token":     idp.sign("RS256", "rsa", claims),
+	})
+}
+
+// sign returns a token of claims, signed with the key kid using alg.
+func (idp *fakeIdP) sign(alg, kid string, claims map[string]interface{}) string {
+	enc := func(v interface{}) string {
+		buf, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(buf)
+	}
+	signed := enc(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"}) + "." + enc(claims)
+	sum := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	switch alg {
+	case "RS256":
+		sig, _ = rsa.SignPKCS1v15(rand.Reader, idp.rsaKey, crypto.SHA256, sum[:])
+	case "ES256":
+		r, s, _ := ecdsa.Sign(rand.Reader, idp.ecKey, sum[:])
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func testProvider(t *testing.T, idp *fakeIdP, cfg Config) *Provider {
+	cfg.Issuer = idp.URL
+	cfg.ClientID, cfg.ClientSecret = testClient, testSecret
+	cfg.RedirectURL = testReturn
+
+	p, err := Discover(context.Background(), cfg)
+	if err != nil {
+		t.Fatal("discovery failed:", err)
+	}
+	return p
+}
+
+// login logs in to p through idp as a browser would, returning the result.
+func login(t *testing.T, p *Provider) (Identity, string, error) {
+	to, state, err := p.Begin("/account/")
+	if err != nil {
+		t.Fatal("beginning login:", err)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(to)
+	if err != nil {
+		t.Fatal("visiting provider:", err)
+	}
+	resp.Body.Close()
+	back, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || !strings.HasPrefix(back.String(), testReturn) {
+		t.Fatalf("provider sent us to %q, expected %q", back, testReturn)
+	}
+	if back.Query().Get("state") != state {
+		t.Fatalf("provider returned state %q, expected %q", back.Query().Get("state"), state)
+	}
+
+	return p.Finish(context.Background(), state, back.Query().Get("code"))
+}
+
+func TestLogin(t *testing.T) {
+	idp := newFakeIdP(t)
+	p := testProvider(t, idp, Config{})
+
+	id, next, err := login(t, p)
+	if err != nil {
+		t.Fatal("login failed:", err)
+	}
+	if id.Username() != "alice@school.example" || id.Name != "Alice" || id.Subject != "1234" {
+		t.Errorf("logged in as %+v, expected alice", id)
+	}
+	if next != "/account/" {
+		t.Errorf("sent on to %q, expected /account/", next)
+	}
+
+	if _, _, err := p.Finish(context.Background(), "unknown", "code"); !errors.Is(err, ErrState) {
+		t.Errorf("finishing unknown login: got %v, expected %v", err, ErrState)
+	}
+
+	// Unverified addresses are not trusted, even if the provider does not
+	// say either way
+	for _, verified := range []interface{}{false, nil} {
+		idp.setClaims(map[string]interface{}{"email_verified": verified})
+		id, _, err = login(t, p)
+		if err != nil {
+			t.Fatal("login failed:", err)
+		}
+		if id.Email != "" || id.Username() != "oidc:1234" {
+			t.Errorf("unverified email used with email_verified %v: %+v", verified, id)
+		}
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	idp := newFakeIdP(t)
+	_, err := Discover(context.Background(), Config{Issuer: idp.URL + "/other", ClientID: testClient})
+	if !errors.Is(err, ErrDiscovery) {
+		t.Errorf("discovering missing provider: got %v, expected %v", err, ErrDiscovery)
+	}
+	_, err = Discover(context.Background(), Config{Issuer: idp.URL + "/", ClientID: testClient})
+	if !errors.Is(err, ErrDiscovery) {
+		t.Errorf("discovering mismatched issuer: got %v, expected %v", err, ErrDiscovery)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	idp := newFakeIdP(t)
+	p := testProvider(t, idp, Config{})
+
+	to, _, _ := p.Begin("/")
+	u, _ := url.Parse(to)
+	code := randomString()
+	idp.mut.Lock()
+	idp.codes[code] = [2]string{u.Query().Get("nonce"), u.Query().Get("code_challenge")}
+	idp.mut.Unlock()
+
+	if _, err := p.exchange(context.Background(), code, "wrong verifier"); !errors.Is(err, ErrExchange) {
+		t.Errorf("exchanging with wrong verifier: got %v, expected %v", err, ErrExchange)
+	}
+
+	p.cfg.ClientSecret = "wrong"
+	if _, _, err := login(t, p); !errors.Is(err, ErrExchange) {
+		t.Errorf("logging in with wrong secret: got %v, expected %v", err, ErrExchange)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	idp := newFakeIdP(t)
+	p := testProvider(t, idp, Config{})
+	now := time.Now()
+
+	valid := func(extra map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss": idp.URL, "sub": "1234", "aud": testClient, "nonce": "n",
+			"exp": now.Add(time.Hour).Unix(), "iat": now.Unix(),
+		}
+		for k, v := range extra {
+			c[k] = v
+		}
+		return c
+	}
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"RS256", idp.sign("RS256", "rsa", valid(nil)), true},
+		{"ES256", idp.sign("ES256", "ec", valid(nil)), true},
+		{"audience array", idp.sign("RS256", "rsa", valid(map[string]interface{}{
+			"aud": []string{testClient, "other"}, "azp": testClient})), true},
+		{"audience array without azp", idp.sign("RS256", "rsa", valid(map[string]interface{}{
+			"aud": []string{testClient, "other"}})), false},
+		{"wrong audience", idp.sign("RS256", "rsa", valid(map[string]interface{}{"aud": "other"})), false},
+		{"wrong issuer", idp.sign("RS256", "rsa", valid(map[string]interface{}{"iss": "https://evil.example"})), false},
+		{"wrong nonce", idp.sign("RS256", "rsa", valid(map[string]interface{}{"nonce": "m"})), false},
+		{"expired", idp.sign("RS256", "rsa", valid(map[string]interface{}{"exp": now.Add(-time.Hour).Unix()})), false},
+		{"future", idp.sign("RS256", "rsa", valid(map[string]interface{}{"iat": now.Add(time.Hour).Unix()})), false},
+		{"wrong key type", idp.sign("RS256", "ec", valid(nil)), false},
+		{"unknown key", idp.sign("RS256", "other", valid(nil)), false},
+		{"unsigned", idp.sign("none", "rsa", valid(nil)), false},
+		{"symmetric", idp.sign("HS256", "hmac", valid(nil)), false},
+		{"malformed", "not.a-token", false},
+	}
+
+	tampered := idp.sign("RS256", "rsa", valid(nil))
+	parts := strings.Split(tampered, ".")
+	evil, _ := json.Marshal(valid(map[string]interface{}{"sub": "admin"}))
+	parts[1] = base64.RawURLEncoding.EncodeToString(evil)
+	tests = append(tests, struct {
+		name  string
+		token string
+		ok    bool
+	}{"tampered", strings.Join(parts, "."), false})
+
+	for _, tt := range tests {
+		_, err := p.verify(context.Background(), tt.token, "n")
+		if tt.ok && err != nil {
+			t.Errorf("%s: expected valid, got %v", tt.name, err)
+		} else if !tt.ok && !errors.Is(err, ErrToken) {
+			t.Errorf("%s: expected %v, got %v", tt.name, ErrToken, err)
+		}
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	idp := newFakeIdP(t)
+
+	p := testProvider(t, idp, Config{Domains: []string{"School.example"}})
+	if _, _, err := login(t, p); err != nil {
+		t.Error("user in allowed domain refused:", err)
+	}
+	idp.setClaims(map[string]interface{}{"email": "mallory@evil.example"})
+	if _, _, err := login(t, p); !errors.Is(err, ErrDenied) {
+		t.Errorf("user in other domain: got %v, expected %v", err, ErrDenied)
+	}
+	idp.setClaims(map[string]interface{}{"email": "alice@school.example", "email_verified": false})
+	if _, _, err := login(t, p); !errors.Is(err, ErrDenied) {
+		t.Errorf("user with unverified email: got %v, expected %v", err, ErrDenied)
+	}
+
+	p = testProvider(t, idp, Config{Groups: []string{"teachers"}, GroupClaim: "roles"})
+	idp.setClaims(map[string]interface{}{"roles": []string{"staff", "teachers"}})
+	id, _, err := login(t, p)
+	if err != nil {
+		t.Error("user in allowed group refused:", err)
+	} else if len(id.Groups) != 2 {
+		t.Errorf("got groups %v, expected [staff teachers]", id.Groups)
+	}
+	idp.setClaims(map[string]interface{}{"roles": "students"})
+	if _, _, err := login(t, p); !errors.Is(err, ErrDenied) {
+		t.Errorf("user in other group: got %v, expected %v", err, ErrDenied)
+	}
+	idp.setClaims(nil)
+	if _, _, err := login(t, p); !errors.Is(err, ErrDenied) {
+		t.Errorf("user in no group: got %v, expected %v", err, ErrDenied)
+	}
+}
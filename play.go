@@ -1,11 +1,15 @@
 package main
 
 import (
+	"crypto/subtle"
+	"image/png"
 	"log"
 	"net/http"
-	"strconv"
+	"path"
+	"strings"
 
 	"github.com/ejv2/gahoot/game"
+	"github.com/ejv2/gahoot/qr"
 
 	"github.com/gin-gonic/gin"
 )
@@ -13,11 +17,18 @@ import (
 // handleHost is the handler for "/play/host/{game PIN}".
 //
 // Handles validation and filling in information before returning the hoster's
-// UI.
+// UI. The host token is read from the cookie set on game creation, or from the
+// "key" query of a host link given out by the JSON API; without it, the
+// visitor is sent back to create their own game. If hosts must log in, only
+// the account which created the game may host it.
 func handleHost(c *gin.Context) {
 	dat := struct {
 		Title          string
 		Pin            uint32
+		Token          string
+		WatchLink      string
+		RemoteLink     string
+		ResultsLink    string
 		WebsocketProto string
 		SiteLink       string
 	}{WebsocketProto: Config.WSProto(), SiteLink: Config.SiteLink}
@@ -32,14 +43,14 @@ func handleHost(c *gin.Context) {
 		c.Abort()
 	}
 
-	pin, err := strconv.ParseUint(spin, 10, 32)
+	pin, err := game.ParsePin(spin)
 	if err != nil {
 		back()
 		return
 	}
 	dat.Pin = uint32(pin)
 
-	g, ok := Coordinator.GetGame(game.Pin(dat.Pin))
+	g, ok := Coordinator.GetGame(pin)
 	if !ok {
 		c.Redirect(http.StatusSeeOther, "/create/")
 		c.Abort()
@@ -47,24 +58,102 @@ func handleHost(c *gin.Context) {
 	}
 	dat.Title = g.Title
 
+	if Config.LoginHost && g.Owner != "" && g.Owner != c.GetString(AccountUserKey) {
+		c.HTML(http.StatusForbidden, "error.gohtml", gin.H{
+			"Title":   "Not your game",
+			"Message": "This game was created by another account. Log in as " + g.Owner + " to host it.",
+		})
+		c.Abort()
+		return
+	}
+
+	// Host links handed out by the JSON API carry the token, which is
+	// moved into the cookie such that it does not linger in the address bar
+	if key := c.Query("key"); key != "" {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(g.HostToken)) != 1 {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		c.SetCookie(HostCookie, g.HostToken, int(g.MaxGameTime.Seconds()),
+			"/play/host/"+g.PIN.String(), "", Config.HasSSL, true)
+		c.Redirect(http.StatusSeeOther, "/play/host/"+g.PIN.String())
+		return
+	}
+
+	// Without the token, this client cannot host the game, and must not
+	// be shown the links which carry the game's other tokens
+	tok, err := c.Cookie(HostCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(tok), []byte(g.HostToken)) != 1 {
+		c.Redirect(http.StatusSeeOther, "/create/")
+		c.Abort()
+		return
+	}
+	dat.Token = tok
+	dat.WatchLink = "/play/watch/" + spin + "?key=" + g.WatchToken
+	dat.RemoteLink = "/play/remote/" + spin + "?key=" + g.RemoteToken
+	if Archive != nil {
+		dat.ResultsLink = resultsLink(pin, g.ID)
+	}
+
 	c.HTML(200, "host.gohtml", dat)
 }
 
+// handleWatch is the handler for "/play/watch/{game PIN}?key={watch token}".
+//
+// Returns the read-only spectator display for a game. The watch token is
+// passed through to the page as-is and validated when the websocket connects.
+func handleWatch(c *gin.Context) {
+	dat := struct {
+		Title          string
+		Pin            uint32
+		Token          string
+		WebsocketProto string
+		SiteLink       string
+	}{WebsocketProto: Config.WSProto(), SiteLink: Config.SiteLink}
+
+	spin := c.Param("pin")
+	if spin == "" {
+		log.Panic("handlewatch: no PIN parameter in required handler")
+	}
+
+	pin, err := game.ParsePin(spin)
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	dat.Pin = uint32(pin)
+
+	g, ok := Coordinator.GetGame(pin)
+	if !ok {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	dat.Title = g.Title
+
+	dat.Token = c.Query("key")
+	if dat.Token == "" {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	c.HTML(200, "watch.gohtml", dat)
+}
+
 // handleGame is the handler for "/play/game/{game PIN}".
 //
 // Handles validation and filling in information before returning the main
-// frontend. At this stage, the provided UID is not validated - the websocket
-// will simply fail later if this is invalid/out of range.
+// frontend. The player's session token is read from the cookie set on joining.
+// At this stage, the token is not validated - the websocket will simply fail
+// later if this is invalid.
 func handleGame(c *gin.Context) {
 	dat := struct {
 		// NOTE: Must be uint32, as game.GamePin is formatted as a JS string
 		Pin            uint32
-		UID            int
+		Token          string
 		WebsocketProto string
 	}{WebsocketProto: Config.WSProto()}
 
-	id, pin := c.Param("pin"), game.Pin(0)
-	uid, intuid := c.Query("plr"), int(0)
+	id := c.Param("pin")
 	if id == "" {
 		log.Panic("handlegame: no PIN parameter in required handler")
 	}
@@ -74,29 +163,108 @@ func handleGame(c *gin.Context) {
 		c.Abort()
 	}
 
-	i, err := strconv.ParseUint(id, 10, 32)
+	pin, err := game.ParsePin(id)
 	if err != nil {
 		back()
 		return
 	}
-	pin = game.Pin(i)
 	if !Coordinator.GameExists(pin) {
 		back()
 		return
 	}
 
-	if uid == "" {
+	tok, err := c.Cookie(PlayerCookie + id)
+	if err != nil || tok == "" {
 		back()
 		return
 	}
-	p, err := strconv.ParseUint(uid, 10, 32)
-	intuid = int(p)
+
+	dat.Pin, dat.Token = uint32(pin), tok
+	log.Println("player from", c.ClientIP(), "is joining game", pin)
+	c.HTML(200, "play.gohtml", dat)
+}
+
+// handleRemote is the handler for "/play/remote/{game PIN}?key={remote token}".
+//
+// Returns the remote control page for a game, for use on a phone or other
+// device away from the host's screen. The remote token is passed through to
+// the page as-is and validated when the websocket connects.
+func handleRemote(c *gin.Context) {
+	dat := struct {
+		Title          string
+		Pin            uint32
+		Token          string
+		WebsocketProto string
+	}{WebsocketProto: Config.WSProto()}
+
+	spin := c.Param("pin")
+	if spin == "" {
+		log.Panic("handleremote: no PIN parameter in required handler")
+	}
+
+	pin, err := game.ParsePin(spin)
 	if err != nil {
-		back()
+		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
+	dat.Pin = uint32(pin)
 
-	dat.Pin, dat.UID = uint32(i), intuid
-	log.Println("user ID", intuid, "is joining game", pin)
-	c.HTML(200, "play.gohtml", dat)
+	g, ok := Coordinator.GetGame(pin)
+	if !ok {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	dat.Title = g.Title
+
+	dat.Token = c.Query("key")
+	if dat.Token == "" {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	c.HTML(200, "remote.gohtml", dat)
+}
+
+// joinLink returns the address at which players can join the game with the
+// given PIN, which skips straight past entering the PIN.
+func joinLink(pin game.Pin) string {
+	return strings.TrimSuffix(Config.SiteLink, "/") + "/join?pin=" + pin.String()
+}
+
+// handleQR is the handler for "/play/qr/{game PIN}.{png,svg}".
+//
+// Renders a QR code of the join link for a game, for display in the host's
+// lobby so that players can join by scanning it.
+func handleQR(c *gin.Context) {
+	file := c.Param("file")
+	ext := path.Ext(file)
+
+	pin, err := game.ParsePin(strings.TrimSuffix(file, ext))
+	if err != nil || !Coordinator.GameExists(pin) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	code, err := qr.Encode(joinLink(pin), qr.M)
+	if err != nil {
+		log.Println("QR encoding failed:", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.Header("Cache-Control", "private, max-age=3600")
+	switch ext {
+	case ".png":
+		c.Header("Content-Type", "image/png")
+		err = png.Encode(c.Writer, code.Image(QRScale))
+	case ".svg":
+		c.Header("Content-Type", "image/svg+xml")
+		err = code.WriteSVG(c.Writer)
+	default:
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("QR rendering failed:", err)
+	}
 }
@@ -0,0 +1,12 @@
+// Package qr implements a minimal QR code encoder, sufficient for rendering
+// links such as game join URLs.
+//
+// Only byte mode encoding is supported, which can represent any text at the
+// cost of some density compared to the numeric and alphanumeric modes. The
+// smallest version (size) which fits the text at the requested error
+// correction level is always chosen, and the data mask is picked using the
+// standard penalty rules, such that the result scans easily.
+//
+// Codes can be rendered either as a PNG image or as SVG, both including the
+// four module wide quiet zone required by scanners.
+package qr
@@ -0,0 +1,131 @@
+package qr
+
+// Number of error correction codewords in each block, indexed by level and
+// version. Version zero does not exist.
+var eccPerBlock = [4][41]int{
+	{-1, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+	{-1, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+}
+
+// Number of error correction blocks, indexed by level and version.
+var eccBlocks = [4][41]int{
+	{-1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+	{-1, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+	{-1, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+	{-1, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+}
+
+// rawModules returns the number of modules in a code of version ver which are
+// available for data and error correction, after all function patterns are
+// placed.
+func rawModules(ver int) int {
+	n := (16*ver+128)*ver + 64
+	if ver >= 2 {
+		align := ver/7 + 2
+		n -= (25*align-10)*align - 55
+		if ver >= 7 {
+			n -= 36
+		}
+	}
+
+	return n
+}
+
+// dataCodewords returns the number of 8-bit data codewords which fit in a code
+// of version ver at error correction level lvl.
+func dataCodewords(ver int, lvl Level) int {
+	return rawModules(ver)/8 - eccPerBlock[lvl][ver]*eccBlocks[lvl][ver]
+}
+
+// gfMul multiplies two elements of GF(2^8) modulo the QR code field
+// polynomial, x^8 + x^4 + x^3 + x^2 + 1.
+func gfMul(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11d)
+		z ^= int((y>>i)&1) * int(x)
+	}
+
+	return byte(z)
+}
+
+// rsDivisor returns the Reed-Solomon generator polynomial of the given degree,
+// from the highest to lowest power, excluding the leading one.
+func rsDivisor(degree int) []byte {
+	div := make([]byte, degree)
+	div[degree-1] = 1
+
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range div {
+			div[j] = gfMul(div[j], root)
+			if j+1 < len(div) {
+				div[j] ^= div[j+1]
+			}
+		}
+		root = gfMul(root, 0x02)
+	}
+
+	return div
+}
+
+// rsRemainder returns the Reed-Solomon error correction codewords for data.
+func rsRemainder(data, div []byte) []byte {
+	rem := make([]byte, len(div))
+	for _, b := range data {
+		factor := b ^ rem[0]
+		copy(rem, rem[1:])
+		rem[len(rem)-1] = 0
+		for i := range rem {
+			rem[i] ^= gfMul(div[i], factor)
+		}
+	}
+
+	return rem
+}
+
+// addECC splits data into blocks, appends error correction to each block and
+// interleaves the result, as it is to be placed in a code of version ver.
+func addECC(data []byte, ver int, lvl Level) []byte {
+	nblocks, ecc := eccBlocks[lvl][ver], eccPerBlock[lvl][ver]
+	raw := rawModules(ver) / 8
+	nshort := nblocks - raw%nblocks
+	shortLen := raw / nblocks
+
+	div := rsDivisor(ecc)
+	blocks := make([][]byte, nblocks)
+	for i, k := 0, 0; i < nblocks; i++ {
+		n := shortLen - ecc
+		if i >= nshort {
+			n++
+		}
+
+		blocks[i] = append([]byte(nil), data[k:k+n]...)
+		blocks[i] = append(blocks[i], rsRemainder(data[k:k+n], div)...)
+		k += n
+	}
+
+	// Short blocks have one less data codeword, so are skipped on the
+	// last data column
+	res := make([]byte, 0, raw)
+	for i := 0; i <= shortLen; i++ {
+		for j, b := range blocks {
+			if j < nshort {
+				if i == shortLen-ecc {
+					continue
+				}
+				if i > shortLen-ecc {
+					res = append(res, b[i-1])
+					continue
+				}
+			}
+			if i < len(b) {
+				res = append(res, b[i])
+			}
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,439 @@
+package qr
+
+import (
+	"errors"
+)
+
+// Level is a QR code error correction level. Higher levels allow more of a
+// code to be damaged or obscured while it still scans, at the expense of a
+// larger code.
+type Level int
+
+// Error correction levels, recovering roughly 7%, 15%, 25% and 30% of the
+// code respectively.
+const (
+	L Level = iota
+	M
+	Q
+	H
+)
+
+// Version limits.
+const (
+	MinVersion = 1
+	MaxVersion = 40
+)
+
+// QuietZone is the width, in modules, of the blank border which must surround
+// a code for it to scan.
+const QuietZone = 4
+
+// Encoding errors.
+var (
+	ErrTooLong = errors.New("qr: data too long")
+	ErrLevel   = errors.New("qr: invalid error correction level")
+)
+
+// formatBits are the bits identifying each error correction level in the
+// format information.
+var formatBits = [4]int{1, 0, 3, 2}
+
+// Code is an encoded QR code.
+type Code struct {
+	// Size is the width and height of the code in modules, excluding the
+	// quiet zone.
+	Size int
+	// Version is the QR code version, between MinVersion and MaxVersion.
+	Version int
+
+	modules  []bool
+	function []bool
+}
+
+// Black returns true if the module at column x and row y is dark. Modules
+// outside the code are light.
+func (c *Code) Black(x, y int) bool {
+	if x < 0 || y < 0 || x >= c.Size || y >= c.Size {
+		return false
+	}
+	return c.modules[y*c.Size+x]
+}
+
+// Encode encodes text as a QR code at error correction level lvl, using the
+// smallest version which fits.
+func Encode(text string, lvl Level) (*Code, error) {
+	if lvl < L || lvl > H {
+		return nil, ErrLevel
+	}
+
+	data := []byte(text)
+	ver := MinVersion
+	for ; ver <= MaxVersion; ver++ {
+		if 4+countBits(ver)+8*len(data) <= dataCodewords(ver, lvl)*8 {
+			break
+		}
+	}
+	if ver > MaxVersion {
+		return nil, ErrTooLong
+	}
+
+	// Byte mode segment, then terminator and padding
+	var bb bitBuffer
+	bb.append(0x4, 4)
+	bb.append(len(data), countBits(ver))
+	for _, b := range data {
+		bb.append(int(b), 8)
+	}
+	capacity := dataCodewords(ver, lvl) * 8
+	bb.append(0, min(4, capacity-len(bb)))
+	bb.append(0, (8-len(bb)%8)%8)
+	for pad := 0xec; len(bb) < capacity; pad ^= 0xec ^ 0x11 {
+		bb.append(pad, 8)
+	}
+
+	c := &Code{
+		Size:     ver*4 + 17,
+		Version:  ver,
+		modules:  make([]bool, (ver*4+17)*(ver*4+17)),
+		function: make([]bool, (ver*4+17)*(ver*4+17)),
+	}
+	c.drawFunctionPatterns(lvl)
+	c.drawCodewords(addECC(bb.bytes(), ver, lvl))
+
+	// Choose the mask with the lowest penalty
+	best, bestScore := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		c.applyMask(mask)
+		c.drawFormatBits(lvl, mask)
+		if score := c.penalty(); bestScore < 0 || score < bestScore {
+			best, bestScore = mask, score
+		}
+		c.applyMask(mask)
+	}
+	c.applyMask(best)
+	c.drawFormatBits(lvl, best)
+
+	return c, nil
+}
+
+// countBits returns the width of the character count field for byte mode in
+// version ver.
+func countBits(ver int) int {
+	if ver < 10 {
+		return 8
+	}
+	return 16
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// bitBuffer is a sequence of bits, most significant first.
+type bitBuffer []bool
+
+func (b *bitBuffer) append(val, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*b = append(*b, (val>>i)&1 != 0)
+	}
+}
+
+func (b bitBuffer) bytes() []byte {
+	res := make([]byte, len(b)/8)
+	for i, bit := range b {
+		if bit {
+			res[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+	return res
+}
+
+// setFunction sets a function module, which is excluded from data placement
+// and masking.
+func (c *Code) setFunction(x, y int, dark bool) {
+	c.modules[y*c.Size+x] = dark
+	c.function[y*c.Size+x] = true
+}
+
+func (c *Code) drawFunctionPatterns(lvl Level) {
+	// Timing patterns
+	for i := 0; i < c.Size; i++ {
+		c.setFunction(6, i, i%2 == 0)
+		c.setFunction(i, 6, i%2 == 0)
+	}
+
+	// Finder patterns, which overwrite part of the timing patterns
+	c.drawFinder(3, 3)
+	c.drawFinder(c.Size-4, 3)
+	c.drawFinder(3, c.Size-4)
+
+	// Alignment patterns, except where they would overlap the finders
+	pos := alignmentPositions(c.Version)
+	for i := range pos {
+		for j := range pos {
+			last := len(pos) - 1
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			c.drawAlignment(pos[i], pos[j])
+		}
+	}
+
+	// Reserve the format areas, which are drawn once the mask is known
+	c.drawFormatBits(lvl, 0)
+	c.drawVersion()
+}
+
+func (c *Code) drawFinder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx < 0 || yy < 0 || xx >= c.Size || yy >= c.Size {
+				continue
+			}
+			dist := max(abs(dx), abs(dy))
+			c.setFunction(xx, yy, dist != 2 && dist != 4)
+		}
+	}
+}
+
+func (c *Code) drawAlignment(x, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			c.setFunction(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+		}
+	}
+}
+
+// alignmentPositions returns the row and column coordinates of the centres of
+// the alignment patterns in version ver.
+func alignmentPositions(ver int) []int {
+	if ver == 1 {
+		return nil
+	}
+
+	n := ver/7 + 2
+	step := (ver*4 + n*2 + 1) / (n*2 - 2) * 2
+	if ver == 32 {
+		step = 26
+	}
+
+	pos := make([]int, n)
+	pos[0] = 6
+	for i, p := n-1, ver*4+17-7; i >= 1; i, p = i-1, p-step {
+		pos[i] = p
+	}
+	return pos
+}
+
+// drawFormatBits draws both copies of the format information, which records
+// the error correction level and mask, along with the dark module.
+func (c *Code) drawFormatBits(lvl Level, mask int) {
+	data := formatBits[lvl]<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool {
+		return (bits>>i)&1 != 0
+	}
+
+	// Around the top left finder
+	for i := 0; i <= 5; i++ {
+		c.setFunction(8, i, bit(i))
+	}
+	c.setFunction(8, 7, bit(6))
+	c.setFunction(8, 8, bit(7))
+	c.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		c.setFunction(14-i, 8, bit(i))
+	}
+
+	// Split between the other two finders
+	for i := 0; i < 8; i++ {
+		c.setFunction(c.Size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		c.setFunction(8, c.Size-15+i, bit(i))
+	}
+	c.setFunction(8, c.Size-8, true)
+}
+
+// drawVersion draws both copies of the version information, which is only
+// present from version 7.
+func (c *Code) drawVersion() {
+	if c.Version < 7 {
+		return
+	}
+
+	rem := c.Version
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1f25)
+	}
+	bits := c.Version<<12 | rem
+
+	for i := 0; i < 18; i++ {
+		dark := (bits>>i)&1 != 0
+		a, b := c.Size-11+i%3, i/3
+		c.setFunction(a, b, dark)
+		c.setFunction(b, a, dark)
+	}
+}
+
+// drawCodewords places data in the zig-zag pattern of two module wide columns
+// running from the bottom right, skipping function modules.
+func (c *Code) drawCodewords(data []byte) {
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		// Skip the vertical timing pattern
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < c.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if (right+1)&2 == 0 {
+					y = c.Size - 1 - vert
+				}
+				if c.function[y*c.Size+x] || i >= len(data)*8 {
+					continue
+				}
+				c.modules[y*c.Size+x] = (data[i/8]>>(7-i%8))&1 != 0
+				i++
+			}
+		}
+	}
+}
+
+// applyMask inverts every data module selected by mask. Applying the same mask
+// twice undoes it.
+func (c *Code) applyMask(mask int) {
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if !c.function[y*c.Size+x] && masked(mask, x, y) {
+				c.modules[y*c.Size+x] = !c.modules[y*c.Size+x]
+			}
+		}
+	}
+}
+
+func masked(mask, x, y int) bool {
+	switch mask {
+	case 0:
+		return (x+y)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (x+y)%3 == 0
+	case 4:
+		return (x/3+y/2)%2 == 0
+	case 5:
+		return x*y%2+x*y%3 == 0
+	case 6:
+		return (x*y%2+x*y%3)%2 == 0
+	default:
+		return ((x+y)%2+x*y%3)%2 == 0
+	}
+}
+
+// penalty scores how hard the code may be to scan, using the rules from the
+// QR code specification. Lower is better.
+func (c *Code) penalty() int {
+	score, dark := 0, 0
+
+	line := func(get func(i int) bool) {
+		run := 0
+		var hist []bool
+		for i := 0; i < c.Size; i++ {
+			d := get(i)
+			if i > 0 && d == get(i-1) {
+				run++
+			} else {
+				run = 1
+			}
+			if run == 5 {
+				score += 3
+			} else if run > 5 {
+				score++
+			}
+			hist = append(hist, d)
+		}
+
+		// Patterns resembling a finder, with light space on one side
+		finder := []bool{true, false, true, true, true, false, true}
+		for i := 0; i+7 <= len(hist); i++ {
+			if !match(hist[i:i+7], finder) {
+				continue
+			}
+			if light(hist, i-4, i) || light(hist, i+7, i+11) {
+				score += 40
+			}
+		}
+	}
+
+	for y := 0; y < c.Size; y++ {
+		y := y
+		line(func(x int) bool { return c.Black(x, y) })
+	}
+	for x := 0; x < c.Size; x++ {
+		x := x
+		line(func(y int) bool { return c.Black(x, y) })
+	}
+
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			d := c.Black(x, y)
+			if d {
+				dark++
+			}
+			if x+1 < c.Size && y+1 < c.Size &&
+				d == c.Black(x+1, y) && d == c.Black(x, y+1) && d == c.Black(x+1, y+1) {
+				score += 3
+			}
+		}
+	}
+
+	total := c.Size * c.Size
+	score += abs(dark*100/total-50) / 5 * 10
+	return score
+}
+
+func match(a, b []bool) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// light returns true if every module of hist between from and to is light.
+// Modules beyond the edges of the code are light.
+func light(hist []bool, from, to int) bool {
+	for i := from; i < to; i++ {
+		if i >= 0 && i < len(hist) && hist[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func abs(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,239 @@
+package qr
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+// Data capacity in codewords of selected versions, from the specification.
+var capacityTests = []struct {
+	ver  int
+	lvl  Level
+	want int
+}{
+	{1, L, 19}, {1, M, 16}, {1, Q, 13}, {1, H, 9},
+	{2, M, 28}, {5, Q, 62}, {7, H, 66}, {10, M, 216},
+	{20, L, 861}, {27, L, 1468}, {27, Q, 808}, {27, H, 628}, {40, L, 2956}, {40, H, 1276},
+}
+
+func TestCapacity(t *testing.T) {
+	for _, tc := range capacityTests {
+		if got := dataCodewords(tc.ver, tc.lvl); got != tc.want {
+			t.Errorf("version %d level %d: got %d codewords, expected %d", tc.ver, tc.lvl, got, tc.want)
+		}
+	}
+}
+
+func TestFormatBits(t *testing.T) {
+	c := &Code{Size: 21, Version: 1, modules: make([]bool, 21*21), function: make([]bool, 21*21)}
+	c.drawFormatBits(L, 0)
+
+	// Format information for level L and mask 0, from the specification
+	const want = 0x77c4
+	if got := readFormat(c); got != want {
+		t.Errorf("got format %015b, expected %015b", got, want)
+	}
+}
+
+func TestVersionBits(t *testing.T) {
+	c := &Code{Size: 45, Version: 7, modules: make([]bool, 45*45), function: make([]bool, 45*45)}
+	c.drawVersion()
+
+	// Version information for version 7, from the specification
+	const want = 0x07c94
+	got, mirrored := 0, 0
+	for i := 0; i < 18; i++ {
+		if c.Black(c.Size-11+i%3, i/3) {
+			got |= 1 << i
+		}
+		if c.Black(i/3, c.Size-11+i%3) {
+			mirrored |= 1 << i
+		}
+	}
+	if got != want || mirrored != want {
+		t.Errorf("got version %018b and %018b, expected %018b", got, mirrored, want)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	for _, lvl := range []Level{L, M, Q, H} {
+		for _, n := range []int{0, 1, 14, 40, 100, 230, 500, 1000, 1270} {
+			text := strings.Repeat("https://example.com/join?pin=1234567890&", n/40+1)[:n]
+			c, err := Encode(text, lvl)
+			if err != nil {
+				t.Fatalf("encode %d bytes at level %d: %v", n, lvl, err)
+			}
+
+			if got := decode(t, c); got != text {
+				t.Errorf("version %d level %d: decoded %q, expected %q", c.Version, lvl, got, text)
+			}
+		}
+	}
+}
+
+func TestTooLong(t *testing.T) {
+	if _, err := Encode(strings.Repeat("x", 2954), L); err != ErrTooLong {
+		t.Errorf("got %v, expected %v", err, ErrTooLong)
+	}
+	if _, err := Encode("x", Level(4)); err != ErrLevel {
+		t.Errorf("got %v, expected %v", err, ErrLevel)
+	}
+}
+
+func TestRender(t *testing.T) {
+	c, err := Encode("https://example.com", M)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img := c.Image(4)
+	if w := img.Bounds().Dx(); w != (c.Size+QuietZone*2)*4 {
+		t.Errorf("image width %d, expected %d", w, (c.Size+QuietZone*2)*4)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := c.WriteSVG(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "<svg") {
+		t.Error("no svg element in output")
+	}
+}
+
+// readFormat reads the first copy of the format information from c.
+func readFormat(c *Code) int {
+	bits := 0
+	set := func(i, x, y int) {
+		if c.Black(x, y) {
+			bits |= 1 << i
+		}
+	}
+
+	for i := 0; i <= 5; i++ {
+		set(i, 8, i)
+	}
+	set(6, 8, 7)
+	set(7, 8, 8)
+	set(8, 7, 8)
+	for i := 9; i < 15; i++ {
+		set(i, 14-i, 8)
+	}
+	return bits
+}
+
+// decode reads back the text stored in c, checking the error correction of
+// every block along the way.
+func decode(t *testing.T, c *Code) string {
+	t.Helper()
+
+	// Format information is a BCH code, so must divide the generator
+	format := readFormat(c) ^ 0x5412
+	rem := format
+	for i := 14; i >= 10; i-- {
+		if rem&(1<<i) != 0 {
+			rem ^= 0x537 << (i - 10)
+		}
+	}
+	if rem != 0 {
+		t.Fatalf("version %d: corrupt format information %015b", c.Version, format)
+	}
+	lvl, mask := Level(0), format>>10&7
+	for i, b := range formatBits {
+		if b == format>>13 {
+			lvl = Level(i)
+		}
+	}
+
+	// Read the raw codewords back out of the data modules
+	var raw bitBuffer
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < c.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if (right+1)&2 == 0 {
+					y = c.Size - 1 - vert
+				}
+				if !c.function[y*c.Size+x] {
+					raw = append(raw, c.Black(x, y) != masked(mask, x, y))
+				}
+			}
+		}
+	}
+	if len(raw) != rawModules(c.Version) {
+		t.Fatalf("version %d: %d data modules, expected %d", c.Version, len(raw), rawModules(c.Version))
+	}
+	words := raw.bytes()
+
+	// Undo the interleaving
+	nblocks, ecc := eccBlocks[lvl][c.Version], eccPerBlock[lvl][c.Version]
+	nshort := nblocks - len(words)%nblocks
+	shortData := len(words)/nblocks - ecc
+	blocks := make([][]byte, nblocks)
+	k := 0
+	for i := 0; i <= shortData; i++ {
+		for j := range blocks {
+			if i == shortData && j < nshort {
+				continue
+			}
+			blocks[j] = append(blocks[j], words[k])
+			k++
+		}
+	}
+	for i := 0; i < ecc; i++ {
+		for j := range blocks {
+			blocks[j] = append(blocks[j], words[k])
+			k++
+		}
+	}
+
+	// Every syndrome of a valid Reed-Solomon codeword is zero
+	var data []byte
+	for j, b := range blocks {
+		root := byte(1)
+		for i := 0; i < ecc; i++ {
+			s := byte(0)
+			for _, w := range b {
+				s = gfMul(s, root) ^ w
+			}
+			if s != 0 {
+				t.Fatalf("version %d: block %d has bad syndrome %d", c.Version, j, i)
+			}
+			root = gfMul(root, 2)
+		}
+		data = append(data, b[:len(b)-ecc]...)
+	}
+
+	// Parse the byte mode segment
+	var bits bitBuffer
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+	read := func(n int) int {
+		v := 0
+		for i := 0; i < n; i++ {
+			v <<= 1
+			if bits[i] {
+				v |= 1
+			}
+		}
+		bits = bits[n:]
+		return v
+	}
+	if mode := read(4); mode != 0x4 {
+		t.Fatalf("version %d: got mode %d, expected byte mode", c.Version, mode)
+	}
+	n := read(countBits(c.Version))
+	text := make([]byte, n)
+	for i := range text {
+		text[i] = byte(read(8))
+	}
+	return string(text)
+}
@@ -0,0 +1,53 @@
+package qr
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"strings"
+)
+
+// Image renders the code as an image, with each module scale pixels wide and
+// surrounded by the quiet zone.
+func (c *Code) Image(scale int) image.Image {
+	if scale < 1 {
+		scale = 1
+	}
+
+	width := (c.Size + QuietZone*2) * scale
+	img := image.NewPaletted(image.Rect(0, 0, width, width),
+		color.Palette{color.White, color.Black})
+	for py := 0; py < width; py++ {
+		for px := 0; px < width; px++ {
+			if c.Black(px/scale-QuietZone, py/scale-QuietZone) {
+				img.SetColorIndex(px, py, 1)
+			}
+		}
+	}
+
+	return img
+}
+
+// WriteSVG renders the code to w as an SVG image, surrounded by the quiet
+// zone. The image has no fixed size, so scales to fit wherever it is placed.
+func (c *Code) WriteSVG(w io.Writer) error {
+	width := c.Size + QuietZone*2
+
+	var path strings.Builder
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.Black(x, y) {
+				fmt.Fprintf(&path, "M%d,%dh1v1h-1z", x+QuietZone, y+QuietZone)
+			}
+		}
+	}
+
+	_, err := fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<svg xmlns="http://www.w3.org/2000/svg" version="1.1" viewBox="0 0 %d %d" shape-rendering="crispEdges">
+<rect width="100%%" height="100%%" fill="#ffffff"/>
+<path d="%s" fill="#000000"/>
+</svg>
+`, width, width, path.String())
+	return err
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/ejv2/gahoot/game"
+	"github.com/ejv2/gahoot/game/results"
+
+	"github.com/gin-gonic/gin"
+)
+
+// resultsLink returns the path to the archived results of the game with the
+// given PIN and ID.
+func resultsLink(pin game.Pin, id string) string {
+	return "/results/" + pin.String() + "/" + id
+}
+
+// handleResults is the handler for "/results/{game PIN}/{game ID}", along
+// with the ".csv" and ".json" downloads of the same.
+//
+// Shows the archived results of a finished game. The game ID is secret, so
+// knowing the link is what grants access to the results.
+func handleResults(c *gin.Context) {
+	notFound := func() {
+		c.HTML(http.StatusNotFound, "error.gohtml", gin.H{
+			"Title":   "Results not found",
+			"Message": "These results do not exist or have expired.",
+		})
+		c.Abort()
+	}
+
+	if Archive == nil {
+		notFound()
+		return
+	}
+
+	file := c.Param("file")
+	ext := path.Ext(file)
+	res, err := Archive.Load(c.Param("pin"), strings.TrimSuffix(file, ext))
+	if err != nil {
+		if !errors.Is(err, results.ErrNotFound) && !errors.Is(err, results.ErrInvalid) {
+			log.Println("loading results failed:", err)
+		}
+		notFound()
+		return
+	}
+
+	name := "gahoot-" + res.PIN + "-" + res.Finished.Format("2006-01-02")
+	switch ext {
+	case "":
+		c.HTML(http.StatusOK, "results.gohtml", struct {
+			results.Result
+			Link string
+		}{res, c.Request.URL.Path})
+	case ".json":
+		c.Header("Content-Disposition", `attachment; filename="`+name+`.json"`)
+		c.JSON(http.StatusOK, res)
+	case ".csv":
+		c.Header("Content-Disposition", `attachment; filename="`+name+`.csv"`)
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		if err := res.WriteCSV(c.Writer); err != nil {
+			log.Println("writing results failed:", err)
+		}
+	default:
+		notFound()
+	}
+}